@@ -1,19 +1,30 @@
 package backendstructs
 
+import "time"
+
 type BackendCreationRequest struct {
 	Token             string      `validate:"required"`
 	Name              string      `validate:"required"`
 	Description       *string     `json:"description"`
 	Backend           string      `validate:"required"`
 	BackendParameters interface{} `json:"connectionDetails" validate:"required"`
+	RestartPolicy     *string     `json:"restartPolicy" validate:"omitempty,oneof=always on-failure never"`
 }
 
 type BackendLookupRequest struct {
-	Token       string  `validate:"required"`
-	BackendID   *uint   `json:"id"`
-	Name        *string `json:"name"`
-	Description *string `json:"description"`
-	Backend     *string `json:"backend"`
+	Token       string     `validate:"required"`
+	BackendID   *uint      `json:"id"`
+	Name        *string    `json:"name"`
+	Description *string    `json:"description"`
+	Backend     *string    `json:"backend"`
+	LogLevel    *string    `json:"logLevel"`
+	LogsSince   *time.Time `json:"logsSince"`
+	LogsBefore  *uint64    `json:"logsBefore"`
+	LogsLimit   *int       `json:"logsLimit" validate:"omitempty,min=1,max=1000"`
+}
+
+type AvailableBackendsRequest struct {
+	Token string `validate:"required"`
 }
 
 type BackendRemovalRequest struct {
@@ -21,18 +32,42 @@ type BackendRemovalRequest struct {
 	BackendID uint   `json:"id" validate:"required"`
 }
 
+type BackendResetRequest struct {
+	Token     string `validate:"required"`
+	BackendID uint   `json:"id" validate:"required"`
+}
+
+type BackendManifestReloadRequest struct {
+	Token string `validate:"required"`
+}
+
+type BackendLogStreamRequest struct {
+	Token     string  `validate:"required"`
+	BackendID uint    `json:"id" validate:"required"`
+	LogLevel  *string `json:"logLevel"`
+	After     *uint64 `json:"after"`
+}
+
 type ConnectionsRequest struct {
 	Token string `validate:"required" json:"token"`
 	Id    uint   `validate:"required" json:"id"`
 }
 
+type KillConnectionRequest struct {
+	Token      string `validate:"required" json:"token"`
+	Id         uint   `validate:"required" json:"id"`
+	ClientIP   string `validate:"required" json:"ip"`
+	ClientPort uint16 `validate:"required" json:"port"`
+}
+
 type ProxyCreationRequest struct {
 	Token           string  `validate:"required" json:"token"`
 	Name            string  `validate:"required" json:"name"`
 	Description     *string `json:"description"`
 	Protocol        string  `validate:"required" json:"protocol"`
-	SourceIP        string  `validate:"required" json:"sourceIP"`
-	SourcePort      uint16  `validate:"required" json:"sourcePort"`
+	SourceType      string  `validate:"omitempty,oneof=address unix" json:"sourceType"` // Defaults to "address"
+	SourceIP        string  `validate:"required" json:"sourceIP"`                       // IP address or hostname, or the socket path for Unix sockets
+	SourcePort      uint16  `json:"sourcePort"`                                         // Required, unless the source is a Unix socket
 	DestinationPort uint16  `validate:"required" json:"destinationPort"`
 	ProviderID      uint    `validate:"required" json:"providerID"`
 	AutoStart       *bool   `json:"autoStart"`
@@ -52,8 +87,9 @@ type ProxyLookupRequest struct {
 }
 
 type ProxyRemovalRequest struct {
-	Token string `validate:"required" json:"token"`
-	ID    uint   `validate:"required" json:"id"`
+	Token        string `validate:"required" json:"token"`
+	ID           uint   `validate:"required" json:"id"`
+	DrainSeconds uint16 `json:"drainSeconds"`
 }
 
 type ProxyStartRequest struct {
@@ -62,8 +98,9 @@ type ProxyStartRequest struct {
 }
 
 type ProxyStopRequest struct {
-	Token string `validate:"required" json:"token"`
-	ID    uint   `validate:"required" json:"id"`
+	Token        string `validate:"required" json:"token"`
+	ID           uint   `validate:"required" json:"id"`
+	DrainSeconds uint16 `json:"drainSeconds"`
 }
 
 type UserCreationRequest struct {
@@ -0,0 +1,92 @@
+package backendruntime
+
+import "sync"
+
+var (
+	// Guards everything below. The API handlers use these while manifest reloads and shutdowns are going on, so
+	// they're only ever touched through the functions below.
+	backendsLock      sync.Mutex
+	availableBackends []*Backend
+	runningBackends   = map[uint]*Runtime{}
+	// Unsubscribes the event logger for each running backend
+	stopLoggingEvents = map[uint]func(){}
+)
+
+// Gets the backends in the manifest. A reload swaps out the whole slice instead of changing it, so this can be
+// used without holding on to any lock.
+func GetAvailableBackends() []*Backend {
+	backendsLock.Lock()
+	defer backendsLock.Unlock()
+
+	return availableBackends
+}
+
+// Finds the backend in the manifest with the name, if there is one
+func GetAvailableBackend(name string) (*Backend, bool) {
+	for _, backend := range GetAvailableBackends() {
+		if backend.Name == name {
+			return backend, true
+		}
+	}
+
+	return nil, false
+}
+
+func setAvailableBackends(backends []*Backend) {
+	backendsLock.Lock()
+	defer backendsLock.Unlock()
+
+	availableBackends = backends
+}
+
+// Gets the runtime for the running backend with the ID
+func GetRunningBackend(backendID uint) (*Runtime, bool) {
+	backendsLock.Lock()
+	defer backendsLock.Unlock()
+
+	runtime, ok := runningBackends[backendID]
+	return runtime, ok
+}
+
+// Gets a copy of every running backend, by ID
+func GetRunningBackends() map[uint]*Runtime {
+	backendsLock.Lock()
+	defer backendsLock.Unlock()
+
+	backends := make(map[uint]*Runtime, len(runningBackends))
+
+	for backendID, runtime := range runningBackends {
+		backends[backendID] = runtime
+	}
+
+	return backends
+}
+
+// Keeps track of a backend's runtime once it has been started, and logs the events it sends
+func AddRunningBackend(backendID uint, runtime *Runtime) {
+	backendsLock.Lock()
+	defer backendsLock.Unlock()
+
+	if unsubscribe, ok := stopLoggingEvents[backendID]; ok {
+		unsubscribe()
+	}
+
+	events, unsubscribe := runtime.SubscribeToEvents()
+	go logEvents(backendID, events)
+
+	runningBackends[backendID] = runtime
+	stopLoggingEvents[backendID] = unsubscribe
+}
+
+// Stops keeping track of a backend's runtime. This doesn't stop the runtime itself.
+func RemoveRunningBackend(backendID uint) {
+	backendsLock.Lock()
+	defer backendsLock.Unlock()
+
+	if unsubscribe, ok := stopLoggingEvents[backendID]; ok {
+		unsubscribe()
+		delete(stopLoggingEvents, backendID)
+	}
+
+	delete(runningBackends, backendID)
+}
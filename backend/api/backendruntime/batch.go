@@ -0,0 +1,118 @@
+package backendruntime
+
+import (
+	"fmt"
+	"net"
+
+	"git.terah.dev/imterah/hermes/backend/commonbackend"
+)
+
+// Starts all of the proxies, in one round trip if the backend supports batching, and one at a time otherwise.
+// Returns one result per proxy, in the same order as proxies. A proxy failing to start isn't an error, and is reported
+// in its result instead.
+func (runtime *Runtime) StartProxies(proxies []*commonbackend.AddProxy) ([]*commonbackend.ProxyStatusResponse, error) {
+	return runtime.startProxies(proxies, runtime.ProcessCommand)
+}
+
+// Same as StartProxies, but talks to the socket directly. Only use this before the runtime starts reading from it (ex. in OnCrashCallback).
+func (runtime *Runtime) StartProxiesOnSocket(sock net.Conn, proxies []*commonbackend.AddProxy) ([]*commonbackend.ProxyStatusResponse, error) {
+	return runtime.startProxies(proxies, func(command interface{}) (interface{}, error) {
+		var commandType string
+
+		switch command.(type) {
+		case *commonbackend.AddProxies:
+			commandType = "addProxies"
+		case *commonbackend.AddProxy:
+			commandType = "addProxy"
+		}
+
+		commandMarshalled, err := commonbackend.Marshal(commandType, command)
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal message: %s", err.Error())
+		}
+
+		if _, err := sock.Write(commandMarshalled); err != nil {
+			return nil, fmt.Errorf("failed to write message: %s", err.Error())
+		}
+
+		_, response, err := runtime.ReadResponse(sock)
+		return response, err
+	})
+}
+
+func (runtime *Runtime) startProxies(proxies []*commonbackend.AddProxy, processCommand func(command interface{}) (interface{}, error)) ([]*commonbackend.ProxyStatusResponse, error) {
+	results := make([]*commonbackend.ProxyStatusResponse, len(proxies))
+
+	// Proxies the backend can't handle get failed here, so that they don't take the rest of the batch down with them.
+	supportedProxies := []*commonbackend.AddProxy{}
+	supportedProxyIndexes := []int{}
+
+	for proxyIndex, proxy := range proxies {
+		if commonbackend.IsUnixSocketPath(proxy.SourceIP) && !runtime.HasCapability(commonbackend.CapabilityUnixSockets) {
+			results[proxyIndex] = &commonbackend.ProxyStatusResponse{
+				Type:       "proxyStatusResponse",
+				SourceIP:   proxy.SourceIP,
+				SourcePort: proxy.SourcePort,
+				DestPort:   proxy.DestPort,
+				Protocol:   proxy.Protocol,
+				IsActive:   false,
+				Message:    "backend does not support Unix socket sources",
+			}
+
+			continue
+		}
+
+		supportedProxies = append(supportedProxies, proxy)
+		supportedProxyIndexes = append(supportedProxyIndexes, proxyIndex)
+	}
+
+	if len(supportedProxies) == 0 {
+		return results, nil
+	}
+
+	if runtime.HasCapability(commonbackend.CapabilityBatchProxies) {
+		response, err := processCommand(&commonbackend.AddProxies{
+			Type:    "addProxies",
+			Proxies: supportedProxies,
+		})
+
+		if err != nil {
+			return nil, err
+		}
+
+		proxiesStatusResponse, ok := response.(*commonbackend.ProxiesStatusResponse)
+
+		if !ok {
+			return nil, fmt.Errorf("got illegal response type: %T", response)
+		}
+
+		if len(proxiesStatusResponse.Results) != len(supportedProxies) {
+			return nil, fmt.Errorf("backend sent %d results for %d proxies", len(proxiesStatusResponse.Results), len(supportedProxies))
+		}
+
+		for resultIndex, result := range proxiesStatusResponse.Results {
+			results[supportedProxyIndexes[resultIndex]] = result
+		}
+
+		return results, nil
+	}
+
+	for resultIndex, proxy := range supportedProxies {
+		response, err := processCommand(proxy)
+
+		if err != nil {
+			return nil, err
+		}
+
+		proxyStatusResponse, ok := response.(*commonbackend.ProxyStatusResponse)
+
+		if !ok {
+			return nil, fmt.Errorf("got illegal response type: %T", response)
+		}
+
+		results[supportedProxyIndexes[resultIndex]] = proxyStatusResponse
+	}
+
+	return results, nil
+}
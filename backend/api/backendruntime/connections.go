@@ -0,0 +1,58 @@
+package backendruntime
+
+import (
+	"fmt"
+
+	"git.terah.dev/imterah/hermes/backend/commonbackend"
+)
+
+func connectionKey(connection *commonbackend.ProxyClientConnection) string {
+	return fmt.Sprintf("%s %s:%d -> %s:%d:%d", connection.Protocol, connection.ClientIP, connection.ClientPort, connection.SourceIP, connection.SourcePort, connection.DestPort)
+}
+
+// Keeps track of the clients connected to the backend's proxies, using the connection events the backend sends.
+// Stops once the events channel is closed.
+func (runtime *Runtime) trackConnections(events <-chan interface{}) {
+	for event := range events {
+		switch event := event.(type) {
+		case *commonbackend.ConnectionOpened:
+			runtime.connectionsLock.Lock()
+			runtime.connections[connectionKey(event.Connection)] = event.Connection
+			runtime.connectionsLock.Unlock()
+		case *commonbackend.ConnectionClosed:
+			runtime.connectionsLock.Lock()
+			delete(runtime.connections, connectionKey(event.Connection))
+			runtime.connectionsLock.Unlock()
+		}
+	}
+}
+
+// Forgets every tracked connection. A backend that has just connected hasn't got any clients yet, so this is done
+// whenever it (re)connects, in case we missed the close events from the last one.
+func (runtime *Runtime) clearConnections() {
+	runtime.connectionsLock.Lock()
+	defer runtime.connectionsLock.Unlock()
+
+	runtime.connections = map[string]*commonbackend.ProxyClientConnection{}
+}
+
+// Counts the clients connected to a proxy without asking the backend, going off of the connection events it has sent.
+// Returns false if the backend doesn't send connection events, as there's nothing to count then.
+func (runtime *Runtime) CountConnections(sourceIP string, sourcePort, destPort uint16, protocol string) (int, bool) {
+	if !runtime.HasCapability(commonbackend.CapabilityConnectionEvents) {
+		return 0, false
+	}
+
+	runtime.connectionsLock.Lock()
+	defer runtime.connectionsLock.Unlock()
+
+	connectionCount := 0
+
+	for _, connection := range runtime.connections {
+		if connection.SourceIP == sourceIP && connection.SourcePort == sourcePort && connection.DestPort == destPort && connection.Protocol == protocol {
+			connectionCount++
+		}
+	}
+
+	return connectionCount, true
+}
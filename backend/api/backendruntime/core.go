@@ -1,15 +1,71 @@
 package backendruntime
 
-import "os"
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	// How long a backend has to answer the hello handshake before we give up on it
+	handshakeTimeout = 10 * time.Second
+	// How often backends get pinged. A ping that isn't answered before the next one is due counts as missed
+	pingInterval = 5 * time.Second
+	// How many commands can be waiting to be sent to a backend before ProcessCommand blocks until there's room
+	commandQueueSize = 64
+	// Same as commandQueueSize, but for the priority queue. Only pings go through it, and only one is out at a time
+	priorityCommandQueueSize = 4
+)
 
 var (
-	AvailableBackends []*Backend
-	RunningBackends   map[uint]*Runtime
 	TempDir           string
 	isDevelopmentMode bool
+
+	// How many pings in a row a backend can miss before it's considered hung and gets restarted.
+	// Can be overridden with the HERMES_BACKEND_MAX_MISSED_PINGS environment variable.
+	maxMissedPings = 3
+
+	// How long ProcessCommand waits for a backend to respond before giving up with ErrCommandTimeout.
+	// Can be overridden (in seconds) with the HERMES_BACKEND_COMMAND_TIMEOUT environment variable.
+	DefaultCommandTimeout = 30 * time.Second
+
+	// How many times a backend can be restarted in the restart window before it's considered to be in a crash loop,
+	// and stops getting restarted. Can be overridden with the HERMES_BACKEND_MAX_RESTARTS environment variable.
+	maxRestartsInWindow = 5
+
+	// Can be overridden (in seconds) with the HERMES_BACKEND_RESTART_WINDOW environment variable.
+	restartWindow = 5 * time.Minute
+
+	// How long to wait before restarting a backend for the first time. This doubles with every restart in the restart window
+	initialRestartBackoff = 1 * time.Second
+	// Most time to wait before restarting a backend
+	maxRestartBackoff = 1 * time.Minute
+
+	// How many log lines are kept for each backend. Older lines are dropped once there's this many.
+	// Can be overridden with the HERMES_BACKEND_LOG_BUFFER_SIZE environment variable.
+	maxLogRecords = 1000
 )
 
 func init() {
-	RunningBackends = make(map[uint]*Runtime)
 	isDevelopmentMode = os.Getenv("HERMES_DEVELOPMENT_MODE") != ""
+
+	if missedPings, err := strconv.Atoi(os.Getenv("HERMES_BACKEND_MAX_MISSED_PINGS")); err == nil && missedPings > 0 {
+		maxMissedPings = missedPings
+	}
+
+	if commandTimeout, err := strconv.Atoi(os.Getenv("HERMES_BACKEND_COMMAND_TIMEOUT")); err == nil && commandTimeout > 0 {
+		DefaultCommandTimeout = time.Duration(commandTimeout) * time.Second
+	}
+
+	if maxRestarts, err := strconv.Atoi(os.Getenv("HERMES_BACKEND_MAX_RESTARTS")); err == nil && maxRestarts > 0 {
+		maxRestartsInWindow = maxRestarts
+	}
+
+	if window, err := strconv.Atoi(os.Getenv("HERMES_BACKEND_RESTART_WINDOW")); err == nil && window > 0 {
+		restartWindow = time.Duration(window) * time.Second
+	}
+
+	if logBufferSize, err := strconv.Atoi(os.Getenv("HERMES_BACKEND_LOG_BUFFER_SIZE")); err == nil && logBufferSize > 0 {
+		maxLogRecords = logBufferSize
+	}
 }
@@ -0,0 +1,77 @@
+package backendruntime
+
+import (
+	"git.terah.dev/imterah/hermes/backend/commonbackend"
+	"github.com/charmbracelet/log"
+)
+
+// How many events a subscriber can fall behind by before we start dropping events for it
+const eventSubscriberBufferSize = 64
+
+// Subscribes to the events (ex. *commonbackend.ConnectionOpened) the backend sends on its own.
+// Call the returned function to unsubscribe.
+func (runtime *Runtime) SubscribeToEvents() (<-chan interface{}, func()) {
+	eventChannel := make(chan interface{}, eventSubscriberBufferSize)
+
+	runtime.eventSubscribersLock.Lock()
+	runtime.eventSubscribers = append(runtime.eventSubscribers, eventChannel)
+	runtime.eventSubscribersLock.Unlock()
+
+	unsubscribe := func() {
+		runtime.eventSubscribersLock.Lock()
+		defer runtime.eventSubscribersLock.Unlock()
+
+		for subscriberIndex, subscriber := range runtime.eventSubscribers {
+			if subscriber == eventChannel {
+				runtime.eventSubscribers = append(runtime.eventSubscribers[:subscriberIndex], runtime.eventSubscribers[subscriberIndex+1:]...)
+				close(eventChannel)
+
+				return
+			}
+		}
+	}
+
+	return eventChannel, unsubscribe
+}
+
+// Logs the events a running backend sends, until the event channel is closed
+func logEvents(backendID uint, events <-chan interface{}) {
+	for event := range events {
+		switch event := event.(type) {
+		case *commonbackend.ConnectionOpened:
+			log.Debugf("Backend #%d: client %s:%d connected to %s:%d", backendID, event.Connection.ClientIP, event.Connection.ClientPort, event.Connection.SourceIP, event.Connection.SourcePort)
+		case *commonbackend.ConnectionClosed:
+			log.Debugf("Backend #%d: client %s:%d disconnected from %s:%d", backendID, event.Connection.ClientIP, event.Connection.ClientPort, event.Connection.SourceIP, event.Connection.SourcePort)
+		case *commonbackend.ProxyFailed:
+			log.Warnf("Backend #%d: proxy %s:%d -> remote:%d failed: %s", backendID, event.SourceIP, event.SourcePort, event.DestPort, event.Message)
+		case *commonbackend.UpstreamDisconnected:
+			log.Warnf("Backend #%d: lost upstream connection: %s", backendID, event.Message)
+		}
+	}
+}
+
+func isEvent(message interface{}) bool {
+	switch message.(type) {
+	case *commonbackend.ConnectionOpened, *commonbackend.ConnectionClosed, *commonbackend.ProxyFailed, *commonbackend.UpstreamDisconnected, *commonbackend.LogMessage:
+		return true
+	default:
+		return false
+	}
+}
+
+func (runtime *Runtime) publishEvent(event interface{}) {
+	if logMessage, ok := event.(*commonbackend.LogMessage); ok {
+		runtime.addLogMessage(logMessage)
+	}
+
+	runtime.eventSubscribersLock.Lock()
+	defer runtime.eventSubscribersLock.Unlock()
+
+	for _, subscriber := range runtime.eventSubscribers {
+		select {
+		case subscriber <- event:
+		default:
+			log.Debugf("Dropping event for slow subscriber: %T", event)
+		}
+	}
+}
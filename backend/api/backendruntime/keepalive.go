@@ -0,0 +1,215 @@
+package backendruntime
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"time"
+
+	"git.terah.dev/imterah/hermes/backend/commonbackend"
+	"github.com/charmbracelet/log"
+)
+
+var errPingTimeout = fmt.Errorf("timed out waiting for pong")
+
+// Gets the round trip time of the last answered ping, and how many pings in a row have gone unanswered since.
+// The latency is zero if the backend hasn't answered a ping yet.
+func (runtime *Runtime) GetLatency() (time.Duration, int) {
+	runtime.keepaliveLock.Lock()
+	defer runtime.keepaliveLock.Unlock()
+
+	return runtime.latency, runtime.missedPings
+}
+
+func (runtime *Runtime) ping() (time.Duration, error) {
+	ping := &commonbackend.Ping{
+		Type:      "ping",
+		Nonce:     rand.Uint64(),
+		Timestamp: time.Now(),
+	}
+
+	response, err := runtime.processPriorityCommand(ping, pingInterval)
+
+	if err == ErrCommandTimeout {
+		return 0, errPingTimeout
+	} else if err != nil {
+		return 0, err
+	}
+
+	pong, ok := response.(*commonbackend.Pong)
+
+	if !ok {
+		return 0, fmt.Errorf("got illegal response type: %T", response)
+	}
+
+	if pong.Nonce != ping.Nonce {
+		return 0, fmt.Errorf("pong nonce does not match (expected %d, got %d)", ping.Nonce, pong.Nonce)
+	}
+
+	return time.Since(pong.Timestamp), nil
+}
+
+// Pings the backend until the connection closes. If the backend misses too many pings in a row, it's considered hung, and gets killed
+// (which makes the runtime restart it). Only pings that time out count as missed, and only while the runtime is running.
+// Anything else (ex. the backend restarting) isn't the backend hanging.
+func (runtime *Runtime) pingKeepalive(sock net.Conn, connectionClosed chan struct{}) {
+	log.Debug("Setting up Hermes ping keepalive Goroutine")
+
+	runtime.keepaliveLock.Lock()
+	runtime.latency = 0
+	runtime.missedPings = 0
+	runtime.keepaliveLock.Unlock()
+
+	for {
+		select {
+		case <-connectionClosed:
+			return
+		default:
+		}
+
+		if !runtime.isRuntimeRunning.Load() {
+			return
+		}
+
+		pingSentAt := time.Now()
+
+		if state, _ := runtime.GetState(); state == StateRunning {
+			runtime.checkPing(sock)
+		}
+
+		select {
+		case <-connectionClosed:
+			return
+		case <-time.After(time.Until(pingSentAt.Add(pingInterval))):
+		}
+	}
+}
+
+// Pings the backend once, and kills it if it has missed too many pings in a row
+func (runtime *Runtime) checkPing(sock net.Conn) {
+	latency, err := runtime.ping()
+
+	if err != nil && err != errPingTimeout {
+		log.Debugf("Failed to ping backend (not counting it as missed): %s", err.Error())
+		return
+	}
+
+	// The backend can get stopped or restarted while we're waiting on it, and then the ping was never going to be answered
+	if state, _ := runtime.GetState(); err != nil && state != StateRunning {
+		return
+	}
+
+	runtime.keepaliveLock.Lock()
+
+	if err != nil {
+		runtime.missedPings++
+	} else {
+		runtime.latency = latency
+		runtime.missedPings = 0
+	}
+
+	missedPings := runtime.missedPings
+	runtime.keepaliveLock.Unlock()
+
+	if err == nil {
+		return
+	}
+
+	log.Warnf("Backend missed a ping (%d/%d): %s", missedPings, maxMissedPings, err.Error())
+
+	if missedPings < maxMissedPings {
+		return
+	}
+
+	log.Errorf("Backend missed %d pings in a row, and is considered hung. Restarting it...", missedPings)
+
+	runtime.restartLock.Lock()
+	process := runtime.currentProcess
+	runtime.restartLock.Unlock()
+
+	if process != nil && process.Cancel != nil {
+		if err := process.Cancel(); err != nil {
+			log.Warnf("Failed to kill hung backend: %s", err.Error())
+		}
+	}
+
+	// Closing the socket ends the keepalive along with the rest of the connection
+	if err := sock.Close(); err != nil {
+		log.Debugf("Failed to close socket: %s", err.Error())
+	}
+}
+
+// Keepalive for backends that don't support pings. Asking for the backend status is a "good-enough" keepalive system.
+// Plus, it provides useful telemetry.
+func (runtime *Runtime) statusKeepalive(sock net.Conn, connectionClosed chan struct{}) {
+	log.Debug("Setting up Hermes status keepalive Goroutine")
+	hasFailedBackendRunningCheckAlready := false
+
+	for {
+		select {
+		case <-connectionClosed:
+			return
+		default:
+		}
+
+		if !runtime.isRuntimeRunning.Load() {
+			return
+		}
+
+		// To be safe here, we have to use the proper (yet annoying) facilities to prevent cross-talk, since we're in
+		// a goroutine, and can't talk directly. This actually has benefits, as the OuterLoop should exit on its own, if we
+		// encounter a critical error.
+		statusResponse, err := runtime.ProcessCommand(&commonbackend.BackendStatusRequest{
+			Type: "backendStatusRequest",
+		})
+
+		if err != nil {
+			log.Warnf("Failed to get response for backend (in backend runtime keep alive): %s", err.Error())
+			log.Debugf("Attempting to close socket...")
+			err := sock.Close()
+
+			if err != nil {
+				log.Debugf("Failed to close socket: %s", err.Error())
+			}
+
+			// Closing the socket ends the keepalive once the response reader notices, so wait for that instead of
+			// asking again straight away
+			select {
+			case <-connectionClosed:
+				return
+			case <-time.After(pingInterval):
+			}
+
+			continue
+		}
+
+		switch responseMessage := statusResponse.(type) {
+		case *commonbackend.BackendStatusResponse:
+			if !responseMessage.IsRunning {
+				if hasFailedBackendRunningCheckAlready {
+					if responseMessage.Message != "" {
+						log.Warnf("Backend (in backend keepalive) is up but not active: %s", responseMessage.Message)
+					} else {
+						log.Warnf("Backend (in backend keepalive) is up but not active")
+					}
+				}
+
+				hasFailedBackendRunningCheckAlready = true
+			}
+		default:
+			log.Errorf("Got illegal response type for backend (in backend keepalive): %T", responseMessage)
+			log.Debugf("Attempting to close socket...")
+			err := sock.Close()
+
+			if err != nil {
+				log.Debugf("Failed to close socket: %s", err.Error())
+			}
+		}
+
+		select {
+		case <-connectionClosed:
+			return
+		case <-time.After(pingInterval):
+		}
+	}
+}
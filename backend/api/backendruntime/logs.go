@@ -0,0 +1,178 @@
+package backendruntime
+
+import (
+	"time"
+
+	"git.terah.dev/imterah/hermes/backend/commonbackend"
+	"github.com/charmbracelet/log"
+)
+
+const (
+	// Where a log line came from
+	LogStreamStdout = "stdout"
+	LogStreamStderr = "stderr"
+	// Log messages the backend forwarded over the socket
+	LogStreamSocket = "socket"
+)
+
+// How many log lines a subscriber can fall behind by before we start dropping lines for it
+const logSubscriberBufferSize = 256
+
+// Filters logs fetched with GetLogs
+type LogQuery struct {
+	// Only get logs at or above this level. Lines without a level are treated as info.
+	MinimumLevel log.Level
+	// Only get logs logged at or after this time, if it isn't zero
+	Since time.Time
+	// Only get logs with an ID lower than this, if it isn't zero. Used to page back through the logs.
+	Before uint64
+	// Most logs to get. If zero, there is no limit.
+	Limit int
+}
+
+// Checks if a log matches the query's level and time filters. Useful for filtering the logs from SubscribeToLogs.
+func (query *LogQuery) Matches(record *LogRecord) bool {
+	level := log.InfoLevel
+
+	if record.Level != "" {
+		if parsedLevel, err := log.ParseLevel(record.Level); err == nil {
+			level = parsedLevel
+		}
+	}
+
+	return level >= query.MinimumLevel && !record.Time.Before(query.Since)
+}
+
+// Adds a log line to the ring buffer, overwriting the oldest one if it's full, and sends it to any log subscribers.
+func (runtime *Runtime) addLog(record *LogRecord) {
+	runtime.logsLock.Lock()
+	defer runtime.logsLock.Unlock()
+
+	if runtime.logs == nil {
+		runtime.logs = make([]*LogRecord, maxLogRecords)
+	}
+
+	runtime.lastLogID++
+	record.ID = runtime.lastLogID
+	record.Generation = runtime.generation
+
+	runtime.logs[runtime.logsStart] = record
+	runtime.logsStart = (runtime.logsStart + 1) % len(runtime.logs)
+
+	if runtime.logsCount < len(runtime.logs) {
+		runtime.logsCount++
+	}
+
+	for _, subscriber := range runtime.logSubscribers {
+		select {
+		case subscriber <- record:
+		default:
+			log.Debugf("Dropping log line for slow subscriber")
+		}
+	}
+}
+
+// Gets the log at the given position, counting from the oldest log in the buffer. Must be called with logsLock held.
+func (runtime *Runtime) logAt(position int) *LogRecord {
+	return runtime.logs[(runtime.logsStart-runtime.logsCount+position+len(runtime.logs))%len(runtime.logs)]
+}
+
+func (runtime *Runtime) addLogMessage(logMessage *commonbackend.LogMessage) {
+	fields := make(map[string]string, len(logMessage.Fields))
+
+	for _, field := range logMessage.Fields {
+		fields[field.Key] = field.Value
+	}
+
+	if isDevelopmentMode {
+		log.Debugf("spawned backend logs: [%s] %s", logMessage.Level, logMessage.Message)
+	}
+
+	runtime.addLog(&LogRecord{
+		Time:    logMessage.Time,
+		Level:   logMessage.Level,
+		Message: logMessage.Message,
+		Fields:  fields,
+		Stream:  LogStreamSocket,
+	})
+}
+
+// Gets the newest logs matching the query, oldest first. Also returns whether there are older matching logs that
+// didn't fit in the limit (in which case, they can be fetched by setting Before to the ID of the first log returned).
+func (runtime *Runtime) GetLogs(query LogQuery) ([]*LogRecord, bool) {
+	runtime.logsLock.Lock()
+	defer runtime.logsLock.Unlock()
+
+	logs := []*LogRecord{}
+	hasOlderLogs := false
+
+	// We walk backwards from the newest log, so that we get the newest page of logs
+	for position := runtime.logsCount - 1; position >= 0; position-- {
+		record := runtime.logAt(position)
+
+		if query.Before != 0 && record.ID >= query.Before {
+			continue
+		}
+
+		if !query.Matches(record) {
+			continue
+		}
+
+		if query.Limit > 0 && len(logs) >= query.Limit {
+			hasOlderLogs = true
+			break
+		}
+
+		logs = append(logs, record)
+	}
+
+	for left, right := 0, len(logs)-1; left < right; left, right = left+1, right-1 {
+		logs[left], logs[right] = logs[right], logs[left]
+	}
+
+	return logs, hasOlderLogs
+}
+
+// Gets the logs matching the query that are newer than the log with the given ID, oldest first. Used to catch up
+// on the logs that were missed (ex. when resuming a log stream). The query's Before and Limit are ignored.
+func (runtime *Runtime) GetLogsAfter(afterID uint64, query LogQuery) []*LogRecord {
+	runtime.logsLock.Lock()
+	defer runtime.logsLock.Unlock()
+
+	logs := []*LogRecord{}
+
+	for position := 0; position < runtime.logsCount; position++ {
+		record := runtime.logAt(position)
+
+		if record.ID > afterID && query.Matches(record) {
+			logs = append(logs, record)
+		}
+	}
+
+	return logs
+}
+
+// Subscribes to the logs the backend writes from now on. Call the returned function to unsubscribe.
+func (runtime *Runtime) SubscribeToLogs() (<-chan *LogRecord, func()) {
+	logChannel := make(chan *LogRecord, logSubscriberBufferSize)
+
+	runtime.logsLock.Lock()
+	runtime.logSubscribers = append(runtime.logSubscribers, logChannel)
+	runtime.logsLock.Unlock()
+
+	unsubscribe := func() {
+		runtime.logsLock.Lock()
+		defer runtime.logsLock.Unlock()
+
+		for subscriberIndex, subscriber := range runtime.logSubscribers {
+			if subscriber == logChannel {
+				runtime.logSubscribers = append(runtime.logSubscribers[:subscriberIndex], runtime.logSubscribers[subscriberIndex+1:]...)
+				close(logChannel)
+
+				return
+			}
+		}
+	}
+
+	return logChannel, unsubscribe
+}
@@ -0,0 +1,228 @@
+package backendruntime
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"git.terah.dev/imterah/hermes/backend/commonbackend"
+	"github.com/go-playground/validator/v10"
+)
+
+// Loads the backend manifest (ex. backends.json), and checks it for mistakes. Relative paths in it are resolved
+// against the directory it's in.
+func LoadManifest(manifestPath string) ([]*Backend, error) {
+	manifest, err := os.ReadFile(manifestPath)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backend manifest: %s", err.Error())
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(manifest))
+	decoder.DisallowUnknownFields()
+
+	backends := []*Backend{}
+
+	if err := decoder.Decode(&backends); err != nil {
+		return nil, fmt.Errorf("failed to parse backend manifest: %s", describeJSONError(manifest, decoder.InputOffset(), err))
+	}
+
+	manifestDirectory := filepath.Dir(manifestPath)
+	backendNames := map[string]bool{}
+
+	for backendIndex, backend := range backends {
+		if err := backend.prepare(manifestDirectory); err != nil {
+			if backend.Name == "" {
+				return nil, fmt.Errorf("invalid backend #%d in manifest: %s", backendIndex+1, err.Error())
+			}
+
+			return nil, fmt.Errorf("invalid backend '%s' in manifest: %s", backend.Name, err.Error())
+		}
+
+		if backendNames[backend.Name] {
+			return nil, fmt.Errorf("backend '%s' is in the manifest more than once", backend.Name)
+		}
+
+		backendNames[backend.Name] = true
+	}
+
+	return backends, nil
+}
+
+// Adds where in the manifest a JSON error happened, since the decoder only gives us a byte offset. decoderOffset is
+// used for errors that don't have an offset of their own.
+func describeJSONError(manifest []byte, decoderOffset int64, err error) string {
+	var syntaxError *json.SyntaxError
+	var typeError *json.UnmarshalTypeError
+
+	if errors.As(err, &syntaxError) {
+		return fmt.Sprintf("%s (line %d)", err.Error(), lineAt(manifest, syntaxError.Offset))
+	} else if errors.As(err, &typeError) {
+		if typeError.Field == "" {
+			return fmt.Sprintf("manifest should be a list of backends (got %s)", typeError.Value)
+		}
+
+		// Fields of backends are prefixed with the backend's index (ex. 0.args)
+		field := typeError.Field
+
+		if _, fieldPath, ok := strings.Cut(field, "."); ok {
+			field = fieldPath
+		}
+
+		return fmt.Sprintf("'%s' should be a %s, not a %s (line %d)", field, typeError.Type.String(), typeError.Value, lineAt(manifest, typeError.Offset))
+	} else if err == io.EOF {
+		return "manifest is empty"
+	} else if err == io.ErrUnexpectedEOF {
+		return "manifest ends unexpectedly"
+	}
+
+	return fmt.Sprintf("%s (line %d)", strings.TrimPrefix(err.Error(), "json: "), lineAt(manifest, decoderOffset))
+}
+
+func lineAt(manifest []byte, offset int64) int {
+	offset = min(offset, int64(len(manifest)))
+	return bytes.Count(manifest[:offset], []byte("\n")) + 1
+}
+
+// Validates the backend's manifest entry, and resolves its relative paths
+func (backend *Backend) prepare(manifestDirectory string) error {
+	validate := validator.New()
+
+	// Errors should use the names from the manifest, not the ones from the struct
+	validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+
+		if name == "" || name == "-" {
+			return field.Name
+		}
+
+		return name
+	})
+
+	if err := validate.Struct(backend); err != nil {
+		var validationErrors validator.ValidationErrors
+
+		if !errors.As(err, &validationErrors) {
+			return err
+		}
+
+		messages := make([]string, len(validationErrors))
+
+		for errorIndex, validationError := range validationErrors {
+			messages[errorIndex] = describeValidationError(validationError)
+		}
+
+		return errors.New(strings.Join(messages, ", "))
+	}
+
+	if !filepath.IsAbs(backend.Path) {
+		backend.Path = filepath.Join(manifestDirectory, backend.Path)
+	}
+
+	if backend.MinimumAPIVersion > commonbackend.ProtocolVersion {
+		return fmt.Errorf("backend needs API version %d or newer, but this API is version %d", backend.MinimumAPIVersion, commonbackend.ProtocolVersion)
+	}
+
+	for name := range backend.Environment {
+		if name == "" || strings.Contains(name, "=") {
+			return fmt.Errorf("invalid environment variable name: '%s'", name)
+		}
+
+		if isReservedEnvironmentVariable(name) {
+			return fmt.Errorf("environment variable is reserved: %s", name)
+		}
+	}
+
+	if backend.Sandbox != nil {
+		if err := backend.Sandbox.Prepare(manifestDirectory); err != nil {
+			return fmt.Errorf("invalid sandbox: %s", err.Error())
+		}
+	}
+
+	return nil
+}
+
+func describeValidationError(validationError validator.FieldError) string {
+	field := validationError.Namespace()
+
+	if _, fieldPath, ok := strings.Cut(field, "."); ok {
+		field = fieldPath
+	}
+
+	switch validationError.Tag() {
+	case "required":
+		return fmt.Sprintf("%s is required", field)
+	case "len":
+		return fmt.Sprintf("%s must be %s characters long", field, validationError.Param())
+	case "hexadecimal":
+		return fmt.Sprintf("%s must be hexadecimal", field)
+	case "oneof":
+		return fmt.Sprintf("%s must be one of: %s (got '%v')", field, validationError.Param(), validationError.Value())
+	default:
+		return fmt.Sprintf("%s failed the '%s' check", field, validationError.Tag())
+	}
+}
+
+// Checks if the environment variable is one the API sets itself when starting a backend
+func isReservedEnvironmentVariable(name string) bool {
+	return name == "HERMES_API_SOCK" || name == "HERMES_LOG_LEVEL" || name == "HERMES_MAX_FRAME_SIZE"
+}
+
+// Gets the manifest's environment variables as NAME=value pairs, sorted by name so that the order is stable
+func (backend *Backend) environment() []string {
+	environment := make([]string, 0, len(backend.Environment))
+
+	for name, value := range backend.Environment {
+		environment = append(environment, fmt.Sprintf("%s=%s", name, value))
+	}
+
+	sort.Strings(environment)
+	return environment
+}
+
+// Gets the SHA-256 hash (in hex) of the file at the path
+func fileChecksum(path string) (string, error) {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return "", fmt.Errorf("failed to open backend to verify it: %s", err.Error())
+	}
+
+	defer file.Close()
+
+	hash := sha256.New()
+
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", fmt.Errorf("failed to read backend to verify it: %s", err.Error())
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Checks that the file at the path has the expected SHA-256 hash (in hex)
+func verifyChecksum(path, expectedHash string) error {
+	actualHash, err := fileChecksum(path)
+
+	if err != nil {
+		return err
+	}
+
+	return compareChecksum(actualHash, expectedHash)
+}
+
+func compareChecksum(actualHash, expectedHash string) error {
+	if !strings.EqualFold(actualHash, expectedHash) {
+		return fmt.Errorf("backend does not match its checksum (expected %s, got %s)", strings.ToLower(expectedHash), actualHash)
+	}
+
+	return nil
+}
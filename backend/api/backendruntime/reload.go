@@ -0,0 +1,134 @@
+package backendruntime
+
+import (
+	"slices"
+	"sync"
+
+	"github.com/charmbracelet/log"
+)
+
+var (
+	// Where the backend manifest was loaded from, so that it can be reloaded
+	manifestPath string
+	// Only one reload can run at once
+	reloadLock sync.Mutex
+)
+
+// What changed when the backend manifest was reloaded
+type ReloadResult struct {
+	// Backend types that were added to the manifest
+	Added []string `json:"added"`
+	// Backend types that were removed from the manifest. New backends can't be created with these, but running
+	// backends using them are left running.
+	Removed []string `json:"removed"`
+	// IDs of running backends that were restarted, as their executable (or its path) changed
+	Restarted []uint `json:"restarted"`
+}
+
+// Reloads the backend manifest from where it was first loaded from. Running backends are only restarted if their
+// executable's path or contents changed. Anything else that changed for them (ex. args) applies the next time they
+// start. If the manifest can't be loaded, nothing is changed.
+func ReloadManifest() (*ReloadResult, error) {
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+
+	backends, err := LoadManifest(manifestPath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ReloadResult{
+		Added:     []string{},
+		Removed:   []string{},
+		Restarted: []uint{},
+	}
+
+	newBackends := map[string]*Backend{}
+
+	for _, backend := range backends {
+		newBackends[backend.Name] = backend
+	}
+
+	oldBackends := map[string]*Backend{}
+
+	for _, backend := range GetAvailableBackends() {
+		oldBackends[backend.Name] = backend
+
+		if newBackends[backend.Name] == nil {
+			result.Removed = append(result.Removed, backend.Name)
+		}
+	}
+
+	for _, backend := range backends {
+		if oldBackends[backend.Name] == nil {
+			result.Added = append(result.Added, backend.Name)
+		}
+	}
+
+	for backendID, runtime := range GetRunningBackends() {
+		if runtime.manifest == nil {
+			continue
+		}
+
+		backend, ok := newBackends[runtime.manifest.Name]
+
+		if !ok {
+			log.Warnf("Backend #%d uses the backend type '%s', which was removed from the manifest. It will keep running until it's removed", backendID, runtime.manifest.Name)
+			continue
+		}
+
+		restartNeeded, err := runtime.needsRestartFor(backend)
+
+		if err != nil {
+			log.Errorf("Not restarting backend #%d with the new manifest: %s", backendID, err.Error())
+			continue
+		}
+
+		if !restartNeeded {
+			runtime.restartLock.Lock()
+			runtime.useManifest(backend)
+			runtime.restartLock.Unlock()
+
+			continue
+		}
+
+		log.Infof("Executable for backend #%d changed. Restarting it...", backendID)
+
+		if err := runtime.restartWithManifest(backend); err != nil {
+			log.Errorf("Failed to restart backend #%d: %s", backendID, err.Error())
+			continue
+		}
+
+		result.Restarted = append(result.Restarted, backendID)
+	}
+
+	slices.Sort(result.Restarted)
+	setAvailableBackends(backends)
+
+	log.Infof("Reloaded backend manifest (%d added, %d removed, %d restarted)", len(result.Added), len(result.Removed), len(result.Restarted))
+
+	return result, nil
+}
+
+// Checks whether the runtime's executable (or its path) is different in the new manifest entry. Errors if the new
+// executable can't be used (ex. it doesn't exist, or doesn't match its checksum).
+func (runtime *Runtime) needsRestartFor(backend *Backend) (bool, error) {
+	runtime.restartLock.Lock()
+	processPath, processHash := runtime.ProcessPath, runtime.processHash
+	runtime.restartLock.Unlock()
+
+	newHash, err := fileChecksum(backend.Path)
+
+	if err != nil {
+		return false, err
+	}
+
+	if backend.SHA256 != "" {
+		if err := compareChecksum(newHash, backend.SHA256); err != nil {
+			return false, err
+		}
+	}
+
+	return processPath != backend.Path || processHash != newHash, nil
+}
@@ -0,0 +1,255 @@
+package backendruntime
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// Decides whether a backend process gets restarted after it exits
+type RestartPolicy string
+
+const (
+	// Restart the backend whenever it exits. This is the default.
+	RestartAlways RestartPolicy = "always"
+	// Only restart the backend if it exits with an error (or gets killed)
+	RestartOnFailure RestartPolicy = "on-failure"
+	// Never restart the backend
+	RestartNever RestartPolicy = "never"
+)
+
+// What a backend runtime is currently doing
+type RuntimeState string
+
+const (
+	StateRunning    RuntimeState = "running"
+	StateRestarting RuntimeState = "restarting"
+	// The backend exited, and its restart policy doesn't allow restarting it
+	StateExited RuntimeState = "exited"
+	// The backend restarted too many times in a short period, and won't be restarted until it's reset
+	StateCrashLoop RuntimeState = "crashloop"
+	StateStopped   RuntimeState = "stopped"
+)
+
+var (
+	// Returned by ProcessCommand while the backend is in a crash loop
+	ErrBackendCrashLoop = fmt.Errorf("backend is in a crash loop")
+	// Returned by ProcessCommand while the backend has exited, and isn't going to be restarted
+	ErrBackendExited = fmt.Errorf("backend has exited, and its restart policy does not allow restarting it")
+)
+
+// Parses a restart policy. An empty policy is treated as RestartAlways.
+func ParseRestartPolicy(policy string) (RestartPolicy, error) {
+	switch RestartPolicy(policy) {
+	case "":
+		return RestartAlways, nil
+	case RestartAlways, RestartOnFailure, RestartNever:
+		return RestartPolicy(policy), nil
+	default:
+		return "", fmt.Errorf("unknown restart policy: %s", policy)
+	}
+}
+
+func (policy RestartPolicy) shouldRestart(exitedCleanly bool) bool {
+	switch policy {
+	case RestartNever:
+		return false
+	case RestartOnFailure:
+		return !exitedCleanly
+	default:
+		return true
+	}
+}
+
+// Gets the state the runtime is in, and how many times the backend has been restarted since it was started (or last reset)
+func (runtime *Runtime) GetState() (RuntimeState, int) {
+	runtime.restartLock.Lock()
+	defer runtime.restartLock.Unlock()
+
+	return runtime.state, runtime.restartCount
+}
+
+func (runtime *Runtime) setState(state RuntimeState) {
+	runtime.restartLock.Lock()
+	defer runtime.restartLock.Unlock()
+
+	runtime.state = state
+}
+
+// Gets the error commands should fail with, if the backend isn't going to come back on its own
+func (runtime *Runtime) unavailableError() error {
+	state, _ := runtime.GetState()
+	return stateError(state)
+}
+
+func stateError(state RuntimeState) error {
+	switch state {
+	case StateCrashLoop:
+		return ErrBackendCrashLoop
+	case StateExited:
+		return ErrBackendExited
+	default:
+		return nil
+	}
+}
+
+// Records a restart, and figures out how long to wait before doing it. The wait doubles with every restart in the
+// restart window. If there's been too many restarts in the window, false is returned, as the backend is crash looping.
+func (runtime *Runtime) nextRestartDelay() (time.Duration, bool) {
+	runtime.restartLock.Lock()
+	defer runtime.restartLock.Unlock()
+
+	recentRestarts := []time.Time{}
+
+	for _, restartedAt := range runtime.recentRestarts {
+		if time.Since(restartedAt) < restartWindow {
+			recentRestarts = append(recentRestarts, restartedAt)
+		}
+	}
+
+	runtime.recentRestarts = recentRestarts
+
+	if len(recentRestarts) >= maxRestartsInWindow {
+		return 0, false
+	}
+
+	delay := min(initialRestartBackoff<<len(recentRestarts), maxRestartBackoff)
+
+	runtime.recentRestarts = append(runtime.recentRestarts, time.Now())
+	runtime.restartCount++
+
+	return delay, true
+}
+
+// Clears the restart history, and if the backend is in a crash loop (or has exited), starts it back up.
+func (runtime *Runtime) Reset() error {
+	if !runtime.isRuntimeRunning.Load() {
+		return fmt.Errorf("runtime not running")
+	}
+
+	runtime.restartLock.Lock()
+	defer runtime.restartLock.Unlock()
+
+	runtime.recentRestarts = nil
+	runtime.restartCount = 0
+
+	if runtime.state == StateCrashLoop || runtime.state == StateExited {
+		runtime.state = StateRestarting
+
+		select {
+		case runtime.resetNotification <- struct{}{}:
+		default:
+		}
+	}
+
+	return nil
+}
+
+// Waits until the backend should be started again after it exits. Returns false if the runtime was stopped instead.
+func (runtime *Runtime) waitForRestart(exitedCleanly bool) bool {
+	processPath := runtime.processPath()
+
+	if !runtime.RestartPolicy.shouldRestart(exitedCleanly) {
+		log.Warnf("Backend '%s' exited, and its restart policy (%s) does not allow restarting it. Waiting for it to be reset...", processPath, runtime.RestartPolicy)
+		return runtime.waitForReset(StateExited)
+	}
+
+	delay, ok := runtime.nextRestartDelay()
+
+	if !ok {
+		log.Errorf("Backend '%s' restarted %d times in the last %s, and is in a crash loop. Waiting for it to be reset...", processPath, maxRestartsInWindow, restartWindow)
+		return runtime.waitForReset(StateCrashLoop)
+	}
+
+	runtime.setState(StateRestarting)
+	log.Debugf("Sleeping %s, and then restarting process", delay)
+
+	select {
+	case <-time.After(delay):
+		return true
+	case <-runtime.stopNotification:
+		return false
+	}
+}
+
+func (runtime *Runtime) waitForReset(state RuntimeState) bool {
+	runtime.setState(state)
+
+	// Nothing is going to answer these until the backend gets reset, so there's no point in making them wait
+	runtime.failQueuedCommands(stateError(state))
+
+	select {
+	case <-runtime.resetNotification:
+		log.Infof("Backend '%s' was reset. Restarting process", runtime.processPath())
+		return true
+	case <-runtime.stopNotification:
+		return false
+	}
+}
+
+// Checks whether the process exited because it was restarted on purpose, and clears the request if so
+func (runtime *Runtime) consumeRestartRequest() bool {
+	runtime.restartLock.Lock()
+	defer runtime.restartLock.Unlock()
+
+	restartRequested := runtime.restartRequested
+	runtime.restartRequested = false
+
+	return restartRequested
+}
+
+// Swaps the backend over to a new manifest entry, and restarts its process so that it picks it up. The restart
+// doesn't count towards the crash loop limit, and happens regardless of the restart policy.
+func (runtime *Runtime) restartWithManifest(backend *Backend) error {
+	if !runtime.isRuntimeRunning.Load() {
+		// Backends that fail the handshake get stopped, but the new executable might be able to talk to us
+		if runtime.getIncompatibilityError() == nil {
+			return fmt.Errorf("runtime not running")
+		}
+
+		runtime.restartLock.Lock()
+		runtime.useManifest(backend)
+		runtime.restartLock.Unlock()
+
+		return runtime.Start()
+	}
+
+	runtime.restartLock.Lock()
+
+	runtime.useManifest(backend)
+
+	state := runtime.state
+	process := runtime.currentProcess
+
+	// While waiting to restart, the new settings get picked up by the next start anyway
+	runtime.restartRequested = state == StateRunning
+
+	runtime.restartLock.Unlock()
+
+	switch state {
+	case StateCrashLoop, StateExited:
+		// The new executable might fix whatever was wrong with the old one
+		return runtime.Reset()
+	case StateRunning:
+		if process == nil || process.Cancel == nil {
+			return fmt.Errorf("backend process is not running")
+		}
+
+		if err := process.Cancel(); err != nil {
+			return fmt.Errorf("failed to stop process: %s", err.Error())
+		}
+	}
+
+	return nil
+}
+
+// Swaps the runtime's process settings over to a new manifest entry. restartLock has to be held while calling this.
+func (runtime *Runtime) useManifest(backend *Backend) {
+	runtime.ProcessPath = backend.Path
+	runtime.ProcessArgs = backend.Args
+	runtime.ProcessEnvironment = backend.environment()
+	runtime.ProcessSHA256 = backend.SHA256
+	runtime.Sandbox = backend.Sandbox
+	runtime.manifest = backend
+}
@@ -2,12 +2,12 @@ package backendruntime
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"os"
 	"os/exec"
 	"strings"
-	"sync"
 	"time"
 
 	"git.terah.dev/imterah/hermes/backend/backendlauncher"
@@ -15,52 +15,319 @@ import (
 	"github.com/charmbracelet/log"
 )
 
-func handleCommand(commandType string, command interface{}, sock net.Conn, rtcChan chan interface{}) error {
-	bytes, err := commonbackend.Marshal(commandType, command)
+// Returned by ProcessCommandWithTimeout when the backend doesn't respond in time
+var ErrCommandTimeout = fmt.Errorf("timed out waiting for the backend to respond")
+
+func handleCommand(requestID uint32, deadline time.Time, commandType string, command interface{}, sock net.Conn) error {
+	bytes, err := commonbackend.MarshalWithDeadline(requestID, deadline, commandType, command)
 
 	if err != nil {
 		log.Warnf("Failed to marshal message: %s", err.Error())
-		rtcChan <- fmt.Errorf("failed to marshal message: %s", err.Error())
-
 		return fmt.Errorf("failed to marshal message: %s", err.Error())
 	}
 
 	if _, err := sock.Write(bytes); err != nil {
 		log.Warnf("Failed to write message: %s", err.Error())
-		rtcChan <- fmt.Errorf("failed to write message: %s", err.Error())
-
 		return fmt.Errorf("failed to write message: %s", err.Error())
 	}
 
-	_, data, err := commonbackend.Unmarshal(sock)
+	return nil
+}
+
+// Reads responses from the backend, and hands them off to whoever is waiting on that request ID.
+// Events sent by the backend on its own are handed off to the event subscribers instead.
+func (runtime *Runtime) responseReader(sock net.Conn) {
+	for {
+		requestID, _, data, err := commonbackend.UnmarshalWithRequestID(sock)
 
-	if err != nil {
-		log.Warnf("Failed to unmarshal message: %s", err.Error())
-		rtcChan <- fmt.Errorf("failed to unmarshal message: %s", err.Error())
+		if err != nil {
+			log.Warnf("Failed to unmarshal message: %s", err.Error())
+			runtime.failPendingResponses(fmt.Errorf("failed to unmarshal message: %s", err.Error()))
+
+			// We can't recover the stream after a bad read, so we close the socket so that everything gets reset
+			sock.Close()
+			return
+		}
+
+		if requestID == 0 && isEvent(data) {
+			runtime.publishEvent(data)
+			continue
+		}
+
+		responseChannel, ok := runtime.takePendingResponse(requestID)
 
-		return fmt.Errorf("failed to unmarshal message: %s", err.Error())
+		if !ok {
+			log.Warnf("Recieved response for unknown request ID #%d: %T", requestID, data)
+			continue
+		}
+
+		responseChannel <- data
 	}
+}
+
+// Reads the next response from the backend, handling any events (ex. log messages) that arrive before it.
+// Only use this when talking to the socket directly, before the runtime starts reading from it (ex. in OnCrashCallback).
+func (runtime *Runtime) ReadResponse(sock net.Conn) (string, interface{}, error) {
+	for {
+		requestID, commandType, data, err := commonbackend.UnmarshalWithRequestID(sock)
+
+		if err != nil {
+			return "", nil, err
+		}
+
+		if requestID == 0 && isEvent(data) {
+			runtime.publishEvent(data)
+			continue
+		}
+
+		return commandType, data, nil
+	}
+}
 
-	rtcChan <- data
+func (runtime *Runtime) addPendingResponse(responseChannel chan interface{}) uint32 {
+	runtime.pendingResponsesLock.Lock()
+	defer runtime.pendingResponsesLock.Unlock()
+
+	// Request ID 0 is reserved for commands sent outside of the runtime (ex. the handshake and the crash callback)
+	runtime.lastRequestID++
+
+	if runtime.lastRequestID == 0 {
+		runtime.lastRequestID++
+	}
+
+	runtime.pendingResponses[runtime.lastRequestID] = responseChannel
+	return runtime.lastRequestID
+}
+
+// Takes the response channel for a request out of the pending responses. Whoever takes it is the only one allowed to
+// send to it, so every request gets exactly one response. Returns false if someone else already took it.
+func (runtime *Runtime) takePendingResponse(requestID uint32) (chan interface{}, bool) {
+	runtime.pendingResponsesLock.Lock()
+	defer runtime.pendingResponsesLock.Unlock()
+
+	responseChannel, ok := runtime.pendingResponses[requestID]
+	delete(runtime.pendingResponses, requestID)
+
+	return responseChannel, ok
+}
+
+func (runtime *Runtime) failPendingResponses(err error) {
+	runtime.pendingResponsesLock.Lock()
+	defer runtime.pendingResponsesLock.Unlock()
+
+	for requestID, responseChannel := range runtime.pendingResponses {
+		delete(runtime.pendingResponses, requestID)
+		responseChannel <- err
+	}
+}
+
+func (runtime *Runtime) sendCommand(commandType string, command interface{}, sock net.Conn, messageData *messageForBuf) error {
+	requestID := runtime.addPendingResponse(messageData.Channel)
+	deadline := messageData.Deadline
+
+	// Older backends can't read frames carrying a deadline
+	if runtime.ProtocolVersion < 8 {
+		deadline = time.Time{}
+	}
+
+	if err := handleCommand(requestID, deadline, commandType, command, sock); err != nil {
+		// If the response reader already failed the request (ex. because the socket closed), it has been answered already
+		if responseChannel, ok := runtime.takePendingResponse(requestID); ok {
+			responseChannel <- err
+		}
+
+		return err
+	}
 
 	return nil
 }
 
-func (runtime *Runtime) goRoutineHandler() error {
-	log.Debug("Starting up backend runtime")
-	log.Debug("Running socket acquisition")
+// Sends queued commands to the backend until the connection closes. This blocks while there's nothing to send.
+func (runtime *Runtime) dispatchCommands(sock net.Conn, connectionClosed chan struct{}) {
+	for {
+		var messageData *messageForBuf
 
-	logLevel := os.Getenv("HERMES_LOG_LEVEL")
+		// Priority commands go first, even if the regular queue has a backlog
+		select {
+		case messageData = <-runtime.priorityCommandQueue:
+		default:
+			select {
+			// Once the response reader gives up on the socket, there's no point in sending anything else over it
+			case <-connectionClosed:
+				return
+			case messageData = <-runtime.priorityCommandQueue:
+			case messageData = <-runtime.commandQueue:
+			}
+		}
 
-	sockPath, sockListener, err := backendlauncher.GetUnixSocket(TempDir)
+		// Whoever sent this has already given up on it, so there's no point in sending it
+		if !messageData.Deadline.IsZero() && time.Now().After(messageData.Deadline) {
+			messageData.Channel <- ErrCommandTimeout
+			continue
+		}
+
+		commandType, err := runtime.commandTypeFor(messageData.Message)
+
+		if err != nil {
+			messageData.Channel <- err
+			continue
+		}
+
+		if err := runtime.sendCommand(commandType, messageData.Message, sock, messageData); err != nil {
+			log.Warnf("failed to handle command in backend runtime instance: %s", err.Error())
+
+			if strings.HasPrefix(err.Error(), "failed to write message") {
+				return
+			}
+		}
+	}
+}
+
+// Gets the command type to send a command as, checking that the backend is capable of handling it first
+func (runtime *Runtime) commandTypeFor(command interface{}) (string, error) {
+	switch command := command.(type) {
+	case *commonbackend.AddProxy:
+		if !runtime.HasCapability(command.Protocol) {
+			return "", fmt.Errorf("backend does not support the '%s' protocol", command.Protocol)
+		}
+
+		if commonbackend.IsUnixSocketPath(command.SourceIP) && !runtime.HasCapability(commonbackend.CapabilityUnixSockets) {
+			return "", fmt.Errorf("backend does not support Unix socket sources")
+		}
+
+		return "addProxy", nil
+	case *commonbackend.AddProxies:
+		if !runtime.HasCapability(commonbackend.CapabilityBatchProxies) {
+			return "", fmt.Errorf("backend does not support batched proxy operations")
+		}
+
+		return "addProxies", nil
+	case *commonbackend.RemoveProxies:
+		if !runtime.HasCapability(commonbackend.CapabilityBatchProxies) {
+			return "", fmt.Errorf("backend does not support batched proxy operations")
+		}
+
+		return "removeProxies", nil
+	case *commonbackend.BackendStatusRequest:
+		return "backendStatusRequest", nil
+	case *commonbackend.CheckClientParameters:
+		return "checkClientParameters", nil
+	case *commonbackend.CheckServerParameters:
+		return "checkServerParameters", nil
+	case *commonbackend.ProxyConnectionsRequest:
+		return "proxyConnectionsRequest", nil
+	case *commonbackend.KillConnection:
+		if !runtime.HasCapability(commonbackend.CapabilityKillConnection) {
+			return "", fmt.Errorf("backend does not support killing connections")
+		}
+
+		return "killConnection", nil
+	case *commonbackend.ParametersSchemaRequest:
+		if !runtime.HasCapability(commonbackend.CapabilityParametersSchema) {
+			return "", fmt.Errorf("backend does not publish a parameters schema")
+		}
+
+		return "parametersSchemaRequest", nil
+	case *commonbackend.Ping:
+		if !runtime.HasCapability(commonbackend.CapabilityPing) {
+			return "", fmt.Errorf("backend does not support pings")
+		}
+
+		return "ping", nil
+	case *commonbackend.ProxyInstanceRequest:
+		return "proxyInstanceRequest", nil
+	case *commonbackend.ProxyStatusRequest:
+		return "proxyStatusRequest", nil
+	case *commonbackend.RemoveProxy:
+		if command.DrainTimeout > 0 && !runtime.HasCapability(commonbackend.CapabilityProxyDrain) {
+			return "", fmt.Errorf("backend does not support draining connections")
+		}
+
+		return "removeProxy", nil
+	case *commonbackend.Start:
+		return "start", nil
+	case *commonbackend.Stop:
+		return "stop", nil
+	default:
+		log.Warnf("Recieved unknown command type from queue: %T", command)
+		return "", fmt.Errorf("unknown command recieved")
+	}
+}
+
+func (runtime *Runtime) performHandshake(sock net.Conn) error {
+	if err := sock.SetDeadline(time.Now().Add(handshakeTimeout)); err != nil {
+		return fmt.Errorf("failed to set handshake deadline: %s", err.Error())
+	}
+
+	defer sock.SetDeadline(time.Time{})
+
+	bytes, err := commonbackend.Marshal("hello", &commonbackend.Hello{
+		Type:            "hello",
+		ProtocolVersion: commonbackend.ProtocolVersion,
+	})
 
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to marshal hello: %s", err.Error())
 	}
 
-	runtime.currentListener = sockListener
+	if _, err := sock.Write(bytes); err != nil {
+		return fmt.Errorf("failed to write hello: %s", err.Error())
+	}
 
-	log.Debugf("Acquired unix socket at: %s", sockPath)
+	_, data, err := runtime.ReadResponse(sock)
+
+	if err != nil {
+		return fmt.Errorf("failed to read hello response: %s", err.Error())
+	}
+
+	response, ok := data.(*commonbackend.HelloResponse)
+
+	if !ok {
+		return fmt.Errorf("got illegal response type to hello: %T", data)
+	}
+
+	if response.ProtocolVersion < commonbackend.MinimumProtocolVersion {
+		return fmt.Errorf("backend speaks protocol version %d, but the oldest supported version is %d", response.ProtocolVersion, commonbackend.MinimumProtocolVersion)
+	}
+
+	// Newer backends get told our version in the hello, so they are expected to downgrade to it
+	runtime.ProtocolVersion = min(response.ProtocolVersion, commonbackend.ProtocolVersion)
+	runtime.BackendName = response.BackendName
+	runtime.BackendVersion = response.BackendVersion
+	runtime.Capabilities = response.Capabilities
+
+	return nil
+}
+
+func (runtime *Runtime) setIncompatibilityError(err error) {
+	runtime.incompatibilityErrorLock.Lock()
+	defer runtime.incompatibilityErrorLock.Unlock()
+
+	runtime.incompatibilityError = err
+}
+
+// Gets the reason the backend was refused during the handshake, if it was
+func (runtime *Runtime) getIncompatibilityError() error {
+	runtime.incompatibilityErrorLock.Lock()
+	defer runtime.incompatibilityErrorLock.Unlock()
+
+	return runtime.incompatibilityError
+}
+
+func (runtime *Runtime) HasCapability(capability string) bool {
+	for _, supportedCapability := range runtime.Capabilities {
+		if supportedCapability == capability {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (runtime *Runtime) goRoutineHandler(sockPath string) error {
+	log.Debug("Starting up backend runtime")
+
+	logLevel := os.Getenv("HERMES_LOG_LEVEL")
 
 	go func() {
 		log.Debug("Created new Goroutine for socket connection handling")
@@ -74,232 +341,125 @@ func (runtime *Runtime) goRoutineHandler() error {
 				return
 			}
 
-			log.Debug("Recieved connection. Attempting to figure out backend state...")
+			log.Debug("Recieved connection. Performing handshake...")
 
-			timeoutChannel := time.After(500 * time.Millisecond)
+			if err := runtime.performHandshake(sock); err != nil {
+				log.Errorf("Refusing to talk to incompatible backend '%s': %s", runtime.processPath(), err.Error())
+				runtime.setIncompatibilityError(fmt.Errorf("backend is incompatible: %s", err.Error()))
 
-			select {
-			case <-timeoutChannel:
-				log.Debug("Timeout reached. Assuming backend is running.")
-			case hasRestarted, ok := <-runtime.processRestartNotification:
-				if !ok {
-					log.Warnf("Failed to get the process restart notification state!")
+				sock.Close()
+				runtime.failQueuedCommands(runtime.getIncompatibilityError())
+
+				if err := runtime.Stop(); err != nil {
+					log.Warnf("Failed to stop incompatible backend: %s", err.Error())
 				}
 
-				if hasRestarted {
-					if runtime.OnCrashCallback == nil {
-						log.Warn("The backend has restarted for some reason, but we could not run the on crash callback as the callback is not set!")
-					} else {
-						log.Debug("We have restarted. Running the restart callback...")
-						runtime.OnCrashCallback(sock)
-					}
-
-					log.Debug("Clearing caches...")
-					runtime.cleanUpPendingCommandProcessingJobs()
-					runtime.messageBufferLock = sync.Mutex{}
+				return
+			}
+
+			runtime.clearConnections()
+
+			log.Debugf("Backend '%s' (version '%s') speaks protocol version %d with capabilities: %s", runtime.BackendName, runtime.BackendVersion, runtime.ProtocolVersion, strings.Join(runtime.Capabilities, ", "))
+
+			// The process is only marked as restarted before it starts, so by the time it connects, we know whether it has
+			if runtime.processRestarted.Swap(false) {
+				if runtime.OnCrashCallback == nil {
+					log.Warn("The backend has restarted for some reason, but we could not run the on crash callback as the callback is not set!")
 				} else {
-					log.Debug("We have not restarted.")
+					log.Debug("We have restarted. Running the restart callback...")
+					runtime.OnCrashCallback(sock)
 				}
+
+				log.Debug("Clearing caches...")
+				runtime.failQueuedCommands(fmt.Errorf("backend restarted before the command could be sent"))
 			}
 
+			connectionClosed := make(chan struct{})
+
 			go func() {
-				log.Debug("Setting up Hermes keepalive Goroutine")
-				hasFailedBackendRunningCheckAlready := false
-
-				for {
-					if !runtime.isRuntimeRunning {
-						return
-					}
-
-					// Asking for the backend status seems to be a "good-enough" keepalive system. Plus, it provides useful telemetry.
-					// There isn't a ping command in the backend API, so we have to make do with what we have.
-					//
-					// To be safe here, we have to use the proper (yet annoying) facilities to prevent cross-talk, since we're in
-					// a goroutine, and can't talk directly. This actually has benefits, as the OuterLoop should exit on its own, if we
-					// encounter a critical error.
-					statusResponse, err := runtime.ProcessCommand(&commonbackend.BackendStatusRequest{
-						Type: "backendStatusRequest",
-					})
-
-					if err != nil {
-						log.Warnf("Failed to get response for backend (in backend runtime keep alive): %s", err.Error())
-						log.Debugf("Attempting to close socket...")
-						err := sock.Close()
-
-						if err != nil {
-							log.Debugf("Failed to close socket: %s", err.Error())
-						}
-
-						continue
-					}
-
-					switch responseMessage := statusResponse.(type) {
-					case *commonbackend.BackendStatusResponse:
-						if !responseMessage.IsRunning {
-							if hasFailedBackendRunningCheckAlready {
-								if responseMessage.Message != "" {
-									log.Warnf("Backend (in backend keepalive) is up but not active: %s", responseMessage.Message)
-								} else {
-									log.Warnf("Backend (in backend keepalive) is up but not active")
-								}
-							}
-
-							hasFailedBackendRunningCheckAlready = true
-						}
-					default:
-						log.Errorf("Got illegal response type for backend (in backend keepalive): %T", responseMessage)
-						log.Debugf("Attempting to close socket...")
-						err := sock.Close()
-
-						if err != nil {
-							log.Debugf("Failed to close socket: %s", err.Error())
-						}
-					}
-
-					time.Sleep(5 * time.Second)
-				}
+				runtime.responseReader(sock)
+				close(connectionClosed)
 			}()
 
-		OuterLoop:
-			for {
-				for chanIndex, messageData := range runtime.messageBuffer {
-					if messageData == nil {
-						continue
-					}
-
-					switch command := messageData.Message.(type) {
-					case *commonbackend.AddProxy:
-						err := handleCommand("addProxy", command, sock, messageData.Channel)
-
-						if err != nil {
-							log.Warnf("failed to handle command in backend runtime instance: %s", err.Error())
-
-							if strings.HasPrefix(err.Error(), "failed to write message") {
-								break OuterLoop
-							}
-						}
-					case *commonbackend.BackendStatusRequest:
-						err := handleCommand("backendStatusRequest", command, sock, messageData.Channel)
-
-						if err != nil {
-							log.Warnf("failed to handle command in backend runtime instance: %s", err.Error())
-
-							if strings.HasPrefix(err.Error(), "failed to write message") {
-								break OuterLoop
-							}
-						}
-					case *commonbackend.CheckClientParameters:
-						err := handleCommand("checkClientParameters", command, sock, messageData.Channel)
-
-						if err != nil {
-							log.Warnf("failed to handle command in backend runtime instance: %s", err.Error())
-
-							if strings.HasPrefix(err.Error(), "failed to write message") {
-								break OuterLoop
-							}
-						}
-					case *commonbackend.CheckServerParameters:
-						err := handleCommand("checkServerParameters", command, sock, messageData.Channel)
-
-						if err != nil {
-							log.Warnf("failed to handle command in backend runtime instance: %s", err.Error())
-
-							if strings.HasPrefix(err.Error(), "failed to write message") {
-								break OuterLoop
-							}
-						}
-					case *commonbackend.ProxyConnectionsRequest:
-						err := handleCommand("proxyConnectionsRequest", command, sock, messageData.Channel)
-
-						if err != nil {
-							log.Warnf("failed to handle command in backend runtime instance: %s", err.Error())
-
-							if strings.HasPrefix(err.Error(), "failed to write message") {
-								break OuterLoop
-							}
-						}
-					case *commonbackend.ProxyInstanceRequest:
-						err := handleCommand("proxyInstanceRequest", command, sock, messageData.Channel)
-
-						if err != nil {
-							log.Warnf("failed to handle command in backend runtime instance: %s", err.Error())
-
-							if strings.HasPrefix(err.Error(), "failed to write message") {
-								break OuterLoop
-							}
-						}
-					case *commonbackend.ProxyStatusRequest:
-						err := handleCommand("proxyStatusRequest", command, sock, messageData.Channel)
-
-						if err != nil {
-							log.Warnf("failed to handle command in backend runtime instance: %s", err.Error())
-
-							if strings.HasPrefix(err.Error(), "failed to write message") {
-								break OuterLoop
-							}
-						}
-					case *commonbackend.RemoveProxy:
-						err := handleCommand("removeProxy", command, sock, messageData.Channel)
-
-						if err != nil {
-							log.Warnf("failed to handle command in backend runtime instance: %s", err.Error())
-
-							if strings.HasPrefix(err.Error(), "failed to write message") {
-								break OuterLoop
-							}
-						}
-					case *commonbackend.Start:
-						err := handleCommand("start", command, sock, messageData.Channel)
-
-						if err != nil {
-							log.Warnf("failed to handle command in backend runtime instance: %s", err.Error())
-
-							if strings.HasPrefix(err.Error(), "failed to write message") {
-								break OuterLoop
-							}
-						}
-					case *commonbackend.Stop:
-						err := handleCommand("stop", command, sock, messageData.Channel)
-
-						if err != nil {
-							log.Warnf("failed to handle command in backend runtime instance: %s", err.Error())
-
-							if strings.HasPrefix(err.Error(), "failed to write message") {
-								break OuterLoop
-							}
-						}
-					default:
-						log.Warnf("Recieved unknown command type from channel: %T", command)
-						messageData.Channel <- fmt.Errorf("unknown command recieved")
-					}
-
-					runtime.messageBuffer[chanIndex] = nil
-				}
+			if runtime.HasCapability(commonbackend.CapabilityPing) {
+				go runtime.pingKeepalive(sock, connectionClosed)
+			} else {
+				go runtime.statusKeepalive(sock, connectionClosed)
 			}
 
+			runtime.isConnected.Store(true)
+			runtime.dispatchCommands(sock, connectionClosed)
+			runtime.isConnected.Store(false)
+
 			sock.Close()
 		}
 	}()
 
-	runtime.processRestartNotification <- false
-
 	for {
 		log.Debug("Starting process...")
 
 		ctx := context.Background()
 
-		runtime.currentProcess = exec.CommandContext(ctx, runtime.ProcessPath)
-		runtime.currentProcess.Env = append(runtime.currentProcess.Env, fmt.Sprintf("HERMES_API_SOCK=%s", sockPath), fmt.Sprintf("HERMES_LOG_LEVEL=%s", logLevel))
+		process, err := runtime.newProcess(ctx, []string{
+			fmt.Sprintf("HERMES_API_SOCK=%s", sockPath),
+			fmt.Sprintf("HERMES_LOG_LEVEL=%s", logLevel),
+			fmt.Sprintf("HERMES_MAX_FRAME_SIZE=%d", commonbackend.MaxFrameSize),
+		})
 
-		runtime.currentProcess.Stdout = runtime.logger
-		runtime.currentProcess.Stderr = runtime.logger
+		if err != nil {
+			log.Errorf("Failed to start backend '%s': %s", runtime.processPath(), err.Error())
 
-		err := runtime.currentProcess.Run()
+			if !runtime.isRuntimeRunning.Load() || !runtime.waitForRestart(false) {
+				runtime.setState(StateStopped)
+				return nil
+			}
+
+			continue
+		}
+
+		process.Stdout = &writeLogger{
+			Runtime: runtime,
+			Stream:  LogStreamStdout,
+		}
+
+		process.Stderr = &writeLogger{
+			Runtime: runtime,
+			Stream:  LogStreamStderr,
+		}
+
+		runtime.logsLock.Lock()
+		runtime.generation++
+		runtime.logsLock.Unlock()
+
+		// The process is started under the lock, so that Stop and Shutdown either see it, or we see that we were stopped
+		runtime.restartLock.Lock()
+
+		if !runtime.isRuntimeRunning.Load() {
+			runtime.restartLock.Unlock()
+			runtime.setState(StateStopped)
+			return nil
+		}
+
+		processExited := make(chan struct{})
+		err = process.Start()
+
+		if err == nil {
+			runtime.currentProcess = process
+			runtime.processExited = processExited
+			runtime.state = StateRunning
+		}
+
+		runtime.restartLock.Unlock()
+
+		if err == nil {
+			err = process.Wait()
+			close(processExited)
+		}
 
 		if err != nil {
-			if err, ok := err.(*exec.ExitError); ok {
-				if err.ExitCode() != -1 && err.ExitCode() != 0 {
-					log.Warnf("A backend process died with exit code '%d' and with error '%s'", err.ExitCode(), err.Error())
+			if exitErr, ok := err.(*exec.ExitError); ok {
+				if exitErr.ExitCode() != -1 && exitErr.ExitCode() != 0 {
+					log.Warnf("A backend process died with exit code '%d' and with error '%s'", exitErr.ExitCode(), exitErr.Error())
 				}
 			} else {
 				log.Warnf("A backend process died with error: %s", err.Error())
@@ -308,60 +468,116 @@ func (runtime *Runtime) goRoutineHandler() error {
 			log.Debug("Process exited gracefully.")
 		}
 
-		if !runtime.isRuntimeRunning {
+		if !runtime.isRuntimeRunning.Load() {
+			runtime.setState(StateStopped)
 			return nil
 		}
 
-		log.Debug("Sleeping 5 seconds, and then restarting process")
-		time.Sleep(5 * time.Second)
-
-		// NOTE(imterah): This could cause hangs if we're not careful. If the process dies so much that we can't keep up, it should deserve to be hung, really.
-		// There's probably a better way to do this, but this works.
-		//
-		// If this does turn out to be a problem, just increase the Goroutine buffer size.
-		runtime.processRestartNotification <- true
+		if runtime.consumeRestartRequest() {
+			log.Infof("Restarting backend '%s', as it was asked to", runtime.processPath())
+		} else if !runtime.waitForRestart(err == nil) {
+			runtime.setState(StateStopped)
+			return nil
+		}
 
-		log.Debug("Sent off notification.")
+		runtime.processRestarted.Store(true)
 	}
 }
 
 func (runtime *Runtime) Start() error {
-	if runtime.isRuntimeRunning {
+	if !runtime.isRuntimeRunning.CompareAndSwap(false, true) {
 		return fmt.Errorf("runtime already running")
 	}
 
-	runtime.messageBuffer = make([]*messageForBuf, 10)
-	runtime.messageBufferLock = sync.Mutex{}
+	// Starting again gives a backend that failed the handshake another go (ex. after it has been updated)
+	runtime.setIncompatibilityError(nil)
+
+	// A manifest reload can swap these out from under us, so we take them all at once
+	runtime.restartLock.Lock()
+	processPath, processSHA256, sandbox := runtime.ProcessPath, runtime.ProcessSHA256, runtime.Sandbox
+	runtime.restartLock.Unlock()
+
+	// This is checked again every time the process starts, but checking up front means a wrong backend fails straight away
+	if processSHA256 != "" {
+		if err := verifyChecksum(processPath, processSHA256); err != nil {
+			runtime.isRuntimeRunning.Store(false)
+			return err
+		}
+	}
 
-	runtime.processRestartNotification = make(chan bool, 1)
+	log.Debug("Running socket acquisition")
 
-	runtime.logger = &writeLogger{
-		Runtime: runtime,
+	sockPath, sockListener, err := backendlauncher.GetUnixSocket(TempDir)
+
+	if err != nil {
+		runtime.isRuntimeRunning.Store(false)
+		return err
+	}
+
+	if err := sandbox.ownSocket(sockPath); err != nil {
+		sockListener.Close()
+		runtime.isRuntimeRunning.Store(false)
+
+		return err
 	}
 
+	log.Debugf("Acquired unix socket at: %s", sockPath)
+
+	runtime.currentListener = sockListener
+	runtime.commandQueue = make(chan *messageForBuf, commandQueueSize)
+	runtime.priorityCommandQueue = make(chan *messageForBuf, priorityCommandQueueSize)
+
+	runtime.pendingResponses = make(map[uint32]chan interface{})
+
+	runtime.processRestarted.Store(false)
+	runtime.resetNotification = make(chan struct{}, 1)
+	runtime.stopNotification = make(chan struct{})
+	runtime.stoppedNotification = make(chan struct{})
+
+	if runtime.RestartPolicy == "" {
+		runtime.RestartPolicy = RestartAlways
+	}
+
+	runtime.restartLock.Lock()
+	runtime.state = StateRunning
+	runtime.restartCount = 0
+	runtime.recentRestarts = nil
+	runtime.restartLock.Unlock()
+
+	runtime.clearConnections()
+	events, stopTrackingConnections := runtime.SubscribeToEvents()
+	runtime.stopTrackingConnections = stopTrackingConnections
+	go runtime.trackConnections(events)
+
 	go func() {
-		err := runtime.goRoutineHandler()
+		defer close(runtime.stoppedNotification)
+		err := runtime.goRoutineHandler(sockPath)
 
 		if err != nil {
 			log.Errorf("Failed during execution of runtime: %s", err.Error())
 		}
 	}()
 
-	runtime.isRuntimeRunning = true
 	return nil
 }
 
 func (runtime *Runtime) Stop() error {
-	if !runtime.isRuntimeRunning {
+	if !runtime.isRuntimeRunning.CompareAndSwap(true, false) {
 		return fmt.Errorf("runtime not running")
 	}
 
-	runtime.isRuntimeRunning = false
+	runtime.stopTrackingConnections()
+	close(runtime.stopNotification)
 
-	if runtime.currentProcess != nil && runtime.currentProcess.Cancel != nil {
-		err := runtime.currentProcess.Cancel()
+	runtime.restartLock.Lock()
+	process := runtime.currentProcess
+	runtime.restartLock.Unlock()
 
-		if err != nil {
+	if process != nil && process.Cancel != nil {
+		err := process.Cancel()
+
+		// The process might have already exited (ex. if the backend is in a crash loop), which is fine
+		if err != nil && !errors.Is(err, os.ErrProcessDone) {
 			return fmt.Errorf("failed to stop process: %s", err.Error())
 		}
 	} else {
@@ -381,54 +597,68 @@ func (runtime *Runtime) Stop() error {
 	return nil
 }
 
+// Sends a command to the backend, and waits for its response, for up to DefaultCommandTimeout.
 func (runtime *Runtime) ProcessCommand(command interface{}) (interface{}, error) {
-	schedulingAttempts := 0
-	var commandChannel chan interface{}
-
-SchedulingLoop:
-	for {
-		if !runtime.isRuntimeRunning {
-			time.Sleep(10 * time.Millisecond)
-		}
+	return runtime.ProcessCommandWithTimeout(command, DefaultCommandTimeout)
+}
 
-		if schedulingAttempts > 50 {
-			return nil, fmt.Errorf("failed to schedule message transmission after 50 tries (REPORT THIS ISSUE)")
-		}
+// Sends a command to the backend, and waits for its response. If the backend doesn't respond within the timeout,
+// ErrCommandTimeout is returned. The deadline is passed on to the backend, so it can give up on the command too.
+// A timeout of zero waits forever.
+func (runtime *Runtime) ProcessCommandWithTimeout(command interface{}, timeout time.Duration) (interface{}, error) {
+	return runtime.processCommand(command, timeout, runtime.commandQueue)
+}
 
-		runtime.messageBufferLock.Lock()
+// Same as ProcessCommandWithTimeout, but the command skips ahead of any commands waiting in the regular queue
+func (runtime *Runtime) processPriorityCommand(command interface{}, timeout time.Duration) (interface{}, error) {
+	return runtime.processCommand(command, timeout, runtime.priorityCommandQueue)
+}
 
-		// Attempt to find spot in buffer to schedule message transmission
-		for i, message := range runtime.messageBuffer {
-			if message != nil {
-				continue
-			}
+func (runtime *Runtime) processCommand(command interface{}, timeout time.Duration, queue chan *messageForBuf) (interface{}, error) {
+	if err := runtime.getIncompatibilityError(); err != nil {
+		return nil, err
+	}
 
-			commandChannel = make(chan interface{})
+	if queue == nil {
+		return nil, fmt.Errorf("runtime not started")
+	}
 
-			runtime.messageBuffer[i] = &messageForBuf{
-				Channel: commandChannel,
-				Message: command,
-			}
+	if err := runtime.unavailableError(); err != nil {
+		return nil, err
+	}
 
-			runtime.messageBufferLock.Unlock()
-			break SchedulingLoop
-		}
+	var deadline time.Time
+	var timeoutChannel <-chan time.Time
 
-		runtime.messageBufferLock.Unlock()
-		time.Sleep(100 * time.Millisecond)
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+		timeoutChannel = time.After(timeout)
+	}
 
-		schedulingAttempts++
+	// This is buffered, so that a response arriving after we've timed out doesn't block whoever sends it
+	commandChannel := make(chan interface{}, 1)
+
+	// If the queue is full, we wait for room in it (up until the timeout), so that bursts of commands get sent eventually
+	select {
+	case queue <- &messageForBuf{
+		Channel:  commandChannel,
+		Message:  command,
+		Deadline: deadline,
+	}:
+	case <-timeoutChannel:
+		return nil, ErrCommandTimeout
 	}
 
-	// Fetch response and close Channel
-	response, ok := <-commandChannel
+	// The channel is never closed. Whoever answers the command sends to it exactly once, and it's buffered, so that
+	// doesn't block even if we've already given up on it.
+	var response interface{}
 
-	if !ok {
-		return nil, fmt.Errorf("failed to read from command channel: recieved signal that is not OK")
+	select {
+	case response = <-commandChannel:
+	case <-timeoutChannel:
+		return nil, ErrCommandTimeout
 	}
 
-	close(commandChannel)
-
 	err, ok := response.(error)
 
 	if ok {
@@ -438,26 +668,17 @@ SchedulingLoop:
 	return response, nil
 }
 
-func (runtime *Runtime) cleanUpPendingCommandProcessingJobs() {
-	for messageIndex, message := range runtime.messageBuffer {
-		if message == nil {
-			continue
-		}
-
-		timeoutChannel := time.After(100 * time.Millisecond)
-
+// Fails every command that is still waiting to be sent to the backend
+func (runtime *Runtime) failQueuedCommands(err error) {
+	for {
 		select {
-		case <-timeoutChannel:
-			log.Warn("Message channel is likely running (timed out reading from it without an error)")
-			close(message.Channel)
-		case _, ok := <-message.Channel:
-			if ok {
-				log.Warn("Message channel is running, but should be stopped (since message is NOT nil!)")
-				close(message.Channel)
-			}
+		case messageData := <-runtime.priorityCommandQueue:
+			messageData.Channel <- err
+		case messageData := <-runtime.commandQueue:
+			messageData.Channel <- err
+		default:
+			return
 		}
-
-		runtime.messageBuffer[messageIndex] = nil
 	}
 }
 
@@ -467,7 +688,53 @@ func NewBackend(path string) *Runtime {
 	}
 }
 
-func Init(backends []*Backend) error {
+// Creates a runtime for a backend from the backend manifest
+func NewBackendFromManifest(backend *Backend) *Runtime {
+	return &Runtime{
+		ProcessPath:        backend.Path,
+		ProcessArgs:        backend.Args,
+		ProcessEnvironment: backend.environment(),
+		ProcessSHA256:      backend.SHA256,
+		Sandbox:            backend.Sandbox,
+		manifest:           backend,
+	}
+}
+
+// Gets the path of the backend's executable. A manifest reload can change it, so it's read under restartLock.
+func (runtime *Runtime) processPath() string {
+	runtime.restartLock.Lock()
+	defer runtime.restartLock.Unlock()
+
+	return runtime.ProcessPath
+}
+
+// Builds the command to start the backend's process with, after checking that the backend is the one we expect
+func (runtime *Runtime) newProcess(ctx context.Context, hermesEnvironment []string) (*exec.Cmd, error) {
+	// The process settings can be swapped out by a manifest reload, so we take them all at once
+	runtime.restartLock.Lock()
+	defer runtime.restartLock.Unlock()
+
+	runtime.restartRequested = false
+
+	processHash, err := fileChecksum(runtime.ProcessPath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if runtime.ProcessSHA256 != "" {
+		if err := compareChecksum(processHash, runtime.ProcessSHA256); err != nil {
+			return nil, err
+		}
+	}
+
+	runtime.processHash = processHash
+
+	return runtime.Sandbox.command(ctx, runtime.ProcessPath, runtime.ProcessArgs, append(hermesEnvironment, runtime.ProcessEnvironment...))
+}
+
+// Sets up the backend runtime, with the backends loaded from the manifest at backendManifestPath. The path is kept around for ReloadManifest.
+func Init(backends []*Backend, backendManifestPath string) error {
 	var err error
 	TempDir, err = os.MkdirTemp("", "hermes-sockets-")
 
@@ -475,7 +742,14 @@ func Init(backends []*Backend) error {
 		return err
 	}
 
-	AvailableBackends = backends
+	// Sandboxed backends might run as a different user, so they need to be able to get to their socket. They still
+	// can't list the directory, and can only connect to sockets they own.
+	if err := os.Chmod(TempDir, 0o711); err != nil {
+		return err
+	}
+
+	setAvailableBackends(backends)
+	manifestPath = backendManifestPath
 
 	return nil
 }
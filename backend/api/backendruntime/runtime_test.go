@@ -0,0 +1,319 @@
+package backendruntime
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	"git.terah.dev/imterah/hermes/backend/commonbackend"
+)
+
+// Sets up the socket directory, and makes restarts fast enough to test crash loops with
+func setUpTestRuntime(t *testing.T) {
+	t.Helper()
+
+	// t.TempDir() can be too long of a path for Unix sockets
+	socketDir, err := os.MkdirTemp("", "hermes-test-")
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	oldTempDir, oldInitialRestartBackoff, oldMaxRestartBackoff, oldMaxRestartsInWindow := TempDir, initialRestartBackoff, maxRestartBackoff, maxRestartsInWindow
+
+	TempDir = socketDir
+	initialRestartBackoff = time.Millisecond
+	maxRestartBackoff = 10 * time.Millisecond
+	maxRestartsInWindow = 3
+
+	t.Cleanup(func() {
+		TempDir, initialRestartBackoff, maxRestartBackoff, maxRestartsInWindow = oldTempDir, oldInitialRestartBackoff, oldMaxRestartBackoff, oldMaxRestartsInWindow
+		os.RemoveAll(socketDir)
+	})
+}
+
+// Gets a backend that exits straight away, without ever connecting to the API
+func exitingBackendPath(t *testing.T) string {
+	t.Helper()
+
+	path, err := exec.LookPath("false")
+
+	if err != nil {
+		t.Skip("'false' is needed to run this test")
+	}
+
+	return path
+}
+
+func waitForState(t *testing.T, runtime *Runtime, expectedState RuntimeState) {
+	t.Helper()
+
+	timeout := time.After(5 * time.Second)
+
+	for {
+		if state, _ := runtime.GetState(); state == expectedState {
+			return
+		}
+
+		select {
+		case <-timeout:
+			state, restartCount := runtime.GetState()
+			t.Fatalf("backend never reached state '%s' (stuck in '%s' after %d restarts)", expectedState, state, restartCount)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestBackendExitingBeforeConnectingCrashLoops(t *testing.T) {
+	setUpTestRuntime(t)
+
+	runtime := NewBackend(exitingBackendPath(t))
+
+	if err := runtime.Start(); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	waitForState(t, runtime, StateCrashLoop)
+
+	if _, err := runtime.ProcessCommand(&commonbackend.BackendStatusRequest{Type: "backendStatusRequest"}); !errors.Is(err, ErrBackendCrashLoop) {
+		t.Fatalf("expected commands to fail with '%v', got '%v'", ErrBackendCrashLoop, err)
+	}
+
+	if err := runtime.Stop(); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	waitForState(t, runtime, StateStopped)
+}
+
+func TestPriorityCommandsSkipTheQueue(t *testing.T) {
+	runtime := NewBackend("")
+	runtime.Capabilities = []string{commonbackend.CapabilityPing}
+	runtime.pendingResponses = make(map[uint32]chan interface{})
+	runtime.commandQueue = make(chan *messageForBuf, commandQueueSize)
+	runtime.priorityCommandQueue = make(chan *messageForBuf, priorityCommandQueueSize)
+
+	// Fill up the regular queue first, so that the ping has to get past it
+	for range commandQueueSize {
+		runtime.commandQueue <- &messageForBuf{
+			Channel: make(chan interface{}, 1),
+			Message: &commonbackend.BackendStatusRequest{Type: "backendStatusRequest"},
+		}
+	}
+
+	runtime.priorityCommandQueue <- &messageForBuf{
+		Channel: make(chan interface{}, 1),
+		Message: &commonbackend.Ping{Type: "ping", Timestamp: time.Now()},
+	}
+
+	apiSide, backendSide := net.Pipe()
+	connectionClosed := make(chan struct{})
+
+	defer backendSide.Close()
+	defer close(connectionClosed)
+
+	go runtime.dispatchCommands(apiSide, connectionClosed)
+
+	_, commandType, _, err := commonbackend.UnmarshalWithRequestID(backendSide)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if commandType != "ping" {
+		t.Fatalf("expected the ping to be sent first (got '%s')", commandType)
+	}
+}
+
+func TestShutdownWithBackendsThatNeverConnect(t *testing.T) {
+	setUpTestRuntime(t)
+
+	sleepPath, err := exec.LookPath("sleep")
+
+	if err != nil {
+		t.Skip("'sleep' is needed to run this test")
+	}
+
+	// One backend is running, but never connects, and the other is in a crash loop
+	sleepingBackend := NewBackend(sleepPath)
+	sleepingBackend.ProcessArgs = []string{"60"}
+
+	crashingBackend := NewBackend(exitingBackendPath(t))
+
+	for _, runtime := range []*Runtime{sleepingBackend, crashingBackend} {
+		if err := runtime.Start(); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	waitForState(t, sleepingBackend, StateRunning)
+	waitForState(t, crashingBackend, StateCrashLoop)
+
+	AddRunningBackend(1, sleepingBackend)
+	AddRunningBackend(2, crashingBackend)
+
+	t.Cleanup(func() {
+		RemoveRunningBackend(1)
+		RemoveRunningBackend(2)
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	shutdownStartedAt := time.Now()
+	Shutdown(ctx)
+
+	// Neither of these have anything to wait on, so they should stop long before the deadline
+	if shutdownTime := time.Since(shutdownStartedAt); shutdownTime > 5*time.Second {
+		t.Fatalf("shutting down took %s", shutdownTime)
+	}
+
+	if _, err := os.Stat(TempDir); !os.IsNotExist(err) {
+		t.Fatalf("expected the socket directory to be removed (got '%v')", err)
+	}
+}
+
+func TestBackendInheritsMaxFrameSize(t *testing.T) {
+	setUpTestRuntime(t)
+
+	shellPath, err := exec.LookPath("sh")
+
+	if err != nil {
+		t.Skip("'sh' is needed to run this test")
+	}
+
+	runtime := NewBackend(shellPath)
+	runtime.ProcessArgs = []string{"-c", "echo $HERMES_MAX_FRAME_SIZE"}
+	runtime.RestartPolicy = RestartNever
+
+	logs, unsubscribe := runtime.SubscribeToLogs()
+	defer unsubscribe()
+
+	if err := runtime.Start(); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	defer runtime.Stop()
+
+	select {
+	case record := <-logs:
+		if expectedMessage := fmt.Sprintf("%d", commonbackend.MaxFrameSize); record.Message != expectedMessage {
+			t.Fatalf("expected the backend to get a max frame size of %s (got '%s')", expectedMessage, record.Message)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("backend never logged its max frame size")
+	}
+}
+
+func TestRunningBackendsHaveOneEventLogger(t *testing.T) {
+	runtime := NewBackend("")
+
+	subscriberCount := func() int {
+		runtime.eventSubscribersLock.Lock()
+		defer runtime.eventSubscribersLock.Unlock()
+
+		return len(runtime.eventSubscribers)
+	}
+
+	AddRunningBackend(1, runtime)
+	AddRunningBackend(1, runtime)
+	t.Cleanup(func() { RemoveRunningBackend(1) })
+
+	if count := subscriberCount(); count != 1 {
+		t.Fatalf("expected 1 event subscriber after adding the backend twice (got %d)", count)
+	}
+
+	RemoveRunningBackend(1)
+
+	if count := subscriberCount(); count != 0 {
+		t.Fatalf("expected no event subscribers after removing the backend (got %d)", count)
+	}
+}
+
+func TestOnlyMarkedLogLinesAreStructured(t *testing.T) {
+	runtime := NewBackend("")
+
+	line, err := commonbackend.EncodeLogLine(&commonbackend.LogMessage{
+		Type:    "logMessage",
+		Time:    time.Now(),
+		Level:   "warn",
+		Message: "marked",
+		Fields: []*commonbackend.LogField{
+			{Key: "err", Value: "connection refused"},
+		},
+	})
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	writer := writeLogger{Runtime: runtime, Stream: LogStreamStderr}
+	writer.Write([]byte(`{"level":"error","msg":"unmarked"}` + "\n"))
+	writer.Write(line)
+
+	logs, _ := runtime.GetLogs(LogQuery{})
+
+	if len(logs) != 2 {
+		t.Fatalf("expected 2 logs (got %d)", len(logs))
+	}
+
+	if logs[0].Level != "" || logs[0].Stream != LogStreamStderr {
+		t.Fatalf("expected the unmarked JSON line to be plain text (got level '%s' from '%s')", logs[0].Level, logs[0].Stream)
+	}
+
+	if logs[1].Level != "warn" || logs[1].Message != "marked" || logs[1].Fields["err"] != "connection refused" {
+		t.Fatalf("expected the marked line to be structured (got %+v)", logs[1])
+	}
+}
+
+func TestParametersSchemaLookupsGiveUpQuickly(t *testing.T) {
+	setUpTestRuntime(t)
+
+	shellPath, err := exec.LookPath("sh")
+
+	if err != nil {
+		t.Skip("'sh' is needed to run this test")
+	}
+
+	oldParametersSchemaTimeout := parametersSchemaTimeout
+	parametersSchemaTimeout = 200 * time.Millisecond
+	t.Cleanup(func() { parametersSchemaTimeout = oldParametersSchemaTimeout })
+
+	// Never connects, so it can't ever answer
+	backend := &Backend{
+		Name: "silent",
+		Path: shellPath,
+		Args: []string{"-c", "sleep 10"},
+	}
+
+	errs := make(chan error, 2)
+
+	for range 2 {
+		go func() {
+			_, err := backend.GetParametersSchema()
+			errs <- err
+		}()
+	}
+
+	timeout := time.After(5 * time.Second)
+
+	for range 2 {
+		select {
+		case err := <-errs:
+			if err == nil {
+				t.Fatal("expected the schema lookup to fail")
+			}
+		case <-timeout:
+			t.Fatal("schema lookup didn't give up in time")
+		}
+	}
+
+	if backend.hasFetchedParametersSchema {
+		t.Fatal("failed schema lookup was cached")
+	}
+}
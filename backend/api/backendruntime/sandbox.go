@@ -0,0 +1,191 @@
+package backendruntime
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Restricts what a backend process can do, so that a compromised backend can't reach the rest of the system (ex. the
+// API's database). Everything is optional. uid/gid, limits, namespaces, and landlock are only supported on Linux.
+type SandboxConfig struct {
+	// User and group to run the backend as. Changing these needs the API to be running as root.
+	UID *uint32 `json:"uid"`
+	GID *uint32 `json:"gid"`
+
+	// Directory to run the backend in. Relative paths are relative to the backend manifest.
+	WorkingDirectory string `json:"workingDirectory"`
+
+	// If set, the backend doesn't inherit the API's environment (which can have secrets in it, ex. the database's
+	// credentials), and only gets the variables Hermes needs, plus the ones in environment.
+	CleanEnvironment bool `json:"cleanEnvironment"`
+
+	// Extra environment variables for the backend. Entries can either be a name, which passes the variable through from
+	// the API's environment (for use with cleanEnvironment), or NAME=value.
+	Environment []string `json:"environment"`
+
+	Limits *ResourceLimits `json:"limits"`
+
+	// Linux namespaces to run the backend in. Can be "ipc", "pid", and "uts". These need the API to be running as root.
+	Namespaces []string `json:"namespaces"`
+
+	// Restricts which paths the backend can access, using Landlock (Linux 5.13+). The backend's own executable is
+	// always allowed. Keep in mind that dynamically linked backends need their libraries (ex. /lib, /lib64, and /usr/lib),
+	// and backends resolving hostnames need /etc/hosts and /etc/resolv.conf.
+	Landlock *LandlockConfig `json:"landlock"`
+}
+
+// Resource limits for a backend process. Limits that aren't set are inherited from the API.
+type ResourceLimits struct {
+	// Most files (and sockets) the backend can have open at once
+	OpenFiles *uint64 `json:"openFiles"`
+	// Most memory (in bytes) the backend can map, which has to be at least 16 MiB. This limits address space, not memory
+	// use, so it needs plenty of room over what the backend really uses. Go backends need about 1 GiB just to start.
+	Memory *uint64 `json:"memory"`
+	// Most processes (and threads) the backend's user can have. This counts everything the user is running, so it's
+	// best used with a dedicated uid.
+	Processes *uint64 `json:"processes"`
+}
+
+type LandlockConfig struct {
+	// Paths (and everything beneath them) the backend can read and execute
+	ReadOnly []string `json:"readOnly"`
+	// Paths (and everything beneath them) the backend can read, write, and execute
+	ReadWrite []string `json:"readWrite"`
+}
+
+var sandboxNamespaces = []string{"ipc", "pid", "uts"}
+
+// Smallest memory limit a backend can have. See ResourceLimits.Memory.
+const minimumMemoryLimit = 16 * 1024 * 1024
+
+// Backends that need limits or landlock applied are started through the API's own executable with this argument,
+// which applies them to itself, and then executes the backend. When the API is started with it, RunSandboxHelper
+// should be called before anything else.
+const SandboxHelperCommand = "__sandbox-exec"
+
+// Passes the sandbox config to the helper
+const sandboxConfigEnvironmentVariable = "HERMES_SANDBOX_CONFIG"
+
+// What the sandbox helper needs to apply the sandbox, and then start the backend
+type sandboxHelperConfig struct {
+	Path     string          `json:"path"`
+	Args     []string        `json:"args"`
+	Limits   *ResourceLimits `json:"limits"`
+	Landlock *LandlockConfig `json:"landlock"`
+}
+
+// Checks the sandbox config for mistakes, and resolves relative paths against the directory the backend manifest is in.
+func (sandbox *SandboxConfig) Prepare(manifestDirectory string) error {
+	if sandbox.WorkingDirectory != "" && !filepath.IsAbs(sandbox.WorkingDirectory) {
+		sandbox.WorkingDirectory = filepath.Join(manifestDirectory, sandbox.WorkingDirectory)
+	}
+
+	for _, variable := range sandbox.Environment {
+		name, _, _ := strings.Cut(variable, "=")
+
+		if name == "" {
+			return fmt.Errorf("invalid environment variable: %s", variable)
+		}
+
+		if isReservedEnvironmentVariable(name) || name == sandboxConfigEnvironmentVariable {
+			return fmt.Errorf("environment variable is reserved: %s", name)
+		}
+	}
+
+	for _, namespace := range sandbox.Namespaces {
+		if !slices.Contains(sandboxNamespaces, namespace) {
+			return fmt.Errorf("unknown namespace: %s (expected one of: %s)", namespace, strings.Join(sandboxNamespaces, ", "))
+		}
+	}
+
+	if sandbox.Limits != nil && sandbox.Limits.Memory != nil && *sandbox.Limits.Memory < minimumMemoryLimit {
+		return fmt.Errorf("memory limit must be at least %d bytes (16 MiB)", minimumMemoryLimit)
+	}
+
+	if sandbox.Landlock != nil {
+		for _, path := range slices.Concat(sandbox.Landlock.ReadOnly, sandbox.Landlock.ReadWrite) {
+			if !filepath.IsAbs(path) {
+				return fmt.Errorf("landlock paths must be absolute: %s", path)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Builds the environment for a backend, from the API's environment (unless the sandbox asks for a clean one), the
+// variables it gets regardless, and the ones the sandbox adds. Later entries win when a name is set more than once.
+func (sandbox *SandboxConfig) environment(baseEnvironment []string) []string {
+	environment := []string{}
+
+	if sandbox == nil || !sandbox.CleanEnvironment {
+		environment = append(environment, os.Environ()...)
+	}
+
+	environment = append(environment, baseEnvironment...)
+
+	if sandbox == nil {
+		return environment
+	}
+
+	for _, variable := range sandbox.Environment {
+		if strings.Contains(variable, "=") {
+			environment = append(environment, variable)
+		} else if value, ok := os.LookupEnv(variable); ok {
+			environment = append(environment, fmt.Sprintf("%s=%s", variable, value))
+		}
+	}
+
+	return environment
+}
+
+// Builds the command to run a backend with, inside of the sandbox (if there is one)
+func (sandbox *SandboxConfig) command(ctx context.Context, path string, args []string, baseEnvironment []string) (*exec.Cmd, error) {
+	cmd := exec.CommandContext(ctx, path, args...)
+	cmd.Env = sandbox.environment(baseEnvironment)
+
+	if sandbox == nil {
+		return cmd, nil
+	}
+
+	cmd.Dir = sandbox.WorkingDirectory
+
+	if err := applyPlatformSandbox(cmd, sandbox); err != nil {
+		return nil, err
+	}
+
+	return cmd, nil
+}
+
+// Gives the backend's user ownership of its socket, so it can still connect to it if it's running as a different user
+func (sandbox *SandboxConfig) ownSocket(path string) error {
+	if sandbox == nil || (sandbox.UID == nil && sandbox.GID == nil) {
+		return nil
+	}
+
+	uid, gid := -1, -1
+
+	if sandbox.UID != nil {
+		uid = int(*sandbox.UID)
+	}
+
+	if sandbox.GID != nil {
+		gid = int(*sandbox.GID)
+	}
+
+	if err := os.Chown(path, uid, gid); err != nil {
+		return fmt.Errorf("failed to give the backend ownership of its socket: %s", err.Error())
+	}
+
+	// Connecting to a socket needs write access to it
+	if err := os.Chmod(path, 0o660); err != nil {
+		return fmt.Errorf("failed to set the permissions of the backend's socket: %s", err.Error())
+	}
+
+	return nil
+}
@@ -0,0 +1,278 @@
+//go:build linux
+
+package backendruntime
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	goruntime "runtime"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+var namespaceCloneFlags = map[string]uintptr{
+	"ipc": syscall.CLONE_NEWIPC,
+	"pid": syscall.CLONE_NEWPID,
+	"uts": syscall.CLONE_NEWUTS,
+}
+
+const (
+	// Access rights supported by the first version of Landlock
+	landlockAccessV1 = unix.LANDLOCK_ACCESS_FS_EXECUTE | unix.LANDLOCK_ACCESS_FS_WRITE_FILE | unix.LANDLOCK_ACCESS_FS_READ_FILE |
+		unix.LANDLOCK_ACCESS_FS_READ_DIR | unix.LANDLOCK_ACCESS_FS_REMOVE_DIR | unix.LANDLOCK_ACCESS_FS_REMOVE_FILE |
+		unix.LANDLOCK_ACCESS_FS_MAKE_CHAR | unix.LANDLOCK_ACCESS_FS_MAKE_DIR | unix.LANDLOCK_ACCESS_FS_MAKE_REG |
+		unix.LANDLOCK_ACCESS_FS_MAKE_SOCK | unix.LANDLOCK_ACCESS_FS_MAKE_FIFO | unix.LANDLOCK_ACCESS_FS_MAKE_BLOCK |
+		unix.LANDLOCK_ACCESS_FS_MAKE_SYM
+
+	landlockReadOnlyAccess = unix.LANDLOCK_ACCESS_FS_EXECUTE | unix.LANDLOCK_ACCESS_FS_READ_FILE | unix.LANDLOCK_ACCESS_FS_READ_DIR
+
+	// Landlock only allows these to be granted on files. The rest only make sense on directories
+	landlockFileAccess = unix.LANDLOCK_ACCESS_FS_EXECUTE | unix.LANDLOCK_ACCESS_FS_WRITE_FILE | unix.LANDLOCK_ACCESS_FS_READ_FILE |
+		unix.LANDLOCK_ACCESS_FS_TRUNCATE | unix.LANDLOCK_ACCESS_FS_IOCTL_DEV
+)
+
+func applyPlatformSandbox(cmd *exec.Cmd, sandbox *SandboxConfig) error {
+	cmd.SysProcAttr = &syscall.SysProcAttr{}
+
+	if sandbox.UID != nil || sandbox.GID != nil {
+		credential := &syscall.Credential{
+			Uid: uint32(os.Getuid()),
+			Gid: uint32(os.Getgid()),
+			// Drops the supplementary groups, so the backend doesn't keep any of the API's
+			Groups: []uint32{},
+		}
+
+		if sandbox.UID != nil {
+			credential.Uid = *sandbox.UID
+		}
+
+		if sandbox.GID != nil {
+			credential.Gid = *sandbox.GID
+		}
+
+		cmd.SysProcAttr.Credential = credential
+	}
+
+	for _, namespace := range sandbox.Namespaces {
+		cloneFlag, ok := namespaceCloneFlags[namespace]
+
+		if !ok {
+			return fmt.Errorf("unknown namespace: %s", namespace)
+		}
+
+		cmd.SysProcAttr.Cloneflags |= cloneFlag
+	}
+
+	if sandbox.Limits == nil && sandbox.Landlock == nil {
+		return nil
+	}
+
+	// Limits and landlock can only be applied from inside of the process, so we go through the sandbox helper
+	executable, err := os.Executable()
+
+	if err != nil {
+		return fmt.Errorf("failed to find the sandbox helper: %s", err.Error())
+	}
+
+	helperConfig, err := json.Marshal(&sandboxHelperConfig{
+		Path:     cmd.Path,
+		Args:     cmd.Args[1:],
+		Limits:   sandbox.Limits,
+		Landlock: sandbox.Landlock,
+	})
+
+	if err != nil {
+		return fmt.Errorf("failed to marshal sandbox helper config: %s", err.Error())
+	}
+
+	cmd.Path = executable
+	cmd.Args = []string{executable, SandboxHelperCommand}
+	cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", sandboxConfigEnvironmentVariable, helperConfig))
+
+	return nil
+}
+
+// Applies the sandbox to the current process, and then executes the backend. This never returns. If the sandbox can't
+// be applied, the process exits, instead of running the backend without it.
+func RunSandboxHelper() {
+	if err := runSandboxHelper(); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to start backend in sandbox: %s\n", err.Error())
+		os.Exit(1)
+	}
+}
+
+func runSandboxHelper() error {
+	helperConfigJSON := os.Getenv(sandboxConfigEnvironmentVariable)
+
+	if err := os.Unsetenv(sandboxConfigEnvironmentVariable); err != nil {
+		return err
+	}
+
+	var helperConfig sandboxHelperConfig
+
+	if err := json.Unmarshal([]byte(helperConfigJSON), &helperConfig); err != nil {
+		return fmt.Errorf("failed to parse sandbox config: %s", err.Error())
+	}
+
+	if helperConfig.Limits != nil {
+		if err := applyResourceLimits(helperConfig.Limits); err != nil {
+			return err
+		}
+	}
+
+	// Landlock only restricts the thread that enables it (and what it executes), so we have to stay on it
+	goruntime.LockOSThread()
+
+	if helperConfig.Landlock != nil {
+		if err := applyLandlock(helperConfig.Landlock, helperConfig.Path); err != nil {
+			return err
+		}
+	}
+
+	// The memory limit is usually far less address space than we (and our threads) already have mapped, so once it's
+	// applied, the Go runtime can't count on being able to map anything else. Everything execve needs is built up front,
+	// and the limit is applied right before it, without going through anything that could allocate in between.
+	path, err := syscall.BytePtrFromString(helperConfig.Path)
+
+	if err != nil {
+		return fmt.Errorf("invalid backend path: %s", err.Error())
+	}
+
+	argv, err := syscall.SlicePtrFromStrings(append([]string{helperConfig.Path}, helperConfig.Args...))
+
+	if err != nil {
+		return fmt.Errorf("invalid backend arguments: %s", err.Error())
+	}
+
+	envv, err := syscall.SlicePtrFromStrings(os.Environ())
+
+	if err != nil {
+		return fmt.Errorf("invalid backend environment: %s", err.Error())
+	}
+
+	if helperConfig.Limits != nil && helperConfig.Limits.Memory != nil {
+		memoryLimit := &unix.Rlimit{Cur: *helperConfig.Limits.Memory, Max: *helperConfig.Limits.Memory}
+
+		if _, _, errno := unix.RawSyscall6(unix.SYS_PRLIMIT64, 0, unix.RLIMIT_AS, uintptr(unsafe.Pointer(memoryLimit)), 0, 0, 0); errno != 0 {
+			return fmt.Errorf("failed to set memory limit: %s", errno.Error())
+		}
+	}
+
+	_, _, errno := unix.RawSyscall(unix.SYS_EXECVE, uintptr(unsafe.Pointer(path)), uintptr(unsafe.Pointer(&argv[0])), uintptr(unsafe.Pointer(&envv[0])))
+	return fmt.Errorf("failed to execute backend: %s", errno.Error())
+}
+
+// Applies every resource limit besides the memory limit, which has to be applied last (see runSandboxHelper)
+func applyResourceLimits(limits *ResourceLimits) error {
+	resourceLimits := map[int]*uint64{
+		unix.RLIMIT_NOFILE: limits.OpenFiles,
+		unix.RLIMIT_NPROC:  limits.Processes,
+	}
+
+	for resource, limit := range resourceLimits {
+		if limit == nil {
+			continue
+		}
+
+		if err := unix.Setrlimit(resource, &unix.Rlimit{Cur: *limit, Max: *limit}); err != nil {
+			return fmt.Errorf("failed to set resource limit %d: %s", resource, err.Error())
+		}
+	}
+
+	return nil
+}
+
+func applyLandlock(config *LandlockConfig, executablePath string) error {
+	abiVersion, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, 0, 0, unix.LANDLOCK_CREATE_RULESET_VERSION)
+
+	if errno != 0 {
+		return fmt.Errorf("landlock is not supported on this system: %s", errno.Error())
+	}
+
+	var handledAccess uint64 = landlockAccessV1
+
+	if abiVersion >= 2 {
+		handledAccess |= unix.LANDLOCK_ACCESS_FS_REFER
+	}
+
+	if abiVersion >= 3 {
+		handledAccess |= unix.LANDLOCK_ACCESS_FS_TRUNCATE
+	}
+
+	if abiVersion >= 5 {
+		handledAccess |= unix.LANDLOCK_ACCESS_FS_IOCTL_DEV
+	}
+
+	rulesetAttr := unix.LandlockRulesetAttr{
+		Access_fs: handledAccess,
+	}
+
+	rulesetFD, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, uintptr(unsafe.Pointer(&rulesetAttr)), unsafe.Sizeof(rulesetAttr), 0)
+
+	if errno != 0 {
+		return fmt.Errorf("failed to create landlock ruleset: %s", errno.Error())
+	}
+
+	defer unix.Close(int(rulesetFD))
+
+	if err := addLandlockRule(int(rulesetFD), executablePath, landlockReadOnlyAccess&handledAccess); err != nil {
+		return err
+	}
+
+	for _, path := range config.ReadOnly {
+		if err := addLandlockRule(int(rulesetFD), path, landlockReadOnlyAccess&handledAccess); err != nil {
+			return err
+		}
+	}
+
+	for _, path := range config.ReadWrite {
+		if err := addLandlockRule(int(rulesetFD), path, handledAccess); err != nil {
+			return err
+		}
+	}
+
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return fmt.Errorf("failed to set no_new_privs: %s", err.Error())
+	}
+
+	if _, _, errno := unix.Syscall(unix.SYS_LANDLOCK_RESTRICT_SELF, rulesetFD, 0, 0); errno != 0 {
+		return fmt.Errorf("failed to enable landlock: %s", errno.Error())
+	}
+
+	return nil
+}
+
+func addLandlockRule(rulesetFD int, path string, access uint64) error {
+	pathFD, err := unix.Open(path, unix.O_PATH|unix.O_CLOEXEC, 0)
+
+	if err != nil {
+		return fmt.Errorf("failed to open landlock path '%s': %s", path, err.Error())
+	}
+
+	defer unix.Close(pathFD)
+
+	var stat unix.Stat_t
+
+	if err := unix.Fstat(pathFD, &stat); err != nil {
+		return fmt.Errorf("failed to stat landlock path '%s': %s", path, err.Error())
+	}
+
+	if stat.Mode&unix.S_IFMT != unix.S_IFDIR {
+		access &= landlockFileAccess
+	}
+
+	pathBeneathAttr := unix.LandlockPathBeneathAttr{
+		Allowed_access: access,
+		Parent_fd:      int32(pathFD),
+	}
+
+	if _, _, errno := unix.Syscall6(unix.SYS_LANDLOCK_ADD_RULE, uintptr(rulesetFD), unix.LANDLOCK_RULE_PATH_BENEATH, uintptr(unsafe.Pointer(&pathBeneathAttr)), 0, 0, 0); errno != 0 {
+		return fmt.Errorf("failed to add landlock rule for '%s': %s", path, errno.Error())
+	}
+
+	return nil
+}
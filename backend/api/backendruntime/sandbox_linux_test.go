@@ -0,0 +1,105 @@
+//go:build linux
+
+package backendruntime
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// The sandbox helper runs through the test binary, the same way it runs through the API
+func TestMain(m *testing.M) {
+	if len(os.Args) > 1 && os.Args[1] == SandboxHelperCommand {
+		RunSandboxHelper()
+	}
+
+	os.Exit(m.Run())
+}
+
+func TestSandboxHelperAppliesSmallMemoryLimit(t *testing.T) {
+	shellPath, err := exec.LookPath("sh")
+
+	if err != nil {
+		t.Skip("'sh' is needed to run this test")
+	}
+
+	// Backends get run without any arguments, so the backend here is a script that prints its memory limit
+	backendPath := filepath.Join(t.TempDir(), "backend.sh")
+
+	if err := os.WriteFile(backendPath, []byte("#!"+shellPath+"\nulimit -v\n"), 0o755); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// This is far less than the helper itself has mapped, so it only works if the limit is applied right before executing
+	memoryLimit := uint64(minimumMemoryLimit)
+
+	sandbox := &SandboxConfig{
+		Limits: &ResourceLimits{
+			Memory: &memoryLimit,
+		},
+	}
+
+	if err := sandbox.Prepare(""); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	cmd, err := sandbox.command(context.Background(), backendPath, nil, nil)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	output, err := cmd.CombinedOutput()
+
+	if err != nil {
+		t.Fatalf("sandboxed backend failed: %s (output: %q)", err.Error(), output)
+	}
+
+	// ulimit reports the limit in KiB
+	if limit := strings.TrimSpace(string(output)); limit != "16384" {
+		t.Fatalf("expected a memory limit of 16384 KiB (got %q)", limit)
+	}
+}
+
+func TestSandboxRejectsMemoryLimitsBelowTheMinimum(t *testing.T) {
+	memoryLimit := uint64(minimumMemoryLimit - 1)
+
+	sandbox := &SandboxConfig{
+		Limits: &ResourceLimits{
+			Memory: &memoryLimit,
+		},
+	}
+
+	if err := sandbox.Prepare(""); err == nil {
+		t.Fatal("expected a memory limit below the minimum to be rejected")
+	}
+}
+
+func TestBackendsOnlyGetTheAPIsEnvironmentUnlessItsClean(t *testing.T) {
+	t.Setenv("HERMES_TEST_SECRET", "hunter2")
+
+	hermesEnvironment := []string{"HERMES_API_SOCK=/tmp/test.sock"}
+
+	for _, sandbox := range []*SandboxConfig{nil, {}} {
+		if environment := sandbox.environment(hermesEnvironment); !slices.Contains(environment, "HERMES_TEST_SECRET=hunter2") {
+			t.Fatalf("expected the API's environment to be passed through (got %v)", environment)
+		}
+	}
+
+	sandbox := &SandboxConfig{
+		CleanEnvironment: true,
+		Environment:      []string{"PATH", "EXTRA=1"},
+	}
+
+	environment := sandbox.environment(hermesEnvironment)
+	expectedEnvironment := []string{"HERMES_API_SOCK=/tmp/test.sock", "PATH=" + os.Getenv("PATH"), "EXTRA=1"}
+
+	if !slices.Equal(environment, expectedEnvironment) {
+		t.Fatalf("expected a clean environment of %v (got %v)", expectedEnvironment, environment)
+	}
+}
@@ -0,0 +1,23 @@
+//go:build !linux
+
+package backendruntime
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+func applyPlatformSandbox(cmd *exec.Cmd, sandbox *SandboxConfig) error {
+	if sandbox.UID != nil || sandbox.GID != nil || sandbox.Limits != nil || len(sandbox.Namespaces) != 0 || sandbox.Landlock != nil {
+		return fmt.Errorf("uid, gid, limits, namespaces, and landlock are only supported on Linux")
+	}
+
+	return nil
+}
+
+// Only used on Linux. Exits straight away everywhere else.
+func RunSandboxHelper() {
+	fmt.Fprintln(os.Stderr, "the sandbox helper is only supported on Linux")
+	os.Exit(1)
+}
@@ -0,0 +1,138 @@
+package backendruntime
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"git.terah.dev/imterah/hermes/backend/commonbackend"
+	"github.com/charmbracelet/log"
+)
+
+// How long to wait for a backend to send its parameters schema. This includes starting up a temporary instance of it,
+// so it's kept short, as the schema is fetched while listing the available backends.
+var parametersSchemaTimeout = 10 * time.Second
+
+// A parameters schema lookup that's in progress, so that callers asking at the same time can share its result
+type parametersSchemaFetch struct {
+	done   chan struct{}
+	schema []byte
+	err    error
+}
+
+// Gets the JSON Schema the backend publishes for its parameters. It's asked for from a running instance of the backend if
+// there is one, or otherwise from a temporary instance that's started up just to ask. The result is cached after the
+// first successful lookup. Returns nil if the backend doesn't publish a schema.
+func (backend *Backend) GetParametersSchema() ([]byte, error) {
+	backend.parametersSchemaLock.Lock()
+
+	if backend.hasFetchedParametersSchema {
+		defer backend.parametersSchemaLock.Unlock()
+		return backend.parametersSchema, nil
+	}
+
+	// Someone else is already looking the schema up, so wait for them instead of starting another backend
+	if fetch := backend.parametersSchemaFetch; fetch != nil {
+		backend.parametersSchemaLock.Unlock()
+		<-fetch.done
+
+		return fetch.schema, fetch.err
+	}
+
+	fetch := &parametersSchemaFetch{
+		done: make(chan struct{}),
+	}
+
+	backend.parametersSchemaFetch = fetch
+	backend.parametersSchemaLock.Unlock()
+
+	// The lock isn't held while we wait on the backend, so that looking up the cached schema never has to wait on it
+	fetch.schema, fetch.err = backend.fetchParametersSchema()
+
+	backend.parametersSchemaLock.Lock()
+
+	if fetch.err == nil {
+		backend.parametersSchema = fetch.schema
+		backend.hasFetchedParametersSchema = true
+	}
+
+	backend.parametersSchemaFetch = nil
+	backend.parametersSchemaLock.Unlock()
+
+	close(fetch.done)
+
+	return fetch.schema, fetch.err
+}
+
+func (backend *Backend) fetchParametersSchema() ([]byte, error) {
+	if runtime := backend.findRunningInstance(); runtime != nil {
+		if !runtime.HasCapability(commonbackend.CapabilityParametersSchema) {
+			return nil, nil
+		}
+
+		return requestParametersSchema(runtime)
+	}
+
+	runtime := NewBackendFromManifest(backend)
+
+	if err := runtime.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start backend: %s", err.Error())
+	}
+
+	defer func() {
+		if err := runtime.Stop(); err != nil {
+			log.Warnf("Failed to stop backend: %s", err.Error())
+		}
+	}()
+
+	schema, err := requestParametersSchema(runtime)
+
+	// Older backends don't know about schemas, which isn't an error on our end
+	if err != nil && runtime.ProtocolVersion != 0 && !runtime.HasCapability(commonbackend.CapabilityParametersSchema) {
+		return nil, nil
+	}
+
+	return schema, err
+}
+
+// Finds a running backend that was started from this manifest entry, and is connected, so that it can be asked for
+// the schema instead of starting up a temporary instance
+func (backend *Backend) findRunningInstance() *Runtime {
+	for _, runtime := range GetRunningBackends() {
+		runtime.restartLock.Lock()
+		manifest := runtime.manifest
+		runtime.restartLock.Unlock()
+
+		if manifest != nil && manifest.Name == backend.Name && runtime.isConnected.Load() {
+			return runtime
+		}
+	}
+
+	return nil
+}
+
+func requestParametersSchema(runtime *Runtime) ([]byte, error) {
+	response, err := runtime.ProcessCommandWithTimeout(&commonbackend.ParametersSchemaRequest{
+		Type: "parametersSchemaRequest",
+	}, parametersSchemaTimeout)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to get response from backend: %s", err.Error())
+	}
+
+	schemaResponse, ok := response.(*commonbackend.ParametersSchemaResponse)
+
+	if !ok {
+		return nil, fmt.Errorf("got illegal response type: %T", response)
+	}
+
+	if len(schemaResponse.Schema) == 0 {
+		return nil, nil
+	}
+
+	if !json.Valid(schemaResponse.Schema) {
+		return nil, fmt.Errorf("backend sent a schema that isn't valid JSON")
+	}
+
+	return schemaResponse.Schema, nil
+}
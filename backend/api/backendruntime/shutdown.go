@@ -0,0 +1,123 @@
+package backendruntime
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"git.terah.dev/imterah/hermes/backend/commonbackend"
+	"github.com/charmbracelet/log"
+)
+
+// Stops every running backend, and then removes their sockets. Backends are asked to stop first, so that they can
+// close their connections cleanly, and are killed if they haven't exited by the time the context is done.
+func Shutdown(ctx context.Context) {
+	var waitGroup sync.WaitGroup
+
+	for backendID, runtime := range GetRunningBackends() {
+		waitGroup.Add(1)
+
+		go func() {
+			defer waitGroup.Done()
+
+			log.Debugf("Stopping backend #%d...", backendID)
+
+			if err := runtime.stopBackend(ctx); err != nil {
+				log.Warnf("Failed to stop backend #%d cleanly: %s", backendID, err.Error())
+			}
+
+			if err := runtime.Shutdown(ctx); err != nil {
+				log.Warnf("Failed to shut down backend #%d: %s", backendID, err.Error())
+				return
+			}
+
+			log.Infof("Stopped backend #%d", backendID)
+		}()
+	}
+
+	waitGroup.Wait()
+
+	if TempDir != "" {
+		if err := os.RemoveAll(TempDir); err != nil {
+			log.Warnf("Failed to remove the backend socket directory: %s", err.Error())
+		}
+	}
+}
+
+// Sends the stop command to the backend, so that it can shut down its proxies and connections
+func (runtime *Runtime) stopBackend(ctx context.Context) error {
+	// There's nothing to ask to stop if the backend never connected (or is waiting to be restarted)
+	if state, _ := runtime.GetState(); state != StateRunning || !runtime.isConnected.Load() {
+		return nil
+	}
+
+	timeout := DefaultCommandTimeout
+
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+	}
+
+	response, err := runtime.ProcessCommandWithTimeout(&commonbackend.Stop{
+		Type: "stop",
+	}, timeout)
+
+	if err != nil {
+		return err
+	}
+
+	switch response := response.(type) {
+	case *commonbackend.BackendStatusResponse:
+		if response.StatusCode == commonbackend.StatusFailure {
+			return fmt.Errorf("backend failed to stop: %s", response.Message)
+		}
+
+		return nil
+	default:
+		return fmt.Errorf("got illegal response type: %T", response)
+	}
+}
+
+// Stops the runtime like Stop does, but asks the backend's process to exit first, instead of killing it straight away.
+// If the process hasn't exited by the time the context is done, it gets killed. This only waits on the process itself,
+// so a backend that's waiting to be restarted (or never started) stops straight away.
+func (runtime *Runtime) Shutdown(ctx context.Context) error {
+	if !runtime.isRuntimeRunning.CompareAndSwap(true, false) {
+		return fmt.Errorf("runtime not running")
+	}
+
+	runtime.stopTrackingConnections()
+	close(runtime.stopNotification)
+
+	runtime.restartLock.Lock()
+	process, processExited, processPath := runtime.currentProcess, runtime.processExited, runtime.ProcessPath
+	runtime.restartLock.Unlock()
+
+	var err error
+
+	if process != nil && process.Process != nil {
+		// Not every platform can send signals (ex. Windows), so we fall back to killing the process
+		if err := process.Process.Signal(syscall.SIGTERM); err != nil && !errors.Is(err, os.ErrProcessDone) {
+			log.Debugf("Failed to ask backend '%s' to exit (killing it instead): %s", processPath, err.Error())
+			process.Process.Kill()
+		}
+
+		select {
+		case <-processExited:
+		case <-ctx.Done():
+			err = fmt.Errorf("backend did not exit in time, so it was killed")
+			process.Process.Kill()
+		}
+	}
+
+	if runtime.currentListener != nil {
+		if closeErr := runtime.currentListener.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("failed to stop listener: %s", closeErr.Error())
+		}
+	}
+
+	return err
+}
@@ -5,54 +5,170 @@ import (
 	"os/exec"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"git.terah.dev/imterah/hermes/backend/commonbackend"
 	"github.com/charmbracelet/log"
 )
 
+// A backend, as described in the backend manifest (ex. backends.json). See LoadManifest.
 type Backend struct {
-	Name string `validate:"required"`
-	Path string `validate:"required"`
+	Name string `json:"name" validate:"required"`
+	// Path to the backend's executable. Relative paths are relative to the manifest.
+	Path        string `json:"path" validate:"required"`
+	Description string `json:"description"`
+
+	// Arguments to run the backend with
+	Args []string `json:"args"`
+	// Environment variables to run the backend with
+	Environment map[string]string `json:"env"`
+	// SHA-256 hash (in hex) of the backend's executable. If set, the backend is checked against it every time it's started.
+	SHA256 string `json:"sha256" validate:"omitempty,len=64,hexadecimal"`
+
+	// Protocols the backend can forward. This is only used to describe the backend to users, as the backend
+	// advertises what it actually supports when it starts.
+	Protocols []string `json:"protocols" validate:"dive,oneof=tcp udp"`
+	// Oldest API version (see commonbackend.ProtocolVersion) the backend works with
+	MinimumAPIVersion uint16 `json:"minimumAPIVersion"`
+
+	// Restricts what the backend's process can do. Optional.
+	Sandbox *SandboxConfig `json:"sandbox"`
+
+	parametersSchemaLock       sync.Mutex
+	parametersSchema           []byte
+	hasFetchedParametersSchema bool
+	parametersSchemaFetch      *parametersSchemaFetch
+}
+
+// A single log line from a backend. Lines the backend printed to stdout/stderr directly don't have a level or fields.
+type LogRecord struct {
+	// Increases with every line logged, so it can be used to page through the logs
+	ID      uint64            `json:"id"`
+	Time    time.Time         `json:"time"`
+	Level   string            `json:"level,omitempty"`
+	Message string            `json:"message"`
+	Fields  map[string]string `json:"fields,omitempty"`
+	// Where the line came from (stdout, stderr, or socket)
+	Stream string `json:"stream"`
+	// Which run of the backend process logged this. This goes up every time the process is (re)started.
+	Generation int `json:"generation"`
 }
 
 type messageForBuf struct {
-	Channel chan interface{}
-	Message interface{}
+	Channel  chan interface{}
+	Message  interface{}
+	Deadline time.Time
 }
 
 type Runtime struct {
-	isRuntimeRunning           bool
-	logger                     *writeLogger
-	currentProcess             *exec.Cmd
-	currentListener            net.Listener
-	processRestartNotification chan bool
+	isRuntimeRunning atomic.Bool
+	currentProcess   *exec.Cmd
+	currentListener  net.Listener
+	// Set when the process gets restarted, and cleared once the restarted process connects and gets set back up
+	processRestarted atomic.Bool
+	// Set while the backend is connected, and has gotten through the handshake
+	isConnected atomic.Bool
+
+	// Commands waiting to be sent to the backend
+	commandQueue chan *messageForBuf
+	// Commands that get sent before anything in commandQueue (ex. pings), so that they aren't held up by a backlog
+	priorityCommandQueue chan *messageForBuf
+
+	pendingResponsesLock sync.Mutex
+	pendingResponses     map[uint32]chan interface{}
+	lastRequestID        uint32
+
+	// Set if the backend failed the handshake
+	incompatibilityErrorLock sync.Mutex
+	incompatibilityError     error
+
+	eventSubscribersLock sync.Mutex
+	eventSubscribers     []chan interface{}
 
-	messageBufferLock sync.Mutex
-	messageBuffer     []*messageForBuf
+	// Clients connected to the backend's proxies, going off of its connection events
+	connectionsLock         sync.Mutex
+	connections             map[string]*commonbackend.ProxyClientConnection
+	stopTrackingConnections func()
 
-	ProcessPath string
-	Logs        []string
+	// Logs are kept in a ring buffer, where logsStart is where the next log goes
+	logsLock       sync.Mutex
+	logs           []*LogRecord
+	logsStart      int
+	logsCount      int
+	lastLogID      uint64
+	generation     int
+	logSubscribers []chan *LogRecord
+
+	restartLock       sync.Mutex
+	state             RuntimeState
+	restartCount      int
+	recentRestarts    []time.Time
+	resetNotification chan struct{}
+	stopNotification  chan struct{}
+	// Closed once the runtime has stopped, and its process has exited
+	stoppedNotification chan struct{}
+	// Closed once the current process exits. Guarded by restartLock, along with currentProcess.
+	processExited chan struct{}
+	// Set when the process is being restarted on purpose (ex. after a manifest reload), so that it doesn't count as a crash
+	restartRequested bool
+
+	keepaliveLock sync.Mutex
+	latency       time.Duration
+	missedPings   int
+
+	// A manifest reload can swap the process settings out while the runtime is running, so once it has started, they're
+	// guarded by restartLock
+	ProcessPath        string
+	ProcessArgs        []string
+	ProcessEnvironment []string // NAME=value pairs
+	ProcessSHA256      string   // Checked before starting the process, if set
+	RestartPolicy      RestartPolicy
+	Sandbox            *SandboxConfig
+
+	// Manifest entry the runtime was created from, and the SHA-256 hash of the executable it last started. Used to
+	// figure out which runtimes need restarting when the manifest is reloaded.
+	manifest    *Backend
+	processHash string
+
+	// Filled in after the hello handshake with the backend
+	ProtocolVersion uint16
+	BackendName     string
+	BackendVersion  string
+	Capabilities    []string
 
 	OnCrashCallback func(sock net.Conn)
 }
 
 type writeLogger struct {
 	Runtime *Runtime
+	Stream  string
 }
 
 func (writer writeLogger) Write(p []byte) (n int, err error) {
 	logSplit := strings.Split(string(p), "\n")
 
-	if isDevelopmentMode {
-		for _, logLine := range logSplit {
-			if logLine == "" {
-				continue
-			}
+	for _, logLine := range logSplit {
+		if logLine == "" {
+			continue
+		}
+
+		// Backends that forward their logs fall back to printing them while they can't reach us
+		if logMessage, ok := commonbackend.DecodeLogLine([]byte(logLine)); ok {
+			writer.Runtime.addLogMessage(logMessage)
+			continue
+		}
 
+		if isDevelopmentMode {
 			log.Debug("spawned backend logs: " + logLine)
 		}
-	}
 
-	writer.Runtime.Logs = append(writer.Runtime.Logs, logSplit...)
+		writer.Runtime.addLog(&LogRecord{
+			Time:    time.Now(),
+			Message: logLine,
+			Stream:  writer.Stream,
+		})
+	}
 
 	return len(p), err
 }
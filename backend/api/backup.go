@@ -34,7 +34,7 @@ type BackupProxy struct {
 	Description     *string `json:"description"`
 	Protocol        string  `json:"protocol" validate:"required"`
 	SourceIP        string  `json:"sourceIP" validate:"required"`
-	SourcePort      uint16  `json:"sourcePort" validate:"required"`
+	SourcePort      uint16  `json:"sourcePort"`
 	DestinationPort uint16  `json:"destPort" validate:"required"`
 	AutoStart       bool    `json:"enabled" validate:"required"`
 }
@@ -280,6 +280,7 @@ func backupRestoreEntrypoint(cCtx *cli.Context) error {
 				Name:            proxy.Name,
 				Description:     proxy.Description,
 				Protocol:        proxy.Protocol,
+				SourceType:      dbcore.ProxySourceAddress,
 				SourceIP:        proxy.SourceIP,
 				SourcePort:      proxy.SourcePort,
 				DestinationPort: proxy.DestinationPort,
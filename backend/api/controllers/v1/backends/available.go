@@ -0,0 +1,123 @@
+package backends
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"git.terah.dev/imterah/hermes/backend/api/backendruntime"
+	"git.terah.dev/imterah/hermes/backend/api/jwtcore"
+	"git.terah.dev/imterah/hermes/backend/api/permissions"
+	"github.com/charmbracelet/log"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+)
+
+type AvailableBackendsRequest struct {
+	Token string `validate:"required"`
+}
+
+type AvailableBackend struct {
+	Name              string   `json:"name"`
+	Description       string   `json:"description"`
+	Protocols         []string `json:"protocols"`         // Protocols the backend says it can forward (ex. tcp), or null if it doesn't say
+	MinimumAPIVersion uint16   `json:"minimumAPIVersion"` // 0 if the backend doesn't have a minimum
+
+	// JSON Schema for the backend's connectionDetails, or null if the backend doesn't publish one.
+	// Sensitive fields are marked with "writeOnly": true
+	ParametersSchema json.RawMessage `json:"parametersSchema"`
+}
+
+type AvailableBackendsResponse struct {
+	Success bool                `json:"success"`
+	Data    []*AvailableBackend `json:"data"`
+}
+
+func GetAvailableBackends(c *gin.Context) {
+	var req AvailableBackendsRequest
+
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("Failed to parse body: %s", err.Error()),
+		})
+
+		return
+	}
+
+	if err := validator.New().Struct(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("Failed to validate body: %s", err.Error()),
+		})
+
+		return
+	}
+
+	user, err := jwtcore.GetUserFromJWT(req.Token)
+
+	if err != nil {
+		if err.Error() == "token is expired" || err.Error() == "user does not exist" {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": err.Error(),
+			})
+
+			return
+		} else {
+			log.Warnf("Failed to get user from the provided JWT token: %s", err.Error())
+
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to parse token",
+			})
+
+			return
+		}
+	}
+
+	if !permissions.UserHasPermission(user, "backends.visible") {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "Missing permissions",
+		})
+
+		return
+	}
+
+	manifestBackends := backendruntime.GetAvailableBackends()
+	availableBackends := make([]*AvailableBackend, len(manifestBackends))
+
+	// Backends that haven't had their schema cached yet have to be asked for it, so they're asked all at once, to not
+	// have one slow backend hold up the rest
+	var waitGroup sync.WaitGroup
+
+	for backendIndex, backend := range manifestBackends {
+		availableBackends[backendIndex] = &AvailableBackend{
+			Name:              backend.Name,
+			Description:       backend.Description,
+			Protocols:         backend.Protocols,
+			MinimumAPIVersion: backend.MinimumAPIVersion,
+		}
+
+		waitGroup.Add(1)
+
+		go func() {
+			defer waitGroup.Done()
+
+			schema, err := backend.GetParametersSchema()
+
+			if err != nil {
+				log.Warnf("Failed to get parameters schema for backend '%s': %s", backend.Name, err.Error())
+				return
+			}
+
+			if schema != nil {
+				availableBackends[backendIndex].ParametersSchema = schema
+			}
+		}()
+	}
+
+	waitGroup.Wait()
+
+	c.JSON(http.StatusOK, &AvailableBackendsResponse{
+		Success: true,
+		Data:    availableBackends,
+	})
+}
@@ -22,6 +22,7 @@ type BackendCreationRequest struct {
 	Description       *string
 	Backend           string      `validate:"required"`
 	BackendParameters interface{} `json:"connectionDetails" validate:"required"`
+	RestartPolicy     *string     `json:"restartPolicy" validate:"omitempty,oneof=always on-failure never"`
 }
 
 func CreateBackend(c *gin.Context) {
@@ -96,15 +97,9 @@ func CreateBackend(c *gin.Context) {
 		return
 	}
 
-	var backendRuntimeFilePath string
+	backendManifest, ok := backendruntime.GetAvailableBackend(req.Backend)
 
-	for _, runtime := range backendruntime.AvailableBackends {
-		if runtime.Name == req.Backend {
-			backendRuntimeFilePath = runtime.Path
-		}
-	}
-
-	if backendRuntimeFilePath == "" {
+	if !ok {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": "Unsupported backend recieved",
 		})
@@ -112,7 +107,14 @@ func CreateBackend(c *gin.Context) {
 		return
 	}
 
-	backend := backendruntime.NewBackend(backendRuntimeFilePath)
+	restartPolicy := backendruntime.RestartAlways
+
+	if req.RestartPolicy != nil {
+		restartPolicy = backendruntime.RestartPolicy(*req.RestartPolicy)
+	}
+
+	backend := backendruntime.NewBackendFromManifest(backendManifest)
+	backend.RestartPolicy = restartPolicy
 	err = backend.Start()
 
 	if err != nil {
@@ -133,15 +135,19 @@ func CreateBackend(c *gin.Context) {
 	if err != nil {
 		log.Warnf("Failed to get response for backend: %s", err.Error())
 
-		err = backend.Stop()
-
-		if err != nil {
+		if err := backend.Stop(); err != nil {
 			log.Warnf("Failed to stop backend: %s", err.Error())
 		}
 
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to get status response from backend",
-		})
+		if err == backendruntime.ErrCommandTimeout {
+			c.JSON(http.StatusGatewayTimeout, gin.H{
+				"error": "Timed out waiting for the backend to respond",
+			})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to get status response from backend",
+			})
+		}
 
 		return
 	}
@@ -197,6 +203,7 @@ func CreateBackend(c *gin.Context) {
 		Description:       req.Description,
 		Backend:           req.Backend,
 		BackendParameters: base64.StdEncoding.EncodeToString(backendParameters),
+		RestartPolicy:     string(restartPolicy),
 	}
 
 	if result := dbcore.DB.Create(&backendInDatabase); result.Error != nil {
@@ -223,15 +230,19 @@ func CreateBackend(c *gin.Context) {
 	if err != nil {
 		log.Warnf("Failed to get response for backend: %s", err.Error())
 
-		err = backend.Stop()
-
-		if err != nil {
+		if err := backend.Stop(); err != nil {
 			log.Warnf("Failed to stop backend: %s", err.Error())
 		}
 
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to get status response from backend",
-		})
+		if err == backendruntime.ErrCommandTimeout {
+			c.JSON(http.StatusGatewayTimeout, gin.H{
+				"error": "Timed out waiting for the backend to respond",
+			})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to get status response from backend",
+			})
+		}
 
 		return
 	}
@@ -263,7 +274,7 @@ func CreateBackend(c *gin.Context) {
 		log.Warnf("Got illegal response type for backend: %T", responseMessage)
 	}
 
-	backendruntime.RunningBackends[backendInDatabase.ID] = backend
+	backendruntime.AddRunningBackend(backendInDatabase.ID, backend)
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -0,0 +1,180 @@
+package backends
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"git.terah.dev/imterah/hermes/backend/api/backendruntime"
+	"git.terah.dev/imterah/hermes/backend/api/jwtcore"
+	"git.terah.dev/imterah/hermes/backend/api/permissions"
+	"github.com/charmbracelet/log"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+)
+
+// How often a comment is sent down idle log streams, so that proxies don't time them out
+const logStreamKeepaliveInterval = 15 * time.Second
+
+type BackendLogStreamRequest struct {
+	Token     string  `validate:"required"`
+	BackendID uint    `json:"id" validate:"required"`
+	LogLevel  *string `json:"logLevel"` // Only stream logs at or above this level (ex. warn)
+	After     *uint64 `json:"after"`    // Send the logs after the log with this ID first, to catch up on what was missed
+}
+
+// Streams a backend's logs as they're logged, as server-sent events. Each event has the log's ID as its ID, and the
+// log as its data. If the Last-Event-ID header is set, the stream resumes after that log.
+func StreamBackendLogs(c *gin.Context) {
+	var req BackendLogStreamRequest
+
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("Failed to parse body: %s", err.Error()),
+		})
+
+		return
+	}
+
+	if err := validator.New().Struct(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("Failed to validate body: %s", err.Error()),
+		})
+
+		return
+	}
+
+	user, err := jwtcore.GetUserFromJWT(req.Token)
+
+	if err != nil {
+		if err.Error() == "token is expired" || err.Error() == "user does not exist" {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": err.Error(),
+			})
+
+			return
+		} else {
+			log.Warnf("Failed to get user from the provided JWT token: %s", err.Error())
+
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to parse token",
+			})
+
+			return
+		}
+	}
+
+	if !permissions.UserHasPermission(user, "backends.visible") {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "Missing permissions",
+		})
+
+		return
+	}
+
+	logQuery := backendruntime.LogQuery{
+		MinimumLevel: log.DebugLevel,
+	}
+
+	if req.LogLevel != nil {
+		logQuery.MinimumLevel, err = log.ParseLevel(*req.LogLevel)
+
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Invalid log level",
+			})
+
+			return
+		}
+	}
+
+	if lastEventID := c.GetHeader("Last-Event-ID"); lastEventID != "" {
+		after, err := strconv.ParseUint(lastEventID, 10, 64)
+
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Invalid Last-Event-ID",
+			})
+
+			return
+		}
+
+		req.After = &after
+	}
+
+	backendInstance, ok := backendruntime.GetRunningBackend(req.BackendID)
+
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Could not find backend",
+		})
+
+		return
+	}
+
+	// We subscribe before catching up, so that nothing gets lost in between. Anything we get twice is skipped by its ID.
+	logChannel, unsubscribe := backendInstance.SubscribeToLogs()
+	defer unsubscribe()
+
+	var lastSentID uint64
+	var catchUpLogs []*backendruntime.LogRecord
+
+	if req.After != nil {
+		lastSentID = *req.After
+		catchUpLogs = backendInstance.GetLogsAfter(lastSentID, logQuery)
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	for _, record := range catchUpLogs {
+		if err := writeLogEvent(c.Writer, record); err != nil {
+			return
+		}
+
+		lastSentID = record.ID
+	}
+
+	c.Writer.Flush()
+
+	keepaliveTicker := time.NewTicker(logStreamKeepaliveInterval)
+	defer keepaliveTicker.Stop()
+
+	c.Stream(func(writer io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case <-keepaliveTicker.C:
+			_, err := fmt.Fprint(writer, ": keepalive\n\n")
+			return err == nil
+		case record, ok := <-logChannel:
+			if !ok {
+				return false
+			}
+
+			if record.ID <= lastSentID || !logQuery.Matches(record) {
+				return true
+			}
+
+			lastSentID = record.ID
+			return writeLogEvent(writer, record) == nil
+		}
+	})
+}
+
+func writeLogEvent(writer io.Writer, record *backendruntime.LogRecord) error {
+	recordJSON, err := json.Marshal(record)
+
+	if err != nil {
+		log.Warnf("Failed to marshal log: %s", err.Error())
+		return err
+	}
+
+	_, err = fmt.Fprintf(writer, "id: %d\nevent: log\ndata: %s\n\n", record.ID, recordJSON)
+	return err
+}
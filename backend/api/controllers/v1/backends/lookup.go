@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
 	"git.terah.dev/imterah/hermes/backend/api/backendruntime"
 	"git.terah.dev/imterah/hermes/backend/api/dbcore"
@@ -15,22 +16,35 @@ import (
 	"github.com/go-playground/validator/v10"
 )
 
+// How many logs are returned for each backend, if the lookup doesn't set a limit
+const defaultLogsLimit = 100
+
 type BackendLookupRequest struct {
 	Token       string `validate:"required"`
 	BackendID   *uint  `json:"id"`
 	Name        *string
 	Description *string
 	Backend     *string
+	LogLevel    *string    `json:"logLevel"`                                      // Only return logs at or above this level (ex. warn)
+	LogsSince   *time.Time `json:"logsSince"`                                     // Only return logs logged at or after this time
+	LogsBefore  *uint64    `json:"logsBefore"`                                    // Only return logs older than the log with this ID. Used to page back through the logs
+	LogsLimit   *int       `json:"logsLimit" validate:"omitempty,min=1,max=1000"` // Most logs to return for each backend. Defaults to 100
 }
 
 type SanitizedBackend struct {
-	Name              string   `json:"name"`
-	BackendID         uint     `json:"id"`
-	OwnerID           uint     `json:"ownerID"`
-	Description       *string  `json:"description,omitempty"`
-	Backend           string   `json:"backend"`
-	BackendParameters *string  `json:"connectionDetails,omitempty"`
-	Logs              []string `json:"logs"`
+	Name              string                      `json:"name"`
+	BackendID         uint                        `json:"id"`
+	OwnerID           uint                        `json:"ownerID"`
+	Description       *string                     `json:"description,omitempty"`
+	Backend           string                      `json:"backend"`
+	BackendParameters *string                     `json:"connectionDetails,omitempty"`
+	Logs              []*backendruntime.LogRecord `json:"logs"`
+	HasOlderLogs      bool                        `json:"hasOlderLogs"` // If there are older logs that didn't fit. Fetch them by setting logsBefore to the ID of the first log
+	LatencyMs         *float64                    `json:"latencyMs"`    // Round trip time of the last ping. Null if the backend hasn't answered one yet
+	MissedPings       int                         `json:"missedPings"`  // How many pings in a row the backend hasn't answered
+	RestartPolicy     string                      `json:"restartPolicy"`
+	State             string                      `json:"state"`        // ex. running, restarting, crashloop
+	RestartCount      int                         `json:"restartCount"` // How many times the backend has been restarted since it was started (or last reset)
 }
 
 type LookupResponse struct {
@@ -85,6 +99,35 @@ func LookupBackend(c *gin.Context) {
 		return
 	}
 
+	logQuery := backendruntime.LogQuery{
+		MinimumLevel: log.DebugLevel,
+		Limit:        defaultLogsLimit,
+	}
+
+	if req.LogLevel != nil {
+		logQuery.MinimumLevel, err = log.ParseLevel(*req.LogLevel)
+
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Invalid log level",
+			})
+
+			return
+		}
+	}
+
+	if req.LogsSince != nil {
+		logQuery.Since = *req.LogsSince
+	}
+
+	if req.LogsBefore != nil {
+		logQuery.Before = *req.LogsBefore
+	}
+
+	if req.LogsLimit != nil {
+		logQuery.Limit = *req.LogsLimit
+	}
+
 	backends := []dbcore.Backend{}
 	queryString := []string{}
 	queryParameters := []interface{}{}
@@ -123,7 +166,7 @@ func LookupBackend(c *gin.Context) {
 	hasSecretVisibility := permissions.UserHasPermission(user, "backends.secretVis")
 
 	for backendIndex, backend := range backends {
-		foundBackend, ok := backendruntime.RunningBackends[backend.ID]
+		foundBackend, ok := backendruntime.GetRunningBackend(backend.ID)
 
 		if !ok {
 			log.Warnf("Failed to get backend #%d controller", backend.ID)
@@ -141,7 +184,21 @@ func LookupBackend(c *gin.Context) {
 			Name:        backend.Name,
 			Description: backend.Description,
 			Backend:     backend.Backend,
-			Logs:        foundBackend.Logs,
+		}
+
+		sanitizedBackends[backendIndex].Logs, sanitizedBackends[backendIndex].HasOlderLogs = foundBackend.GetLogs(logQuery)
+
+		state, restartCount := foundBackend.GetState()
+		sanitizedBackends[backendIndex].RestartPolicy = string(foundBackend.RestartPolicy)
+		sanitizedBackends[backendIndex].State = string(state)
+		sanitizedBackends[backendIndex].RestartCount = restartCount
+
+		latency, missedPings := foundBackend.GetLatency()
+		sanitizedBackends[backendIndex].MissedPings = missedPings
+
+		if latency != 0 {
+			latencyMs := float64(latency.Microseconds()) / 1000
+			sanitizedBackends[backendIndex].LatencyMs = &latencyMs
 		}
 
 		if backend.UserID == user.ID || hasSecretVisibility {
@@ -98,7 +98,7 @@ func RemoveBackend(c *gin.Context) {
 		return
 	}
 
-	backendInstance, ok := backendruntime.RunningBackends[req.BackendID]
+	backendInstance, ok := backendruntime.GetRunningBackend(req.BackendID)
 
 	if ok {
 		err = backendInstance.Stop()
@@ -110,11 +110,11 @@ func RemoveBackend(c *gin.Context) {
 				"error": "Backend deleted, but failed to stop",
 			})
 
-			delete(backendruntime.RunningBackends, req.BackendID)
+			backendruntime.RemoveRunningBackend(req.BackendID)
 			return
 		}
 
-		delete(backendruntime.RunningBackends, req.BackendID)
+		backendruntime.RemoveRunningBackend(req.BackendID)
 	}
 
 	c.JSON(http.StatusOK, gin.H{
@@ -3,6 +3,7 @@ package proxies
 import (
 	"fmt"
 	"net/http"
+	"time"
 
 	"git.terah.dev/imterah/hermes/backend/api/backendruntime"
 	"git.terah.dev/imterah/hermes/backend/api/dbcore"
@@ -26,8 +27,12 @@ type ConnectionDetailsForConnection struct {
 }
 
 type SanitizedConnection struct {
-	ClientIP string `json:"ip"`
-	Port     uint16 `json:"port"`
+	ClientIP      string    `json:"ip"`
+	Port          uint16    `json:"port"`
+	Protocol      string    `json:"protocol"`
+	ConnectedAt   time.Time `json:"connectedAt"`
+	BytesSent     uint64    `json:"bytesSent"`
+	BytesReceived uint64    `json:"bytesReceived"`
 
 	ConnectionDetails *ConnectionDetailsForConnection `json:"connectionDetails"`
 }
@@ -106,7 +111,7 @@ func GetConnections(c *gin.Context) {
 		return
 	}
 
-	backendRuntime, ok := backendruntime.RunningBackends[proxy.BackendID]
+	backendRuntime, ok := backendruntime.GetRunningBackend(proxy.BackendID)
 
 	if !ok {
 		log.Warnf("Couldn't fetch backend runtime from backend ID #%d", proxy.BackendID)
@@ -125,6 +130,14 @@ func GetConnections(c *gin.Context) {
 	if err != nil {
 		log.Warnf("Failed to get response for backend: %s", err.Error())
 
+		if err == backendruntime.ErrCommandTimeout {
+			c.JSON(http.StatusGatewayTimeout, gin.H{
+				"error": "Timed out waiting for the backend to respond",
+			})
+
+			return
+		}
+
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Failed to get status response from backend",
 		})
@@ -137,10 +150,14 @@ func GetConnections(c *gin.Context) {
 		sanitizedConnections := []*SanitizedConnection{}
 
 		for _, connection := range responseMessage.Connections {
-			if connection.SourceIP == proxy.SourceIP && connection.SourcePort == proxy.SourcePort && proxy.DestinationPort == proxy.DestinationPort {
+			if connection.SourceIP == proxy.SourceIP && connection.SourcePort == proxy.SourcePort && connection.DestPort == proxy.DestinationPort && connection.Protocol == proxy.Protocol {
 				sanitizedConnections = append(sanitizedConnections, &SanitizedConnection{
-					ClientIP: connection.ClientIP,
-					Port:     connection.ClientPort,
+					ClientIP:      connection.ClientIP,
+					Port:          connection.ClientPort,
+					Protocol:      connection.Protocol,
+					ConnectedAt:   connection.ConnectionStarted,
+					BytesSent:     connection.BytesSent,
+					BytesReceived: connection.BytesReceived,
 
 					ConnectionDetails: &ConnectionDetailsForConnection{
 						SourceIP:   proxy.SourceIP,
@@ -19,8 +19,9 @@ type ProxyCreationRequest struct {
 	Name            string  `validate:"required" json:"name"`
 	Description     *string `json:"description"`
 	Protocol        string  `validate:"required" json:"protocol"`
-	SourceIP        string  `validate:"required" json:"sourceIP"`
-	SourcePort      uint16  `validate:"required" json:"sourcePort"`
+	SourceType      string  `validate:"omitempty,oneof=address unix" json:"sourceType"` // Defaults to "address"
+	SourceIP        string  `validate:"required" json:"sourceIP"`                       // IP address or hostname, or the socket path for Unix sockets
+	SourcePort      uint16  `json:"sourcePort"`                                         // Required, unless the source is a Unix socket
 	DestinationPort uint16  `validate:"required" json:"destinationPort"`
 	ProviderID      uint    `validate:"required" json:"providerID"`
 	AutoStart       *bool   `json:"autoStart"`
@@ -80,6 +81,47 @@ func CreateProxy(c *gin.Context) {
 		return
 	}
 
+	if req.SourceType == "" {
+		req.SourceType = dbcore.ProxySourceAddress
+	}
+
+	if req.SourceType == dbcore.ProxySourceUnix {
+		if req.Protocol != "tcp" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Unix socket sources only support TCP",
+			})
+
+			return
+		}
+
+		if !commonbackend.IsUnixSocketPath(req.SourceIP) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Source IP must be an absolute Unix socket path",
+			})
+
+			return
+		}
+
+		// The port is meaningless for Unix sockets, so don't store whatever we got sent.
+		req.SourcePort = 0
+	} else {
+		if commonbackend.IsUnixSocketPath(req.SourceIP) || !commonbackend.IsValidAddress(req.SourceIP) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Source IP must be an IP address or hostname",
+			})
+
+			return
+		}
+
+		if req.SourcePort == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Source port is required",
+			})
+
+			return
+		}
+	}
+
 	var backend dbcore.Backend
 	backendRequest := dbcore.DB.Where("id = ?", req.ProviderID).First(&backend)
 
@@ -99,6 +141,17 @@ func CreateProxy(c *gin.Context) {
 		})
 	}
 
+	// Check this before adding anything, so that we don't store a proxy the backend can never start
+	if req.SourceType == dbcore.ProxySourceUnix {
+		if backend, ok := backendruntime.GetRunningBackend(req.ProviderID); ok && !backend.HasCapability(commonbackend.CapabilityUnixSockets) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Backend does not support Unix socket sources",
+			})
+
+			return
+		}
+	}
+
 	autoStart := false
 
 	if req.AutoStart != nil {
@@ -111,6 +164,7 @@ func CreateProxy(c *gin.Context) {
 		Name:            req.Name,
 		Description:     req.Description,
 		Protocol:        req.Protocol,
+		SourceType:      req.SourceType,
 		SourceIP:        req.SourceIP,
 		SourcePort:      req.SourcePort,
 		DestinationPort: req.DestinationPort,
@@ -128,7 +182,7 @@ func CreateProxy(c *gin.Context) {
 	}
 
 	if autoStart {
-		backend, ok := backendruntime.RunningBackends[proxy.BackendID]
+		backend, ok := backendruntime.GetRunningBackend(proxy.BackendID)
 
 		if !ok {
 			log.Warnf("Couldn't fetch backend runtime from backend ID #%d", proxy.BackendID)
@@ -152,6 +206,14 @@ func CreateProxy(c *gin.Context) {
 		if err != nil {
 			log.Warnf("Failed to get response for backend #%d: %s", proxy.BackendID, err.Error())
 
+			if err == backendruntime.ErrCommandTimeout {
+				c.JSON(http.StatusGatewayTimeout, gin.H{
+					"error": "Timed out waiting for the backend to respond",
+				})
+
+				return
+			}
+
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"error": "failed to get response from backend",
 			})
@@ -0,0 +1,163 @@
+package proxies
+
+import (
+	"fmt"
+	"net/http"
+
+	"git.terah.dev/imterah/hermes/backend/api/backendruntime"
+	"git.terah.dev/imterah/hermes/backend/api/dbcore"
+	"git.terah.dev/imterah/hermes/backend/api/jwtcore"
+	"git.terah.dev/imterah/hermes/backend/api/permissions"
+	"git.terah.dev/imterah/hermes/backend/commonbackend"
+	"github.com/charmbracelet/log"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+)
+
+type KillConnectionRequest struct {
+	Token      string `validate:"required" json:"token"`
+	Id         uint   `validate:"required" json:"id"`
+	ClientIP   string `validate:"required,ip" json:"ip"`
+	ClientPort uint16 `validate:"required" json:"port"`
+}
+
+func KillConnection(c *gin.Context) {
+	var req KillConnectionRequest
+
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("Failed to parse body: %s", err.Error()),
+		})
+
+		return
+	}
+
+	if err := validator.New().Struct(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("Failed to validate body: %s", err.Error()),
+		})
+
+		return
+	}
+
+	user, err := jwtcore.GetUserFromJWT(req.Token)
+	if err != nil {
+		if err.Error() == "token is expired" || err.Error() == "user does not exist" {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": err.Error(),
+			})
+
+			return
+		} else {
+			log.Warnf("Failed to get user from the provided JWT token: %s", err.Error())
+
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to parse token",
+			})
+
+			return
+		}
+	}
+
+	if !permissions.UserHasPermission(user, "routes.killConn") {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "Missing permissions",
+		})
+
+		return
+	}
+
+	var proxy dbcore.Proxy
+	proxyRequest := dbcore.DB.Where("id = ?", req.Id).Find(&proxy)
+
+	if proxyRequest.Error != nil {
+		log.Warnf("failed to find proxy: %s", proxyRequest.Error.Error())
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to find forward entry",
+		})
+
+		return
+	}
+
+	proxyExists := proxyRequest.RowsAffected > 0
+
+	if !proxyExists {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "No forward entry found",
+		})
+
+		return
+	}
+
+	backendRuntime, ok := backendruntime.GetRunningBackend(proxy.BackendID)
+
+	if !ok {
+		log.Warnf("Couldn't fetch backend runtime from backend ID #%d", proxy.BackendID)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Couldn't fetch backend runtime",
+		})
+
+		return
+	}
+
+	if !backendRuntime.HasCapability(commonbackend.CapabilityKillConnection) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Backend does not support killing connections",
+		})
+
+		return
+	}
+
+	backendResponse, err := backendRuntime.ProcessCommand(&commonbackend.KillConnection{
+		Type:       "killConnection",
+		SourceIP:   proxy.SourceIP,
+		SourcePort: proxy.SourcePort,
+		DestPort:   proxy.DestinationPort,
+		ClientIP:   req.ClientIP,
+		ClientPort: req.ClientPort,
+		Protocol:   proxy.Protocol,
+	})
+
+	if err != nil {
+		log.Warnf("Failed to get response for backend #%d: %s", proxy.BackendID, err.Error())
+
+		if err == backendruntime.ErrCommandTimeout {
+			c.JSON(http.StatusGatewayTimeout, gin.H{
+				"error": "Timed out waiting for the backend to respond",
+			})
+
+			return
+		}
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get response from backend",
+		})
+
+		return
+	}
+
+	switch responseMessage := backendResponse.(type) {
+	case *commonbackend.KillConnectionResponse:
+		if !responseMessage.IsKilled {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("Failed to kill connection: %s", responseMessage.Message),
+			})
+
+			return
+		}
+	default:
+		log.Errorf("Got illegal response type for backend #%d: %T", proxy.BackendID, responseMessage)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Got illegal response type",
+		})
+
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+	})
+}
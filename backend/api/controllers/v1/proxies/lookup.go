@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"strings"
 
+	"git.terah.dev/imterah/hermes/backend/api/backendruntime"
 	"git.terah.dev/imterah/hermes/backend/api/dbcore"
 	"git.terah.dev/imterah/hermes/backend/api/jwtcore"
 	"git.terah.dev/imterah/hermes/backend/api/permissions"
@@ -31,11 +32,14 @@ type SanitizedProxy struct {
 	Name            string  `json:"name"`
 	Description     *string `json:"description,omitempty"`
 	Protcol         string  `json:"protocol"`
+	SourceType      string  `json:"sourceType"`
 	SourceIP        string  `json:"sourceIP"`
 	SourcePort      uint16  `json:"sourcePort"`
 	DestinationPort uint16  `json:"destPort"`
 	ProviderID      uint    `json:"providerID"`
 	AutoStart       bool    `json:"autoStart"`
+	// Only set if the backend is running, and tells us about connections as they come and go
+	ActiveConnections *int `json:"activeConnections,omitempty"`
 }
 
 type ProxyLookupResponse struct {
@@ -163,17 +167,30 @@ func LookupProxy(c *gin.Context) {
 	sanitizedProxies := make([]*SanitizedProxy, len(proxies))
 
 	for proxyIndex, proxy := range proxies {
+		sourceType := proxy.SourceType
+
+		if sourceType == "" {
+			sourceType = dbcore.ProxySourceAddress
+		}
+
 		sanitizedProxies[proxyIndex] = &SanitizedProxy{
 			Id:              proxy.ID,
 			Name:            proxy.Name,
 			Description:     proxy.Description,
 			Protcol:         proxy.Protocol,
+			SourceType:      sourceType,
 			SourceIP:        proxy.SourceIP,
 			SourcePort:      proxy.SourcePort,
 			DestinationPort: proxy.DestinationPort,
 			ProviderID:      proxy.BackendID,
 			AutoStart:       proxy.AutoStart,
 		}
+
+		if backendRuntime, ok := backendruntime.GetRunningBackend(proxy.BackendID); ok {
+			if activeConnections, ok := backendRuntime.CountConnections(proxy.SourceIP, proxy.SourcePort, proxy.DestinationPort, proxy.Protocol); ok {
+				sanitizedProxies[proxyIndex].ActiveConnections = &activeConnections
+			}
+		}
 	}
 
 	c.JSON(http.StatusOK, &ProxyLookupResponse{
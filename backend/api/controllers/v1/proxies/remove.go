@@ -3,6 +3,7 @@ package proxies
 import (
 	"fmt"
 	"net/http"
+	"time"
 
 	"git.terah.dev/imterah/hermes/backend/api/backendruntime"
 	"git.terah.dev/imterah/hermes/backend/api/dbcore"
@@ -17,6 +18,9 @@ import (
 type ProxyRemovalRequest struct {
 	Token string `validate:"required" json:"token"`
 	ID    uint   `validate:"required" json:"id"`
+
+	// If set, the backend stops accepting new connections, and gives existing ones this many seconds to finish
+	DrainSeconds uint16 `json:"drainSeconds"`
 }
 
 func RemoveProxy(c *gin.Context) {
@@ -88,6 +92,17 @@ func RemoveProxy(c *gin.Context) {
 		return
 	}
 
+	// Check this before deleting anything, so that a drain request the backend can't honor doesn't leave a half-removed proxy
+	if req.DrainSeconds > 0 {
+		if backend, ok := backendruntime.GetRunningBackend(proxy.BackendID); ok && !backend.HasCapability(commonbackend.CapabilityProxyDrain) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Backend does not support draining connections",
+			})
+
+			return
+		}
+	}
+
 	if err := dbcore.DB.Delete(proxy).Error; err != nil {
 		log.Warnf("failed to delete proxy: %s", err.Error())
 
@@ -98,7 +113,7 @@ func RemoveProxy(c *gin.Context) {
 		return
 	}
 
-	backend, ok := backendruntime.RunningBackends[proxy.BackendID]
+	backend, ok := backendruntime.GetRunningBackend(proxy.BackendID)
 
 	if !ok {
 		log.Warnf("Couldn't fetch backend runtime from backend ID #%d", proxy.BackendID)
@@ -110,17 +125,26 @@ func RemoveProxy(c *gin.Context) {
 		return
 	}
 
-	backendResponse, err := backend.ProcessCommand(&commonbackend.RemoveProxy{
-		Type:       "removeProxy",
-		SourceIP:   proxy.SourceIP,
-		SourcePort: proxy.SourcePort,
-		DestPort:   proxy.DestinationPort,
-		Protocol:   proxy.Protocol,
-	})
+	backendResponse, err := backend.ProcessCommandWithTimeout(&commonbackend.RemoveProxy{
+		Type:         "removeProxy",
+		SourceIP:     proxy.SourceIP,
+		SourcePort:   proxy.SourcePort,
+		DestPort:     proxy.DestinationPort,
+		Protocol:     proxy.Protocol,
+		DrainTimeout: time.Duration(req.DrainSeconds) * time.Second,
+	}, backendruntime.DefaultCommandTimeout+time.Duration(req.DrainSeconds)*time.Second)
 
 	if err != nil {
 		log.Warnf("Failed to get response for backend #%d: %s", proxy.BackendID, err.Error())
 
+		if err == backendruntime.ErrCommandTimeout {
+			c.JSON(http.StatusGatewayTimeout, gin.H{
+				"error": "Timed out waiting for the backend to respond. Proxy was still successfully deleted",
+			})
+
+			return
+		}
+
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Failed to get response from backend. Proxy was still successfully deleted",
 		})
@@ -137,6 +161,15 @@ func RemoveProxy(c *gin.Context) {
 
 			return
 		}
+
+		if req.DrainSeconds > 0 {
+			c.JSON(http.StatusOK, gin.H{
+				"success":              true,
+				"remainingConnections": responseMessage.RemainingConnections,
+			})
+
+			return
+		}
 	default:
 		log.Errorf("Got illegal response type for backend #%d: %T", proxy.BackendID, responseMessage)
 
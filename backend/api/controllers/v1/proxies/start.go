@@ -88,7 +88,7 @@ func StartProxy(c *gin.Context) {
 		return
 	}
 
-	backend, ok := backendruntime.RunningBackends[proxy.BackendID]
+	backend, ok := backendruntime.GetRunningBackend(proxy.BackendID)
 
 	if !ok {
 		log.Warnf("Couldn't fetch backend runtime from backend ID #%d", proxy.BackendID)
@@ -108,15 +108,25 @@ func StartProxy(c *gin.Context) {
 		Protocol:   proxy.Protocol,
 	})
 
-	switch responseMessage := backendResponse.(type) {
-	case error:
-		log.Warnf("Failed to get response for backend #%d: %s", proxy.BackendID, responseMessage.Error())
+	if err != nil {
+		log.Warnf("Failed to get response for backend #%d: %s", proxy.BackendID, err.Error())
+
+		if err == backendruntime.ErrCommandTimeout {
+			c.JSON(http.StatusGatewayTimeout, gin.H{
+				"error": "Timed out waiting for the backend to respond",
+			})
+
+			return
+		}
 
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "failed to get response from backend",
 		})
 
 		return
+	}
+
+	switch responseMessage := backendResponse.(type) {
 	case *commonbackend.ProxyStatusResponse:
 		if !responseMessage.IsActive {
 			c.JSON(http.StatusInternalServerError, gin.H{
@@ -3,6 +3,7 @@ package proxies
 import (
 	"fmt"
 	"net/http"
+	"time"
 
 	"git.terah.dev/imterah/hermes/backend/api/backendruntime"
 	"git.terah.dev/imterah/hermes/backend/api/dbcore"
@@ -17,6 +18,9 @@ import (
 type ProxyStopRequest struct {
 	Token string `validate:"required" json:"token"`
 	ID    uint   `validate:"required" json:"id"`
+
+	// If set, the backend stops accepting new connections, and gives existing ones this many seconds to finish
+	DrainSeconds uint16 `json:"drainSeconds"`
 }
 
 func StopProxy(c *gin.Context) {
@@ -88,7 +92,7 @@ func StopProxy(c *gin.Context) {
 		return
 	}
 
-	backend, ok := backendruntime.RunningBackends[proxy.BackendID]
+	backend, ok := backendruntime.GetRunningBackend(proxy.BackendID)
 
 	if !ok {
 		log.Warnf("Couldn't fetch backend runtime from backend ID #%d", proxy.BackendID)
@@ -100,17 +104,34 @@ func StopProxy(c *gin.Context) {
 		return
 	}
 
-	backendResponse, err := backend.ProcessCommand(&commonbackend.RemoveProxy{
-		Type:       "removeProxy",
-		SourceIP:   proxy.SourceIP,
-		SourcePort: proxy.SourcePort,
-		DestPort:   proxy.DestinationPort,
-		Protocol:   proxy.Protocol,
-	})
+	if req.DrainSeconds > 0 && !backend.HasCapability(commonbackend.CapabilityProxyDrain) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Backend does not support draining connections",
+		})
+
+		return
+	}
+
+	backendResponse, err := backend.ProcessCommandWithTimeout(&commonbackend.RemoveProxy{
+		Type:         "removeProxy",
+		SourceIP:     proxy.SourceIP,
+		SourcePort:   proxy.SourcePort,
+		DestPort:     proxy.DestinationPort,
+		Protocol:     proxy.Protocol,
+		DrainTimeout: time.Duration(req.DrainSeconds) * time.Second,
+	}, backendruntime.DefaultCommandTimeout+time.Duration(req.DrainSeconds)*time.Second)
 
 	if err != nil {
 		log.Warnf("Failed to get response for backend #%d: %s", proxy.BackendID, err.Error())
 
+		if err == backendruntime.ErrCommandTimeout {
+			c.JSON(http.StatusGatewayTimeout, gin.H{
+				"error": "Timed out waiting for the backend to respond",
+			})
+
+			return
+		}
+
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "failed to get response from backend",
 		})
@@ -127,6 +148,15 @@ func StopProxy(c *gin.Context) {
 
 			return
 		}
+
+		if req.DrainSeconds > 0 {
+			c.JSON(http.StatusOK, gin.H{
+				"success":              true,
+				"remainingConnections": responseMessage.RemainingConnections,
+			})
+
+			return
+		}
 	default:
 		log.Errorf("Got illegal response type for backend #%d: %T", proxy.BackendID, responseMessage)
 
@@ -18,10 +18,17 @@ type Backend struct {
 	Description       *string
 	Backend           string
 	BackendParameters string
+	RestartPolicy     string // Empty is treated as "always"
 
 	Proxies []Proxy
 }
 
+// Kinds of proxy sources
+const (
+	ProxySourceAddress = "address" // IP address or hostname, with a port
+	ProxySourceUnix    = "unix"    // Absolute Unix socket path, without a port
+)
+
 type Proxy struct {
 	gorm.Model
 
@@ -31,8 +38,9 @@ type Proxy struct {
 	Name            string
 	Description     *string
 	Protocol        string
-	SourceIP        string
-	SourcePort      uint16
+	SourceType      string // Empty is treated as "address"
+	SourceIP        string // IP address or hostname, or the Unix socket path if SourceType is "unix"
+	SourcePort      uint16 // Always 0 for Unix sockets
 	DestinationPort uint16
 	AutoStart       bool
 }
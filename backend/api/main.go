@@ -1,14 +1,17 @@
 package main
 
 import (
+	"context"
 	"encoding/base64"
-	"encoding/json"
 	"fmt"
 	"net"
+	"net/http"
 	"os"
-	"path"
-	"path/filepath"
+	"os/signal"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"git.terah.dev/imterah/hermes/backend/api/backendruntime"
 	"git.terah.dev/imterah/hermes/backend/api/controllers/v1/backends"
@@ -23,6 +26,66 @@ import (
 	"gorm.io/gorm"
 )
 
+func autoStartProxyCommands(autoStartProxies []dbcore.Proxy) []*commonbackend.AddProxy {
+	commands := make([]*commonbackend.AddProxy, len(autoStartProxies))
+
+	for proxyIndex, proxy := range autoStartProxies {
+		commands[proxyIndex] = &commonbackend.AddProxy{
+			Type:       "addProxy",
+			SourceIP:   proxy.SourceIP,
+			SourcePort: proxy.SourcePort,
+			DestPort:   proxy.DestinationPort,
+			Protocol:   proxy.Protocol,
+		}
+	}
+
+	return commands
+}
+
+func logAutoStartResults(backendID uint, autoStartProxies []dbcore.Proxy, results []*commonbackend.ProxyStatusResponse) {
+	failedProxies := 0
+
+	for proxyIndex, result := range results {
+		proxy := autoStartProxies[proxyIndex]
+
+		if result.IsActive {
+			log.Infof("Started up route #%d for backend #%d: %s", proxy.ID, backendID, proxy.Name)
+			continue
+		}
+
+		failedProxies++
+
+		if result.Message != "" {
+			log.Warnf("Failed to start proxy for backend #%d and route #%d: %s", backendID, proxy.ID, result.Message)
+		} else {
+			log.Warnf("Failed to start proxy for backend #%d and route #%d", backendID, proxy.ID)
+		}
+	}
+
+	if failedProxies != 0 {
+		log.Errorf("%d of %d auto-starting proxies failed to start for backend #%d", failedProxies, len(results), backendID)
+	}
+}
+
+// How long shutting down can take before backends get killed. Can be overridden (in seconds) with the
+// HERMES_SHUTDOWN_TIMEOUT environment variable.
+const defaultShutdownTimeout = 30 * time.Second
+
+// Reloads the backend manifest whenever we get a SIGHUP. Everything else is configured through the environment, which
+// can't change under us, so the manifest is all there is to reload.
+func reloadOnSIGHUP() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	for range signals {
+		log.Info("Received SIGHUP. Reloading backend manifest...")
+
+		if _, err := backendruntime.ReloadManifest(); err != nil {
+			log.Errorf("Failed to reload backend manifest (keeping the old one): %s", err.Error())
+		}
+	}
+}
+
 func apiEntrypoint(cCtx *cli.Context) error {
 	developmentMode := false
 
@@ -54,25 +117,19 @@ func apiEntrypoint(cCtx *cli.Context) error {
 
 	log.Debug("Initializing the backend subsystem...")
 
-	backendMetadataPath := cCtx.String("backends-path")
-	backendMetadata, err := os.ReadFile(backendMetadataPath)
+	backendManifestPath := cCtx.String("backends-path")
+	availableBackends, err := backendruntime.LoadManifest(backendManifestPath)
 
 	if err != nil {
-		return fmt.Errorf("Failed to read backends: %s", err.Error())
+		return fmt.Errorf("Failed to load backends: %s", err.Error())
 	}
 
-	availableBackends := []*backendruntime.Backend{}
-	err = json.Unmarshal(backendMetadata, &availableBackends)
+	backendruntime.Init(availableBackends, backendManifestPath)
 
-	if err != nil {
-		return fmt.Errorf("Failed to parse backends: %s", err.Error())
-	}
-
-	for _, backend := range availableBackends {
-		backend.Path = path.Join(filepath.Dir(backendMetadataPath), backend.Path)
-	}
-
-	backendruntime.Init(availableBackends)
+	// We start listening for these before starting any backends, so that they don't get left behind if we're asked to
+	// stop while starting up
+	shutdownSignal, stopListeningForShutdown := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopListeningForShutdown()
 
 	log.Debug("Enumerating backends...")
 
@@ -85,20 +142,22 @@ func apiEntrypoint(cCtx *cli.Context) error {
 	for _, backend := range backendList {
 		log.Infof("Starting up backend #%d: %s", backend.ID, backend.Name)
 
-		var backendRuntimeFilePath string
+		backendManifest, ok := backendruntime.GetAvailableBackend(backend.Backend)
 
-		for _, runtime := range backendruntime.AvailableBackends {
-			if runtime.Name == backend.Backend {
-				backendRuntimeFilePath = runtime.Path
-			}
-		}
-
-		if backendRuntimeFilePath == "" {
+		if !ok {
 			log.Errorf("Unsupported backend recieved for ID %d: %s", backend.ID, backend.Backend)
 			continue
 		}
 
-		backendInstance := backendruntime.NewBackend(backendRuntimeFilePath)
+		restartPolicy, err := backendruntime.ParseRestartPolicy(backend.RestartPolicy)
+
+		if err != nil {
+			log.Warnf("Invalid restart policy for backend #%d (using '%s' instead): %s", backend.ID, backendruntime.RestartAlways, err.Error())
+			restartPolicy = backendruntime.RestartAlways
+		}
+
+		backendInstance := backendruntime.NewBackendFromManifest(backendManifest)
+		backendInstance.RestartPolicy = restartPolicy
 
 		backendInstance.OnCrashCallback = func(conn net.Conn) {
 			backendParameters, err := base64.StdEncoding.DecodeString(backend.BackendParameters)
@@ -123,7 +182,7 @@ func apiEntrypoint(cCtx *cli.Context) error {
 				return
 			}
 
-			_, backendResponse, err := commonbackend.Unmarshal(conn)
+			_, backendResponse, err := backendInstance.ReadResponse(conn)
 
 			if err != nil {
 				log.Errorf("Failed to get start command response for backend #%d: %s", backend.ID, err.Error())
@@ -149,44 +208,14 @@ func apiEntrypoint(cCtx *cli.Context) error {
 				return
 			}
 
-			for _, proxy := range autoStartProxies {
-				log.Infof("Starting up route #%d for backend #%d: %s", proxy.ID, backend.ID, proxy.Name)
+			results, err := backendInstance.StartProxiesOnSocket(conn, autoStartProxyCommands(autoStartProxies))
 
-				marhalledCommand, err := commonbackend.Marshal("addProxy", &commonbackend.AddProxy{
-					Type:       "addProxy",
-					SourceIP:   proxy.SourceIP,
-					SourcePort: proxy.SourcePort,
-					DestPort:   proxy.DestinationPort,
-					Protocol:   proxy.Protocol,
-				})
-
-				if err != nil {
-					log.Errorf("Failed to marshal proxy adding request for backend #%d and route #%d: %s", proxy.BackendID, proxy.ID, err.Error())
-					continue
-				}
-
-				if _, err := conn.Write(marhalledCommand); err != nil {
-					log.Errorf("Failed to send proxy adding request for backend #%d and route #%d: %s", proxy.BackendID, proxy.ID, err.Error())
-					continue
-				}
-
-				_, backendResponse, err := commonbackend.Unmarshal(conn)
-
-				if err != nil {
-					log.Errorf("Failed to get response for backend #%d and route #%d: %s", proxy.BackendID, proxy.ID, err.Error())
-					continue
-				}
-
-				switch responseMessage := backendResponse.(type) {
-				case *commonbackend.ProxyStatusResponse:
-					if !responseMessage.IsActive {
-						log.Warnf("Failed to start proxy for backend #%d and route #%d", proxy.BackendID, proxy.ID)
-					}
-				default:
-					log.Errorf("Got illegal response type for backend #%d and proxy #%d: %T", proxy.BackendID, proxy.ID, responseMessage)
-					continue
-				}
+			if err != nil {
+				log.Errorf("Failed to start auto-starting proxies for backend #%d: %s", backend.ID, err.Error())
+				return
 			}
+
+			logAutoStartResults(backend.ID, autoStartProxies, results)
 		}
 
 		err = backendInstance.Start()
@@ -242,7 +271,7 @@ func apiEntrypoint(cCtx *cli.Context) error {
 			continue
 		}
 
-		backendruntime.RunningBackends[backend.ID] = backendInstance
+		backendruntime.AddRunningBackend(backend.ID, backendInstance)
 
 		log.Infof("Successfully initialized backend #%d", backend.ID)
 
@@ -253,33 +282,15 @@ func apiEntrypoint(cCtx *cli.Context) error {
 			continue
 		}
 
-		for _, proxy := range autoStartProxies {
-			log.Infof("Starting up route #%d for backend #%d: %s", proxy.ID, backend.ID, proxy.Name)
-
-			backendResponse, err := backendInstance.ProcessCommand(&commonbackend.AddProxy{
-				Type:       "addProxy",
-				SourceIP:   proxy.SourceIP,
-				SourcePort: proxy.SourcePort,
-				DestPort:   proxy.DestinationPort,
-				Protocol:   proxy.Protocol,
-			})
+		results, err := backendInstance.StartProxies(autoStartProxyCommands(autoStartProxies))
 
-			if err != nil {
-				log.Errorf("Failed to get response for backend #%d and route #%d: %s", proxy.BackendID, proxy.ID, err.Error())
-				continue
-			}
-
-			switch responseMessage := backendResponse.(type) {
-			case *commonbackend.ProxyStatusResponse:
-				if !responseMessage.IsActive {
-					log.Warnf("Failed to start proxy for backend #%d and route #%d", proxy.BackendID, proxy.ID)
-				}
-			default:
-				log.Errorf("Got illegal response type for backend #%d and proxy #%d: %T", proxy.BackendID, proxy.ID, responseMessage)
-				continue
-			}
+		if err != nil {
+			log.Errorf("Failed to start auto-starting proxies for backend #%d: %s", backend.ID, err.Error())
+			continue
 		}
 
+		logAutoStartResults(backend.ID, autoStartProxies, results)
+
 		log.Infof("Successfully started backend #%d", backend.ID)
 	}
 
@@ -323,6 +334,10 @@ func apiEntrypoint(cCtx *cli.Context) error {
 	engine.POST("/api/v1/backends/create", backends.CreateBackend)
 	engine.POST("/api/v1/backends/remove", backends.RemoveBackend)
 	engine.POST("/api/v1/backends/lookup", backends.LookupBackend)
+	engine.POST("/api/v1/backends/reset", backends.ResetBackend)
+	engine.POST("/api/v1/backends/reload", backends.ReloadBackendManifest)
+	engine.POST("/api/v1/backends/logs", backends.StreamBackendLogs)
+	engine.POST("/api/v1/backends/available", backends.GetAvailableBackends)
 
 	engine.POST("/api/v1/forward/create", proxies.CreateProxy)
 	engine.POST("/api/v1/forward/lookup", proxies.LookupProxy)
@@ -330,18 +345,67 @@ func apiEntrypoint(cCtx *cli.Context) error {
 	engine.POST("/api/v1/forward/start", proxies.StartProxy)
 	engine.POST("/api/v1/forward/stop", proxies.StopProxy)
 	engine.POST("/api/v1/forward/connections", proxies.GetConnections)
+	engine.POST("/api/v1/forward/connections/kill", proxies.KillConnection)
+
+	go reloadOnSIGHUP()
+
+	shutdownTimeout := defaultShutdownTimeout
+
+	if timeout, err := strconv.Atoi(os.Getenv("HERMES_SHUTDOWN_TIMEOUT")); err == nil && timeout > 0 {
+		shutdownTimeout = time.Duration(timeout) * time.Second
+	}
+
+	// Log streams only end once their request's context does, so the server can't finish shutting down until they're cancelled
+	requestContext, cancelRequests := context.WithCancel(context.Background())
+
+	server := &http.Server{
+		Addr:    listeningAddress,
+		Handler: engine.Handler(),
+		BaseContext: func(net.Listener) context.Context {
+			return requestContext
+		},
+	}
+
+	server.RegisterOnShutdown(cancelRequests)
+
+	serverError := make(chan error, 1)
+
+	go func() {
+		serverError <- server.ListenAndServe()
+	}()
 
 	log.Infof("Listening on '%s'", listeningAddress)
-	err = engine.Run(listeningAddress)
 
-	if err != nil {
+	select {
+	case err := <-serverError:
 		return fmt.Errorf("Error running web server: %s", err.Error())
+	case <-shutdownSignal.Done():
+	}
+
+	// If we get asked to stop again, we just exit straight away
+	stopListeningForShutdown()
+
+	log.Infof("Shutting down (waiting up to %s)...", shutdownTimeout)
+
+	shutdownContext, cancelShutdown := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancelShutdown()
+
+	if err := server.Shutdown(shutdownContext); err != nil {
+		log.Warnf("Failed to stop the web server cleanly: %s", err.Error())
 	}
 
+	backendruntime.Shutdown(shutdownContext)
+
+	log.Info("Hermes has shut down")
 	return nil
 }
 
 func main() {
+	// Backends that are sandboxed get started through us, so that we can apply the sandbox before they run
+	if len(os.Args) > 1 && os.Args[1] == backendruntime.SandboxHelperCommand {
+		backendruntime.RunSandboxHelper()
+	}
+
 	logLevel := os.Getenv("HERMES_LOG_LEVEL")
 
 	if logLevel != "" {
@@ -10,6 +10,7 @@ var DefaultPermissionNodes []string = []string{
 	"routes.edit",
 	"routes.visible",
 	"routes.visibleConn",
+	"routes.killConn",
 
 	"backends.add",
 	"backends.remove",
@@ -1,9 +1,13 @@
 package backendutil
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"os"
+	"slices"
+	"sync"
+	"time"
 
 	"git.terah.dev/imterah/hermes/backend/commonbackend"
 	"github.com/charmbracelet/log"
@@ -13,7 +17,13 @@ type BackendApplicationHelper struct {
 	Backend    BackendInterface
 	SocketPath string
 
-	socket net.Conn
+	// Advertised to the API during the hello handshake
+	Name         string
+	Version      string
+	Capabilities []string
+
+	socket          net.Conn
+	socketWriteLock sync.Mutex
 }
 
 func (helper *BackendApplicationHelper) Start() error {
@@ -26,265 +36,467 @@ func (helper *BackendApplicationHelper) Start() error {
 
 	log.Debug("Currently waiting for Unix socket connection...")
 
-	helper.socket, err = net.Dial("unix", helper.SocketPath)
+	socket, err := net.Dial("unix", helper.SocketPath)
 
 	if err != nil {
 		return err
 	}
 
+	return helper.Serve(socket)
+}
+
+// Handles commands from the API over an already established connection, until it closes. Start calls this for you,
+// but it's useful on its own for running a backend in-process (ex. in tests).
+func (helper *BackendApplicationHelper) Serve(socket net.Conn) error {
+	// Log forwarding and events can write to the socket from other goroutines, so we have to hold the lock here
+	helper.socketWriteLock.Lock()
+	helper.socket = socket
+	helper.socketWriteLock.Unlock()
+
 	log.Debug("Sucessfully connected")
 
 	for {
-		commandType, commandRaw, err := commonbackend.Unmarshal(helper.socket)
+		requestID, deadline, commandType, commandRaw, err := commonbackend.UnmarshalWithDeadline(helper.socket)
 
 		if err != nil {
 			return err
 		}
 
-		switch commandType {
-		case "start":
-			command, ok := commandRaw.(*commonbackend.Start)
+		// Commands are handled concurrently, so that a slow command (ex. adding a proxy) doesn't hold up other ones
+		go func() {
+			ctx, cancel := commandContext(deadline)
+			defer cancel()
 
-			if !ok {
-				return fmt.Errorf("failed to typecast")
+			if err := helper.handleCommand(ctx, requestID, commandType, commandRaw); err != nil {
+				log.Errorf("failed to handle command '%s': %s", commandType, err.Error())
+				helper.socket.Close()
 			}
+		}()
+	}
+}
 
-			ok, err = helper.Backend.StartBackend(command.Arguments)
+// Gets the context a command gets handled with, which is cancelled once the command's deadline (if it has one) passes
+func commandContext(deadline time.Time) (context.Context, context.CancelFunc) {
+	if deadline.IsZero() {
+		return context.WithCancel(context.Background())
+	}
 
-			var (
-				message    string
-				statusCode int
-			)
+	return context.WithDeadline(context.Background(), deadline)
+}
 
-			if err != nil {
-				message = err.Error()
-				statusCode = commonbackend.StatusFailure
-			} else {
-				statusCode = commonbackend.StatusSuccess
-			}
+func (helper *BackendApplicationHelper) writeMessage(requestID uint32, commandType string, command interface{}) error {
+	responseMarshalled, err := commonbackend.MarshalWithRequestID(requestID, commandType, command)
 
-			response := &commonbackend.BackendStatusResponse{
-				Type:       "backendStatusResponse",
-				IsRunning:  ok,
-				StatusCode: statusCode,
-				Message:    message,
-			}
+	if err != nil {
+		return fmt.Errorf("failed to marshal response: %s", err.Error())
+	}
 
-			responseMarshalled, err := commonbackend.Marshal(response.Type, response)
+	helper.socketWriteLock.Lock()
+	defer helper.socketWriteLock.Unlock()
 
-			if err != nil {
-				log.Error("failed to marshal response: %s", err.Error())
-				continue
-			}
+	if helper.socket == nil {
+		return fmt.Errorf("not connected to the API")
+	}
 
-			helper.socket.Write(responseMarshalled)
-		case "backendStatusRequest":
-			_, ok := commandRaw.(*commonbackend.BackendStatusRequest)
+	if _, err = helper.socket.Write(responseMarshalled); err != nil {
+		return fmt.Errorf("failed to write response: %s", err.Error())
+	}
 
-			if !ok {
-				return fmt.Errorf("failed to typecast")
-			}
+	return nil
+}
 
-			ok, err := helper.Backend.GetBackendStatus()
+func (helper *BackendApplicationHelper) handleCommand(ctx context.Context, requestID uint32, commandType string, commandRaw interface{}) error {
+	switch commandType {
+	case "hello":
+		command, ok := commandRaw.(*commonbackend.Hello)
 
-			var (
-				message    string
-				statusCode int
-			)
+		if !ok {
+			return fmt.Errorf("failed to typecast")
+		}
 
-			if err != nil {
-				message = err.Error()
-				statusCode = commonbackend.StatusFailure
-			} else {
-				statusCode = commonbackend.StatusSuccess
-			}
+		// If the API is older than us, we speak its version instead, so that it knows what to expect from us
+		protocolVersion := min(command.ProtocolVersion, commonbackend.ProtocolVersion)
+		log.Debugf("API speaks protocol version %d, so we're speaking version %d", command.ProtocolVersion, protocolVersion)
 
-			response := &commonbackend.BackendStatusResponse{
-				Type:       "backendStatusResponse",
-				IsRunning:  ok,
-				StatusCode: statusCode,
-				Message:    message,
-			}
+		response := &commonbackend.HelloResponse{
+			Type:            "helloResponse",
+			ProtocolVersion: protocolVersion,
+			BackendName:     helper.Name,
+			BackendVersion:  helper.Version,
+			Capabilities:    helper.getCapabilities(),
+		}
 
-			responseMarshalled, err := commonbackend.Marshal(response.Type, response)
+		return helper.writeMessage(requestID, response.Type, response)
+	case "ping":
+		command, ok := commandRaw.(*commonbackend.Ping)
 
-			if err != nil {
-				log.Error("failed to marshal response: %s", err.Error())
-				continue
-			}
+		if !ok {
+			return fmt.Errorf("failed to typecast")
+		}
 
-			helper.socket.Write(responseMarshalled)
-		case "stop":
-			_, ok := commandRaw.(*commonbackend.Stop)
+		response := &commonbackend.Pong{
+			Type:      "pong",
+			Nonce:     command.Nonce,
+			Timestamp: command.Timestamp,
+		}
 
-			if !ok {
-				return fmt.Errorf("failed to typecast")
-			}
+		return helper.writeMessage(requestID, response.Type, response)
+	case "start":
+		command, ok := commandRaw.(*commonbackend.Start)
+
+		if !ok {
+			return fmt.Errorf("failed to typecast")
+		}
+
+		var err error
+
+		if contextBackend, isContextBackend := helper.Backend.(BackendContextInterface); isContextBackend {
+			ok, err = contextBackend.StartBackendContext(ctx, command.Arguments)
+		} else {
+			ok, err = helper.Backend.StartBackend(command.Arguments)
+		}
+
+		var (
+			message    string
+			statusCode int
+		)
 
+		if err != nil {
+			message = err.Error()
+			statusCode = commonbackend.StatusFailure
+		} else {
+			statusCode = commonbackend.StatusSuccess
+		}
+
+		response := &commonbackend.BackendStatusResponse{
+			Type:       "backendStatusResponse",
+			IsRunning:  ok,
+			StatusCode: statusCode,
+			Message:    message,
+		}
+
+		return helper.writeMessage(requestID, response.Type, response)
+	case "backendStatusRequest":
+		_, ok := commandRaw.(*commonbackend.BackendStatusRequest)
+
+		if !ok {
+			return fmt.Errorf("failed to typecast")
+		}
+
+		ok, err := helper.Backend.GetBackendStatus()
+
+		var (
+			message    string
+			statusCode int
+		)
+
+		if err != nil {
+			message = err.Error()
+			statusCode = commonbackend.StatusFailure
+		} else {
+			statusCode = commonbackend.StatusSuccess
+		}
+
+		response := &commonbackend.BackendStatusResponse{
+			Type:       "backendStatusResponse",
+			IsRunning:  ok,
+			StatusCode: statusCode,
+			Message:    message,
+		}
+
+		return helper.writeMessage(requestID, response.Type, response)
+	case "stop":
+		_, ok := commandRaw.(*commonbackend.Stop)
+
+		if !ok {
+			return fmt.Errorf("failed to typecast")
+		}
+
+		var err error
+
+		if contextBackend, isContextBackend := helper.Backend.(BackendContextInterface); isContextBackend {
+			ok, err = contextBackend.StopBackendContext(ctx)
+		} else {
 			ok, err = helper.Backend.StopBackend()
+		}
 
-			var (
-				message    string
-				statusCode int
-			)
+		var (
+			message    string
+			statusCode int
+		)
 
-			if err != nil {
-				message = err.Error()
-				statusCode = commonbackend.StatusFailure
-			} else {
-				statusCode = commonbackend.StatusSuccess
-			}
+		if err != nil {
+			message = err.Error()
+			statusCode = commonbackend.StatusFailure
+		} else {
+			statusCode = commonbackend.StatusSuccess
+		}
 
-			response := &commonbackend.BackendStatusResponse{
-				Type:       "backendStatusResponse",
-				IsRunning:  !ok,
-				StatusCode: statusCode,
-				Message:    message,
-			}
+		response := &commonbackend.BackendStatusResponse{
+			Type:       "backendStatusResponse",
+			IsRunning:  !ok,
+			StatusCode: statusCode,
+			Message:    message,
+		}
 
-			responseMarshalled, err := commonbackend.Marshal(response.Type, response)
+		return helper.writeMessage(requestID, response.Type, response)
+	case "addProxy":
+		command, ok := commandRaw.(*commonbackend.AddProxy)
 
-			if err != nil {
-				log.Error("failed to marshal response: %s", err.Error())
-				continue
-			}
+		if !ok {
+			return fmt.Errorf("failed to typecast")
+		}
 
-			helper.socket.Write(responseMarshalled)
-		case "addProxy":
-			command, ok := commandRaw.(*commonbackend.AddProxy)
+		response := helper.startProxy(ctx, command)
+		return helper.writeMessage(requestID, response.Type, response)
+	case "removeProxy":
+		command, ok := commandRaw.(*commonbackend.RemoveProxy)
 
-			if !ok {
-				return fmt.Errorf("failed to typecast")
-			}
+		if !ok {
+			return fmt.Errorf("failed to typecast")
+		}
 
-			ok, err = helper.Backend.StartProxy(command)
-			var hasAnyFailed bool
+		response := helper.stopProxy(ctx, command)
+		return helper.writeMessage(requestID, response.Type, response)
+	case "addProxies":
+		command, ok := commandRaw.(*commonbackend.AddProxies)
 
-			if !ok {
-				log.Warnf("failed to add proxy (%s:%d -> remote:%d): StartProxy returned into failure state", command.SourceIP, command.SourcePort, command.DestPort)
-				hasAnyFailed = true
-			} else if err != nil {
-				log.Warnf("failed to add proxy (%s:%d -> remote:%d): %s", command.SourceIP, command.SourcePort, command.DestPort, err.Error())
-				hasAnyFailed = true
-			}
+		if !ok {
+			return fmt.Errorf("failed to typecast")
+		}
 
-			response := &commonbackend.ProxyStatusResponse{
-				Type:       "proxyStatusResponse",
-				SourceIP:   command.SourceIP,
-				SourcePort: command.SourcePort,
-				DestPort:   command.DestPort,
-				Protocol:   command.Protocol,
-				IsActive:   !hasAnyFailed,
-			}
+		response := &commonbackend.ProxiesStatusResponse{
+			Type: "proxiesStatusResponse",
+		}
 
-			responseMarshalled, err := commonbackend.Marshal(response.Type, response)
+		if batcher, ok := helper.Backend.(BackendProxyBatcher); ok {
+			response.Results = batcher.StartProxies(command.Proxies)
+		} else {
+			response.Results = make([]*commonbackend.ProxyStatusResponse, len(command.Proxies))
 
-			if err != nil {
-				log.Error("failed to marshal response: %s", err.Error())
-				continue
+			for proxyIndex, proxy := range command.Proxies {
+				response.Results[proxyIndex] = helper.startProxy(ctx, proxy)
 			}
+		}
 
-			helper.socket.Write(responseMarshalled)
-		case "removeProxy":
-			command, ok := commandRaw.(*commonbackend.RemoveProxy)
+		return helper.writeMessage(requestID, response.Type, response)
+	case "removeProxies":
+		command, ok := commandRaw.(*commonbackend.RemoveProxies)
 
-			if !ok {
-				return fmt.Errorf("failed to typecast")
-			}
+		if !ok {
+			return fmt.Errorf("failed to typecast")
+		}
 
-			ok, err = helper.Backend.StopProxy(command)
-			var hasAnyFailed bool
+		response := &commonbackend.ProxiesStatusResponse{
+			Type: "proxiesStatusResponse",
+		}
 
-			if !ok {
-				log.Warnf("failed to remove proxy (%s:%d -> remote:%d): RemoveProxy returned into failure state", command.SourceIP, command.SourcePort, command.DestPort)
-				hasAnyFailed = true
-			} else if err != nil {
-				log.Warnf("failed to remove proxy (%s:%d -> remote:%d): %s", command.SourceIP, command.SourcePort, command.DestPort, err.Error())
-				hasAnyFailed = true
-			}
+		if batcher, ok := helper.Backend.(BackendProxyBatcher); ok {
+			response.Results = batcher.StopProxies(command.Proxies)
+		} else {
+			response.Results = make([]*commonbackend.ProxyStatusResponse, len(command.Proxies))
 
-			response := &commonbackend.ProxyStatusResponse{
-				Type:       "proxyStatusResponse",
-				SourceIP:   command.SourceIP,
-				SourcePort: command.SourcePort,
-				DestPort:   command.DestPort,
-				Protocol:   command.Protocol,
-				IsActive:   hasAnyFailed,
+			for proxyIndex, proxy := range command.Proxies {
+				response.Results[proxyIndex] = helper.stopProxy(ctx, proxy)
 			}
+		}
 
-			responseMarshalled, err := commonbackend.Marshal(response.Type, response)
+		return helper.writeMessage(requestID, response.Type, response)
+	case "proxyConnectionsRequest":
+		_, ok := commandRaw.(*commonbackend.ProxyConnectionsRequest)
 
-			if err != nil {
-				log.Error("failed to marshal response: %s", err.Error())
-				continue
-			}
+		if !ok {
+			return fmt.Errorf("failed to typecast")
+		}
 
-			helper.socket.Write(responseMarshalled)
-		case "proxyConnectionsRequest":
-			_, ok := commandRaw.(*commonbackend.ProxyConnectionsRequest)
+		connections := helper.Backend.GetAllClientConnections()
 
-			if !ok {
-				return fmt.Errorf("failed to typecast")
-			}
+		serverParams := &commonbackend.ProxyConnectionsResponse{
+			Type:        "proxyConnectionsResponse",
+			Connections: connections,
+		}
 
-			connections := helper.Backend.GetAllClientConnections()
+		return helper.writeMessage(requestID, serverParams.Type, serverParams)
+	case "checkClientParameters":
+		command, ok := commandRaw.(*commonbackend.CheckClientParameters)
 
-			serverParams := &commonbackend.ProxyConnectionsResponse{
-				Type:        "proxyConnectionsResponse",
-				Connections: connections,
-			}
+		if !ok {
+			return fmt.Errorf("failed to typecast")
+		}
+
+		resp := helper.Backend.CheckParametersForConnections(command)
+		resp.Type = "checkParametersResponse"
+		resp.InResponseTo = "checkClientParameters"
+
+		return helper.writeMessage(requestID, resp.Type, resp)
+	case "killConnection":
+		command, ok := commandRaw.(*commonbackend.KillConnection)
+
+		if !ok {
+			return fmt.Errorf("failed to typecast")
+		}
+
+		response := &commonbackend.KillConnectionResponse{
+			Type: "killConnectionResponse",
+		}
 
-			byteData, err := commonbackend.Marshal(serverParams.Type, serverParams)
+		if connectionKiller, ok := helper.Backend.(BackendConnectionKiller); ok {
+			ok, err := connectionKiller.KillConnection(command)
+			response.IsKilled = ok
 
 			if err != nil {
-				return err
+				response.Message = err.Error()
 			}
+		} else {
+			response.Message = "backend does not support killing connections"
+		}
 
-			if _, err = helper.socket.Write(byteData); err != nil {
-				return err
-			}
-		case "checkClientParameters":
-			command, ok := commandRaw.(*commonbackend.CheckClientParameters)
+		return helper.writeMessage(requestID, response.Type, response)
+	case "parametersSchemaRequest":
+		_, ok := commandRaw.(*commonbackend.ParametersSchemaRequest)
 
-			if !ok {
-				return fmt.Errorf("failed to typecast")
-			}
+		if !ok {
+			return fmt.Errorf("failed to typecast")
+		}
 
-			resp := helper.Backend.CheckParametersForConnections(command)
-			resp.Type = "checkParametersResponse"
-			resp.InResponseTo = "checkClientParameters"
+		response := &commonbackend.ParametersSchemaResponse{
+			Type: "parametersSchemaResponse",
+		}
 
-			byteData, err := commonbackend.Marshal(resp.Type, resp)
+		if schemaProvider, ok := helper.Backend.(BackendParametersSchemaProvider); ok {
+			response.Schema = schemaProvider.GetParametersSchema()
+		}
 
-			if err != nil {
-				return err
-			}
+		return helper.writeMessage(requestID, response.Type, response)
+	case "checkServerParameters":
+		command, ok := commandRaw.(*commonbackend.CheckServerParameters)
 
-			if _, err = helper.socket.Write(byteData); err != nil {
-				return err
-			}
-		case "checkServerParameters":
-			command, ok := commandRaw.(*commonbackend.CheckServerParameters)
+		if !ok {
+			return fmt.Errorf("failed to typecast")
+		}
 
-			if !ok {
-				return fmt.Errorf("failed to typecast")
-			}
+		resp := helper.Backend.CheckParametersForBackend(command.Arguments)
+		resp.Type = "checkParametersResponse"
+		resp.InResponseTo = "checkServerParameters"
 
-			resp := helper.Backend.CheckParametersForBackend(command.Arguments)
-			resp.Type = "checkParametersResponse"
-			resp.InResponseTo = "checkServerParameters"
+		return helper.writeMessage(requestID, resp.Type, resp)
+	}
 
-			byteData, err := commonbackend.Marshal(resp.Type, resp)
+	return nil
+}
 
-			if err != nil {
-				return err
-			}
+func (helper *BackendApplicationHelper) startProxy(ctx context.Context, command *commonbackend.AddProxy) *commonbackend.ProxyStatusResponse {
+	response := &commonbackend.ProxyStatusResponse{
+		Type:       "proxyStatusResponse",
+		SourceIP:   command.SourceIP,
+		SourcePort: command.SourcePort,
+		DestPort:   command.DestPort,
+		Protocol:   command.Protocol,
+	}
 
-			if _, err = helper.socket.Write(byteData); err != nil {
-				return err
-			}
+	// Batches go through their proxies one at a time, so the API might have given up on the batch by the time we get here
+	if err := ctx.Err(); err != nil {
+		response.Message = fmt.Sprintf("gave up before adding the proxy: %s", err.Error())
+		return response
+	}
+
+	var (
+		ok  bool
+		err error
+	)
+
+	if contextBackend, isContextBackend := helper.Backend.(BackendContextInterface); isContextBackend {
+		ok, err = contextBackend.StartProxyContext(ctx, command)
+	} else {
+		ok, err = helper.Backend.StartProxy(command)
+	}
+
+	if err != nil {
+		log.Warnf("failed to add proxy (%s:%d -> remote:%d): %s", command.SourceIP, command.SourcePort, command.DestPort, err.Error())
+		response.Message = err.Error()
+	} else if !ok {
+		log.Warnf("failed to add proxy (%s:%d -> remote:%d): StartProxy returned into failure state", command.SourceIP, command.SourcePort, command.DestPort)
+	}
+
+	response.IsActive = ok && err == nil
+	return response
+}
+
+func (helper *BackendApplicationHelper) stopProxy(ctx context.Context, command *commonbackend.RemoveProxy) *commonbackend.ProxyStatusResponse {
+	response := &commonbackend.ProxyStatusResponse{
+		Type:       "proxyStatusResponse",
+		SourceIP:   command.SourceIP,
+		SourcePort: command.SourcePort,
+		DestPort:   command.DestPort,
+		Protocol:   command.Protocol,
+	}
+
+	// Same as in startProxy. The proxy is left running, as we never got around to removing it.
+	if err := ctx.Err(); err != nil {
+		response.IsActive = true
+		response.Message = fmt.Sprintf("gave up before removing the proxy: %s", err.Error())
+		return response
+	}
+
+	if drainer, isDrainer := helper.Backend.(BackendProxyDrainer); isDrainer && command.DrainTimeout > 0 {
+		remainingConnections, err := drainer.DrainProxy(command)
+		response.RemainingConnections = remainingConnections
+
+		if err != nil {
+			log.Warnf("failed to drain proxy (%s:%d -> remote:%d): %s", command.SourceIP, command.SourcePort, command.DestPort, err.Error())
+			response.IsActive = true
+			response.Message = err.Error()
+		}
+
+		return response
+	}
+
+	var (
+		ok  bool
+		err error
+	)
+
+	if contextBackend, isContextBackend := helper.Backend.(BackendContextInterface); isContextBackend {
+		ok, err = contextBackend.StopProxyContext(ctx, command)
+	} else {
+		ok, err = helper.Backend.StopProxy(command)
+	}
+
+	if err != nil {
+		log.Warnf("failed to remove proxy (%s:%d -> remote:%d): %s", command.SourceIP, command.SourcePort, command.DestPort, err.Error())
+		response.Message = err.Error()
+	} else if !ok {
+		log.Warnf("failed to remove proxy (%s:%d -> remote:%d): RemoveProxy returned into failure state", command.SourceIP, command.SourcePort, command.DestPort)
+	}
+
+	response.IsActive = !ok || err != nil
+	return response
+}
+
+// Gets the capabilities to advertise, including the ones implied by the optional interfaces the backend implements
+func (helper *BackendApplicationHelper) getCapabilities() []string {
+	capabilities := append([]string{}, helper.Capabilities...)
+
+	// Pings and batches are handled by us (batches fall back to one proxy at a time), so every backend built on the helper supports them
+	for _, capability := range []string{commonbackend.CapabilityPing, commonbackend.CapabilityBatchProxies} {
+		if !slices.Contains(capabilities, capability) {
+			capabilities = append(capabilities, capability)
 		}
 	}
+
+	if _, ok := helper.Backend.(BackendConnectionKiller); ok && !slices.Contains(capabilities, commonbackend.CapabilityKillConnection) {
+		capabilities = append(capabilities, commonbackend.CapabilityKillConnection)
+	}
+
+	if _, ok := helper.Backend.(BackendParametersSchemaProvider); ok && !slices.Contains(capabilities, commonbackend.CapabilityParametersSchema) {
+		capabilities = append(capabilities, commonbackend.CapabilityParametersSchema)
+	}
+
+	if _, ok := helper.Backend.(BackendProxyDrainer); ok && !slices.Contains(capabilities, commonbackend.CapabilityProxyDrain) {
+		capabilities = append(capabilities, commonbackend.CapabilityProxyDrain)
+	}
+
+	return capabilities
 }
 
 func NewHelper(backend BackendInterface) *BackendApplicationHelper {
@@ -295,8 +507,9 @@ func NewHelper(backend BackendInterface) *BackendApplicationHelper {
 	}
 
 	helper := &BackendApplicationHelper{
-		Backend:    backend,
-		SocketPath: socketPath,
+		Backend:      backend,
+		SocketPath:   socketPath,
+		Capabilities: []string{commonbackend.CapabilityTCP},
 	}
 
 	return helper
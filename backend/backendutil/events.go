@@ -0,0 +1,40 @@
+package backendutil
+
+import "git.terah.dev/imterah/hermes/backend/commonbackend"
+
+// Sends an unsolicited event to the API. Events always use a request ID of 0, as they aren't in response to anything.
+func (helper *BackendApplicationHelper) SendEvent(eventType string, event interface{}) error {
+	return helper.writeMessage(0, eventType, event)
+}
+
+func (helper *BackendApplicationHelper) SendConnectionOpened(connection *commonbackend.ProxyClientConnection) error {
+	return helper.SendEvent("connectionOpened", &commonbackend.ConnectionOpened{
+		Type:       "connectionOpened",
+		Connection: connection,
+	})
+}
+
+func (helper *BackendApplicationHelper) SendConnectionClosed(connection *commonbackend.ProxyClientConnection) error {
+	return helper.SendEvent("connectionClosed", &commonbackend.ConnectionClosed{
+		Type:       "connectionClosed",
+		Connection: connection,
+	})
+}
+
+func (helper *BackendApplicationHelper) SendProxyFailed(sourceIP string, sourcePort, destPort uint16, protocol, message string) error {
+	return helper.SendEvent("proxyFailed", &commonbackend.ProxyFailed{
+		Type:       "proxyFailed",
+		SourceIP:   sourceIP,
+		SourcePort: sourcePort,
+		DestPort:   destPort,
+		Protocol:   protocol,
+		Message:    message,
+	})
+}
+
+func (helper *BackendApplicationHelper) SendUpstreamDisconnected(message string) error {
+	return helper.SendEvent("upstreamDisconnected", &commonbackend.UpstreamDisconnected{
+		Type:    "upstreamDisconnected",
+		Message: message,
+	})
+}
@@ -0,0 +1,57 @@
+package backendutil
+
+import (
+	"io"
+	"os"
+	"time"
+
+	"git.terah.dev/imterah/hermes/backend/commonbackend"
+	"github.com/charmbracelet/log"
+)
+
+// Output for charmbracelet/log that sends every log line to the API as a LogMessage frame. charmbracelet/log can only
+// hand its records to an io.Writer, so the logger has to use the JSON formatter, which writes one record per call.
+// Records that can't be sent (ex. before we are connected) go to stderr instead, marked with
+// commonbackend.LogLinePrefix so that the API still gets them as structured logs.
+type LogHandler struct {
+	helper   *BackendApplicationHelper
+	fallback io.Writer
+}
+
+func NewLogHandler(helper *BackendApplicationHelper) *LogHandler {
+	return &LogHandler{
+		helper:   helper,
+		fallback: os.Stderr,
+	}
+}
+
+func (handler *LogHandler) Write(p []byte) (int, error) {
+	logMessage, err := commonbackend.ParseJSONLogLine(p)
+
+	if err != nil {
+		return handler.fallback.Write(p)
+	}
+
+	// We can't log anything in here, as that would call back into us
+	if err := handler.helper.SendEvent(logMessage.Type, logMessage); err != nil {
+		line, err := commonbackend.EncodeLogLine(logMessage)
+
+		if err != nil {
+			return handler.fallback.Write(p)
+		}
+
+		if _, err := handler.fallback.Write(line); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(p), nil
+}
+
+// Sends everything logged through the default charmbracelet/log logger to the API
+func (helper *BackendApplicationHelper) ForwardLogs() {
+	log.SetFormatter(log.JSONFormatter)
+	log.SetReportTimestamp(true)
+	log.SetTimeFormat(time.RFC3339Nano)
+	log.SetOutput(NewLogHandler(helper))
+}
@@ -0,0 +1,325 @@
+package backendutil
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"git.terah.dev/imterah/hermes/backend/commonbackend"
+	"github.com/charmbracelet/log"
+)
+
+// Size of the buffers used to copy data between connections. This fits the largest possible UDP datagram, so that
+// UDP sessions don't get their datagrams truncated. Buffers are pooled, so idle connections don't hold on to one.
+const relayBufferSize = 64 * 1024
+
+var relayBufferPool = sync.Pool{
+	New: func() interface{} {
+		buffer := make([]byte, relayBufferSize)
+		return &buffer
+	},
+}
+
+// Keeps track of the connections a backend is relaying, so that they can be listed, killed, and drained.
+// Relays started through it are registered for as long as they run, and connection events are sent for them automatically.
+type ConnectionTracker struct {
+	// Sends connection events to the API. Can be nil, in which case no events are sent.
+	Helper *BackendApplicationHelper
+
+	relaysLock sync.Mutex
+	relays     []*Relay
+}
+
+// A client connection being relayed to a proxy's source
+type Relay struct {
+	// Byte counters are updated atomically while the relay is running. Use Snapshot to read them.
+	Connection *commonbackend.ProxyClientConnection
+	// Connection accepted from the client
+	ClientConn net.Conn
+	// Connection to the proxy's source
+	SourceConn net.Conn
+
+	tracker      *ConnectionTracker
+	idleTimeout  time.Duration
+	lastActivity atomic.Int64
+	closeOnce    sync.Once
+	done         chan struct{}
+}
+
+func NewConnectionTracker(helper *BackendApplicationHelper) *ConnectionTracker {
+	return &ConnectionTracker{
+		Helper: helper,
+	}
+}
+
+// Builds the connection metadata for a client connecting to a proxy, from the client's remote address
+func NewProxyClientConnection(clientAddr net.Addr, proxy *commonbackend.AddProxy) (*commonbackend.ProxyClientConnection, error) {
+	clientIP, clientPortString, err := net.SplitHostPort(clientAddr.String())
+
+	if err != nil {
+		return nil, err
+	}
+
+	clientPort, err := strconv.ParseUint(clientPortString, 10, 16)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &commonbackend.ProxyClientConnection{
+		SourceIP:          proxy.SourceIP,
+		SourcePort:        proxy.SourcePort,
+		DestPort:          proxy.DestPort,
+		ClientIP:          clientIP,
+		ClientPort:        uint16(clientPort),
+		Protocol:          proxy.Protocol,
+		ConnectionStarted: time.Now(),
+	}, nil
+}
+
+// Relays data between a client and a proxy's source until both sides are done, and then closes both connections.
+// When one side finishes sending, the other side's write half is closed (if it supports it), so half-closed TCP
+// connections keep working. If idleTimeout isn't zero, the relay is closed once no data has gone either way for that long.
+//
+// The relay is registered with the tracker until it finishes.
+func (tracker *ConnectionTracker) StartRelay(clientConn, sourceConn net.Conn, connection *commonbackend.ProxyClientConnection, idleTimeout time.Duration) *Relay {
+	relay := &Relay{
+		Connection:  connection,
+		ClientConn:  clientConn,
+		SourceConn:  sourceConn,
+		tracker:     tracker,
+		idleTimeout: idleTimeout,
+		done:        make(chan struct{}),
+	}
+
+	relay.lastActivity.Store(time.Now().UnixNano())
+
+	tracker.relaysLock.Lock()
+	tracker.relays = append(tracker.relays, relay)
+	tracker.relaysLock.Unlock()
+
+	var copiers sync.WaitGroup
+	copiers.Add(2)
+
+	go func() {
+		defer copiers.Done()
+		relay.copy(sourceConn, clientConn, &connection.BytesReceived)
+	}()
+
+	go func() {
+		defer copiers.Done()
+		relay.copy(clientConn, sourceConn, &connection.BytesSent)
+	}()
+
+	if idleTimeout > 0 {
+		go relay.idleWatchdog()
+	}
+
+	// The events are sent from here instead of the caller, so that a slow API doesn't hold up accepting connections.
+	// Sending both from the same goroutine keeps the opened event ahead of the closed one.
+	go func() {
+		if tracker.Helper != nil {
+			if err := tracker.Helper.SendConnectionOpened(relay.Snapshot()); err != nil {
+				log.Debugf("failed to send connection opened event: %s", err.Error())
+			}
+		}
+
+		copiers.Wait()
+		relay.Close()
+		tracker.remove(relay)
+		close(relay.done)
+	}()
+
+	return relay
+}
+
+func (relay *Relay) copy(destination, source net.Conn, byteCounter *uint64) {
+	bufferPointer := relayBufferPool.Get().(*[]byte)
+	defer relayBufferPool.Put(bufferPointer)
+
+	buffer := *bufferPointer
+
+	for {
+		n, err := source.Read(buffer)
+
+		if n > 0 {
+			if _, err := destination.Write(buffer[:n]); err != nil {
+				if !errors.Is(err, net.ErrClosed) {
+					log.Debugf("failed to write to connection: %s", err.Error())
+				}
+
+				relay.Close()
+				return
+			}
+
+			atomic.AddUint64(byteCounter, uint64(n))
+			relay.lastActivity.Store(time.Now().UnixNano())
+		}
+
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				// The other side might still have something to say, so only close our half of the connection, if we can
+				if halfCloser, ok := destination.(interface{ CloseWrite() error }); ok {
+					if err := halfCloser.CloseWrite(); err == nil {
+						return
+					}
+				}
+			} else if !errors.Is(err, net.ErrClosed) {
+				log.Debugf("failed to read from connection: %s", err.Error())
+			}
+
+			relay.Close()
+			return
+		}
+	}
+}
+
+func (relay *Relay) idleWatchdog() {
+	for {
+		idleFor := time.Since(time.Unix(0, relay.lastActivity.Load()))
+
+		if idleFor >= relay.idleTimeout {
+			log.Debugf("closing connection from %s:%d, as it has been idle for %s", relay.Connection.ClientIP, relay.Connection.ClientPort, idleFor.Round(time.Second))
+			relay.Close()
+			return
+		}
+
+		select {
+		case <-relay.done:
+			return
+		case <-time.After(relay.idleTimeout - idleFor):
+		}
+	}
+}
+
+// Closes both sides of the relay. The relay is unregistered once it has finished tearing down (see Done).
+func (relay *Relay) Close() {
+	relay.closeOnce.Do(func() {
+		if err := relay.ClientConn.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			log.Warnf("failed to close client connection: %s", err.Error())
+		}
+
+		if err := relay.SourceConn.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			log.Warnf("failed to close source connection: %s", err.Error())
+		}
+	})
+}
+
+// Closed once the relay has finished, and is no longer registered
+func (relay *Relay) Done() <-chan struct{} {
+	return relay.done
+}
+
+// Copies the connection metadata, reading the byte counters atomically
+func (relay *Relay) Snapshot() *commonbackend.ProxyClientConnection {
+	return &commonbackend.ProxyClientConnection{
+		SourceIP:          relay.Connection.SourceIP,
+		SourcePort:        relay.Connection.SourcePort,
+		DestPort:          relay.Connection.DestPort,
+		ClientIP:          relay.Connection.ClientIP,
+		ClientPort:        relay.Connection.ClientPort,
+		Protocol:          relay.Connection.Protocol,
+		ConnectionStarted: relay.Connection.ConnectionStarted,
+		BytesSent:         atomic.LoadUint64(&relay.Connection.BytesSent),
+		BytesReceived:     atomic.LoadUint64(&relay.Connection.BytesReceived),
+	}
+}
+
+func (tracker *ConnectionTracker) remove(relay *Relay) {
+	tracker.relaysLock.Lock()
+
+	for relayIndex, trackedRelay := range tracker.relays {
+		if trackedRelay == relay {
+			tracker.relays = append(tracker.relays[:relayIndex], tracker.relays[relayIndex+1:]...)
+			break
+		}
+	}
+
+	tracker.relaysLock.Unlock()
+
+	if tracker.Helper != nil {
+		if err := tracker.Helper.SendConnectionClosed(relay.Snapshot()); err != nil {
+			log.Debugf("failed to send connection closed event: %s", err.Error())
+		}
+	}
+}
+
+// Gets snapshots of every connection being relayed. Suitable for BackendInterface.GetAllClientConnections.
+func (tracker *ConnectionTracker) Connections() []*commonbackend.ProxyClientConnection {
+	tracker.relaysLock.Lock()
+	defer tracker.relaysLock.Unlock()
+
+	connections := make([]*commonbackend.ProxyClientConnection, len(tracker.relays))
+
+	for relayIndex, relay := range tracker.relays {
+		connections[relayIndex] = relay.Snapshot()
+	}
+
+	return connections
+}
+
+// Gets the relays for a proxy
+func (tracker *ConnectionTracker) RelaysForProxy(sourceIP string, sourcePort, destPort uint16, protocol string) []*Relay {
+	tracker.relaysLock.Lock()
+	defer tracker.relaysLock.Unlock()
+
+	relays := []*Relay{}
+
+	for _, relay := range tracker.relays {
+		connection := relay.Connection
+
+		if connection.SourceIP == sourceIP && connection.SourcePort == sourcePort && connection.DestPort == destPort && connection.Protocol == protocol {
+			relays = append(relays, relay)
+		}
+	}
+
+	return relays
+}
+
+// Closes the relay for a single client connection. Suitable for BackendConnectionKiller.KillConnection.
+func (tracker *ConnectionTracker) KillConnection(command *commonbackend.KillConnection) (bool, error) {
+	for _, relay := range tracker.RelaysForProxy(command.SourceIP, command.SourcePort, command.DestPort, command.Protocol) {
+		if net.ParseIP(relay.Connection.ClientIP).Equal(net.ParseIP(command.ClientIP)) && relay.Connection.ClientPort == command.ClientPort {
+			relay.Close()
+			return true, nil
+		}
+	}
+
+	return false, fmt.Errorf("could not find the connection")
+}
+
+// Waits for a proxy's relays to finish on their own, until the timeout passes, and then closes the rest.
+// Returns how many relays had to be closed. Suitable for BackendProxyDrainer.DrainProxy, after the proxy has stopped accepting connections.
+func (tracker *ConnectionTracker) DrainProxy(command *commonbackend.RemoveProxy) uint32 {
+	relays := tracker.RelaysForProxy(command.SourceIP, command.SourcePort, command.DestPort, command.Protocol)
+	drainTimeout := time.After(command.DrainTimeout)
+
+	for relayIndex, relay := range relays {
+		select {
+		case <-relay.Done():
+		case <-drainTimeout:
+			remainingRelays := relays[relayIndex:]
+			log.Debugf("drain timeout reached with up to %d connection(s) still open. Closing them...", len(remainingRelays))
+
+			remainingConnections := uint32(0)
+
+			for _, relay := range remainingRelays {
+				select {
+				case <-relay.Done():
+				default:
+					remainingConnections++
+					relay.Close()
+				}
+			}
+
+			return remainingConnections
+		}
+	}
+
+	return 0
+}
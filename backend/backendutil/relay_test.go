@@ -0,0 +1,271 @@
+package backendutil_test
+
+import (
+	"io"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"git.terah.dev/imterah/hermes/backend/backendutil"
+	"git.terah.dev/imterah/hermes/backend/backendutil/testkit"
+	"git.terah.dev/imterah/hermes/backend/commonbackend"
+)
+
+func TestRelayHalfClose(t *testing.T) {
+	source := testkit.StartTCPEchoServer(t)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	defer listener.Close()
+
+	userConn, err := net.Dial("tcp", listener.Addr().String())
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	defer userConn.Close()
+
+	clientConn, err := listener.Accept()
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	sourceConn, err := net.Dial("tcp", net.JoinHostPort(source.IP, strconv.Itoa(int(source.Port))))
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	connection, err := backendutil.NewProxyClientConnection(clientConn.RemoteAddr(), &commonbackend.AddProxy{
+		SourceIP:   source.IP,
+		SourcePort: source.Port,
+		DestPort:   1,
+		Protocol:   "tcp",
+	})
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	tracker := backendutil.NewConnectionTracker(nil)
+	relay := tracker.StartRelay(clientConn, sourceConn, connection, 0)
+
+	if len(tracker.Connections()) != 1 {
+		t.Errorf("relay was not registered")
+	}
+
+	// After we're done sending, the echo server should still be able to send everything back to us
+	if _, err := userConn.Write([]byte("hello")); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if err := userConn.(*net.TCPConn).CloseWrite(); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	userConn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	echoed, err := io.ReadAll(userConn)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if string(echoed) != "hello" {
+		t.Errorf("got %q back through the relay", echoed)
+	}
+
+	select {
+	case <-relay.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("relay did not finish after both sides closed")
+	}
+
+	snapshot := relay.Snapshot()
+
+	if snapshot.BytesReceived != 5 || snapshot.BytesSent != 5 {
+		t.Errorf("byte counters are not correct (sent: %d, received: %d)", snapshot.BytesSent, snapshot.BytesReceived)
+	}
+
+	if len(tracker.Connections()) != 0 {
+		t.Errorf("relay is still registered after finishing")
+	}
+}
+
+func TestUDPRelaySessions(t *testing.T) {
+	source := testkit.StartUDPEchoServer(t)
+
+	packetConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	defer packetConn.Close()
+
+	proxy := &commonbackend.AddProxy{
+		SourceIP:   source.IP,
+		SourcePort: source.Port,
+		DestPort:   uint16(packetConn.LocalAddr().(*net.UDPAddr).Port),
+		Protocol:   "udp",
+	}
+
+	tracker := backendutil.NewConnectionTracker(nil)
+
+	go tracker.RelayUDP(packetConn, proxy, func() (net.Conn, error) {
+		return net.Dial("udp", net.JoinHostPort(source.IP, strconv.Itoa(int(source.Port))))
+	}, backendutil.UDPRelayOptions{
+		SessionTimeout: 500 * time.Millisecond,
+		MaxSessions:    1,
+	})
+
+	firstClient, err := net.Dial("udp", packetConn.LocalAddr().String())
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	defer firstClient.Close()
+
+	if _, err := firstClient.Write([]byte("hello")); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	echoed := make([]byte, 16)
+	firstClient.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := firstClient.Read(echoed)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if string(echoed[:n]) != "hello" {
+		t.Errorf("got %q back through the relay", echoed[:n])
+	}
+
+	connections := tracker.Connections()
+
+	if len(connections) != 1 {
+		t.Fatalf("expected 1 session, got %d", len(connections))
+	}
+
+	if connections[0].Protocol != "udp" || connections[0].BytesReceived != 5 {
+		t.Errorf("session is not reported correctly (protocol: %s, received: %d)", connections[0].Protocol, connections[0].BytesReceived)
+	}
+
+	// We're at the session limit, so a second client shouldn't get anything back
+	secondClient, err := net.Dial("udp", packetConn.LocalAddr().String())
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	defer secondClient.Close()
+
+	if _, err := secondClient.Write([]byte("hello")); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	secondClient.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+
+	if _, err := secondClient.Read(echoed); err == nil {
+		t.Errorf("session limit was not enforced")
+	}
+
+	// The first session should expire after going idle
+	time.Sleep(time.Second)
+
+	if len(tracker.Connections()) != 0 {
+		t.Errorf("idle session was not expired")
+	}
+}
+
+func TestUDPRelaySlowSourceDoesNotHoldUpOtherSessions(t *testing.T) {
+	source := testkit.StartUDPEchoServer(t)
+
+	packetConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	defer packetConn.Close()
+
+	proxy := &commonbackend.AddProxy{
+		SourceIP:   source.IP,
+		SourcePort: source.Port,
+		DestPort:   uint16(packetConn.LocalAddr().(*net.UDPAddr).Port),
+		Protocol:   "udp",
+	}
+
+	// The first dial is held up until we let it through
+	firstDialStarted := make(chan struct{})
+	releaseFirstDial := make(chan struct{})
+	var dialCount atomic.Int32
+
+	tracker := backendutil.NewConnectionTracker(nil)
+
+	go tracker.RelayUDP(packetConn, proxy, func() (net.Conn, error) {
+		if dialCount.Add(1) == 1 {
+			close(firstDialStarted)
+			<-releaseFirstDial
+		}
+
+		return net.Dial("udp", net.JoinHostPort(source.IP, strconv.Itoa(int(source.Port))))
+	}, backendutil.UDPRelayOptions{})
+
+	expectEcho := func(client net.Conn, message string) {
+		t.Helper()
+
+		echoed := make([]byte, 16)
+		client.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, err := client.Read(echoed)
+
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if string(echoed[:n]) != message {
+			t.Errorf("got %q back through the relay", echoed[:n])
+		}
+	}
+
+	slowClient, err := net.Dial("udp", packetConn.LocalAddr().String())
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	defer slowClient.Close()
+
+	if _, err := slowClient.Write([]byte("slow")); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	<-firstDialStarted
+
+	fastClient, err := net.Dial("udp", packetConn.LocalAddr().String())
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	defer fastClient.Close()
+
+	if _, err := fastClient.Write([]byte("fast")); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expectEcho(fastClient, "fast")
+
+	// The slow client's datagram should have been queued up while its source was being dialed
+	close(releaseFirstDial)
+	expectEcho(slowClient, "slow")
+}
@@ -0,0 +1,123 @@
+package backendutil
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"git.terah.dev/imterah/hermes/backend/commonbackend"
+)
+
+type resolvedHostname struct {
+	ips        []net.IP
+	resolvedAt time.Time
+}
+
+// Dials proxy sources, which can be an IP address, a hostname, or a Unix socket path. Hostnames are resolved when dialing,
+// not when the proxy is added, so that sources behind dynamic DNS keep working.
+type SourceResolver struct {
+	// How long to keep using a resolved hostname before resolving it again. If zero, hostnames are resolved on every dial.
+	// Cached addresses are always dropped if dialing them fails, so the next dial re-resolves.
+	CacheDuration time.Duration
+
+	cache     map[string]*resolvedHostname
+	cacheLock sync.Mutex
+}
+
+func NewSourceResolver(cacheDuration time.Duration) *SourceResolver {
+	return &SourceResolver{
+		CacheDuration: cacheDuration,
+		cache:         map[string]*resolvedHostname{},
+	}
+}
+
+func (resolver *SourceResolver) lookup(ctx context.Context, host string) ([]net.IP, error) {
+	if resolver.CacheDuration > 0 {
+		resolver.cacheLock.Lock()
+		cached, ok := resolver.cache[host]
+		resolver.cacheLock.Unlock()
+
+		if ok && time.Since(cached.resolvedAt) < resolver.CacheDuration {
+			return cached.ips, nil
+		}
+	}
+
+	ips, err := net.DefaultResolver.LookupIP(ctx, "ip", host)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("no addresses found for %s", host)
+	}
+
+	if resolver.CacheDuration > 0 {
+		resolver.cacheLock.Lock()
+
+		if resolver.cache == nil {
+			resolver.cache = map[string]*resolvedHostname{}
+		}
+
+		resolver.cache[host] = &resolvedHostname{
+			ips:        ips,
+			resolvedAt: time.Now(),
+		}
+		resolver.cacheLock.Unlock()
+	}
+
+	return ips, nil
+}
+
+// Forgets a resolved hostname, so that it gets resolved again on the next dial.
+func (resolver *SourceResolver) Invalidate(host string) {
+	resolver.cacheLock.Lock()
+	defer resolver.cacheLock.Unlock()
+
+	delete(resolver.cache, host)
+}
+
+// Dials a proxy source. If host is a hostname, every address it resolves to is tried in order, until one succeeds.
+// If host is a Unix socket path, network and port are ignored, and the socket is dialed directly.
+func (resolver *SourceResolver) Dial(network, host string, port uint16) (net.Conn, error) {
+	return resolver.DialContext(context.Background(), network, host, port)
+}
+
+// Same as Dial, but gives up once the context is cancelled, including while resolving the hostname
+func (resolver *SourceResolver) DialContext(ctx context.Context, network, host string, port uint16) (net.Conn, error) {
+	var dialer net.Dialer
+
+	if commonbackend.IsUnixSocketPath(host) {
+		return dialer.DialContext(ctx, "unix", host)
+	}
+
+	portString := strconv.Itoa(int(port))
+
+	if net.ParseIP(host) != nil {
+		return dialer.DialContext(ctx, network, net.JoinHostPort(host, portString))
+	}
+
+	ips, err := resolver.lookup(ctx, host)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %s", host, err.Error())
+	}
+
+	var lastErr error
+
+	for _, ip := range ips {
+		conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), portString))
+
+		if err == nil {
+			return conn, nil
+		}
+
+		lastErr = err
+	}
+
+	resolver.Invalidate(host)
+	return nil, lastErr
+}
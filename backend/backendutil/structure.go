@@ -1,6 +1,10 @@
 package backendutil
 
-import "git.terah.dev/imterah/hermes/backend/commonbackend"
+import (
+	"context"
+
+	"git.terah.dev/imterah/hermes/backend/commonbackend"
+)
 
 type BackendInterface interface {
 	StartBackend(arguments []byte) (bool, error)
@@ -12,3 +16,41 @@ type BackendInterface interface {
 	CheckParametersForConnections(clientParameters *commonbackend.CheckClientParameters) *commonbackend.CheckParametersResponse
 	CheckParametersForBackend(arguments []byte) *commonbackend.CheckParametersResponse
 }
+
+// Optional, context-aware variant of the BackendInterface functions that can block on the network. If a backend implements
+// this, the helper calls these instead. The context is cancelled once the deadline the API sent along with the command passes,
+// as the API will have given up on the command by then.
+type BackendContextInterface interface {
+	StartBackendContext(ctx context.Context, arguments []byte) (bool, error)
+	StopBackendContext(ctx context.Context) (bool, error)
+	StartProxyContext(ctx context.Context, command *commonbackend.AddProxy) (bool, error)
+	StopProxyContext(ctx context.Context, command *commonbackend.RemoveProxy) (bool, error)
+}
+
+// Optional interface for backends that can disconnect a single client without stopping the whole proxy.
+// Backends implementing this automatically advertise the 'killConnection' capability.
+type BackendConnectionKiller interface {
+	KillConnection(command *commonbackend.KillConnection) (bool, error)
+}
+
+// Optional interface for backends that describe their parameters (the arguments to StartBackend) with a JSON Schema.
+// Sensitive fields should be marked with "writeOnly": true. Backends implementing this automatically advertise the
+// 'parametersSchema' capability.
+type BackendParametersSchemaProvider interface {
+	GetParametersSchema() []byte
+}
+
+// Optional interface for backends that can stop accepting new connections on a proxy while letting existing ones finish.
+// DrainProxy should return once every connection has closed or the drain timeout has passed, along with how many
+// connections were still open at that point. Backends implementing this automatically advertise the 'proxyDrain' capability.
+type BackendProxyDrainer interface {
+	DrainProxy(command *commonbackend.RemoveProxy) (uint32, error)
+}
+
+// Optional interface for backends that can start or stop many proxies more efficiently than one at a time.
+// Both functions must return one result per proxy, in the same order they were given. Backends that don't implement
+// this still support batches, as the helper falls back to calling StartProxy/StopProxy for each proxy.
+type BackendProxyBatcher interface {
+	StartProxies(commands []*commonbackend.AddProxy) []*commonbackend.ProxyStatusResponse
+	StopProxies(commands []*commonbackend.RemoveProxy) []*commonbackend.ProxyStatusResponse
+}
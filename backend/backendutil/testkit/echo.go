@@ -0,0 +1,146 @@
+package testkit
+
+import (
+	"io"
+	"net"
+	"sync"
+	"testing"
+)
+
+// A loopback server that sends back everything it receives. Use it as a proxy's source, so there's something to forward traffic to.
+type EchoServer struct {
+	IP       string
+	Port     uint16
+	Protocol string
+
+	listener   net.Listener
+	packetConn net.PacketConn
+
+	connectionsLock sync.Mutex
+	connections     []net.Conn
+}
+
+// Starts a TCP echo server on 127.0.0.1. It's stopped when the test ends.
+func StartTCPEchoServer(t testing.TB) *EchoServer {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatalf("failed to start TCP echo server: %s", err.Error())
+	}
+
+	server := &EchoServer{
+		IP:       "127.0.0.1",
+		Port:     uint16(listener.Addr().(*net.TCPAddr).Port),
+		Protocol: "tcp",
+		listener: listener,
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+
+			if err != nil {
+				return
+			}
+
+			server.connectionsLock.Lock()
+			server.connections = append(server.connections, conn)
+			server.connectionsLock.Unlock()
+
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+
+	t.Cleanup(server.Close)
+	return server
+}
+
+// Starts a UDP echo server on 127.0.0.1, which sends every datagram back to whoever sent it. It's stopped when the test ends.
+func StartUDPEchoServer(t testing.TB) *EchoServer {
+	t.Helper()
+
+	packetConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatalf("failed to start UDP echo server: %s", err.Error())
+	}
+
+	server := &EchoServer{
+		IP:         "127.0.0.1",
+		Port:       uint16(packetConn.LocalAddr().(*net.UDPAddr).Port),
+		Protocol:   "udp",
+		packetConn: packetConn,
+	}
+
+	go func() {
+		buffer := make([]byte, 65535)
+
+		for {
+			n, addr, err := packetConn.ReadFrom(buffer)
+
+			if err != nil {
+				return
+			}
+
+			packetConn.WriteTo(buffer[:n], addr)
+		}
+	}()
+
+	t.Cleanup(server.Close)
+	return server
+}
+
+// Stops the server, and closes every connection to it
+func (server *EchoServer) Close() {
+	if server.listener != nil {
+		server.listener.Close()
+	}
+
+	if server.packetConn != nil {
+		server.packetConn.Close()
+	}
+
+	server.connectionsLock.Lock()
+	defer server.connectionsLock.Unlock()
+
+	for _, conn := range server.connections {
+		conn.Close()
+	}
+
+	server.connections = nil
+}
+
+// Finds a free port on 127.0.0.1 for the given protocol ("tcp" or "udp"), to have a backend listen on.
+// Something else could still grab the port before the backend does, but that's unlikely in practice.
+func FreePort(t testing.TB, protocol string) uint16 {
+	t.Helper()
+
+	switch protocol {
+	case "tcp":
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+		if err != nil {
+			t.Fatalf("failed to find a free port: %s", err.Error())
+		}
+
+		defer listener.Close()
+		return uint16(listener.Addr().(*net.TCPAddr).Port)
+	case "udp":
+		packetConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+
+		if err != nil {
+			t.Fatalf("failed to find a free port: %s", err.Error())
+		}
+
+		defer packetConn.Close()
+		return uint16(packetConn.LocalAddr().(*net.UDPAddr).Port)
+	default:
+		t.Fatalf("unknown protocol: %s", protocol)
+		return 0
+	}
+}
@@ -0,0 +1,275 @@
+// Package testkit runs a backend in-process, over a net.Pipe, so that it can be tested with ordinary Go tests, without
+// building a binary or setting up a Unix socket.
+package testkit
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"git.terah.dev/imterah/hermes/backend/backendutil"
+	"git.terah.dev/imterah/hermes/backend/commonbackend"
+)
+
+// How long requests wait for the backend to respond by default
+const DefaultTimeout = 10 * time.Second
+
+// Talks to an in-process backend, the same way the API would
+type Client struct {
+	// Response to the hello handshake, which has the capabilities the backend advertises
+	Hello *commonbackend.HelloResponse
+	// How long requests wait for the backend to respond
+	Timeout time.Duration
+
+	conn      net.Conn
+	writeLock sync.Mutex
+
+	pendingLock   sync.Mutex
+	pending       map[uint32]chan interface{}
+	lastRequestID uint32
+
+	events    chan interface{}
+	closeOnce sync.Once
+}
+
+// Starts a backend in-process, and performs the hello handshake with it. The backend is advertised with the default
+// capabilities of backendutil.NewHelper. Use NewFromHelper to customize them.
+func New(t testing.TB, backend backendutil.BackendInterface) *Client {
+	t.Helper()
+
+	return NewFromHelper(t, &backendutil.BackendApplicationHelper{
+		Backend:      backend,
+		Name:         "testkit",
+		Capabilities: []string{commonbackend.CapabilityTCP},
+	})
+}
+
+// Same as New, but runs an already configured helper. Its SocketPath is ignored. The backend is stopped when the test ends.
+func NewFromHelper(t testing.TB, helper *backendutil.BackendApplicationHelper) *Client {
+	t.Helper()
+
+	apiConn, backendConn := net.Pipe()
+
+	go helper.Serve(backendConn)
+
+	client := &Client{
+		Timeout: DefaultTimeout,
+		conn:    apiConn,
+		pending: map[uint32]chan interface{}{},
+		events:  make(chan interface{}, 128),
+	}
+
+	go client.responseReader()
+
+	t.Cleanup(func() {
+		client.Close()
+		backendConn.Close()
+	})
+
+	helloResponse, err := requestAs[*commonbackend.HelloResponse](client, "hello", &commonbackend.Hello{
+		Type:            "hello",
+		ProtocolVersion: commonbackend.ProtocolVersion,
+	})
+
+	if err != nil {
+		t.Fatalf("hello handshake failed: %s", err.Error())
+	}
+
+	client.Hello = helloResponse
+	return client
+}
+
+func (client *Client) responseReader() {
+	defer client.Close()
+
+	for {
+		requestID, _, data, err := commonbackend.UnmarshalWithRequestID(client.conn)
+
+		if err != nil {
+			client.failPendingRequests(err)
+			return
+		}
+
+		// Events are always sent with a request ID of 0. If nobody is reading them, we drop them instead of blocking.
+		if requestID == 0 {
+			select {
+			case client.events <- data:
+			default:
+			}
+
+			continue
+		}
+
+		client.pendingLock.Lock()
+		responseChannel, ok := client.pending[requestID]
+		delete(client.pending, requestID)
+		client.pendingLock.Unlock()
+
+		if ok {
+			responseChannel <- data
+		}
+	}
+}
+
+func (client *Client) failPendingRequests(err error) {
+	client.pendingLock.Lock()
+	defer client.pendingLock.Unlock()
+
+	for requestID, responseChannel := range client.pending {
+		responseChannel <- fmt.Errorf("connection to backend failed: %s", err.Error())
+		delete(client.pending, requestID)
+	}
+}
+
+// Sends a command to the backend, and waits for its response, for up to Timeout
+func (client *Client) Request(commandType string, command interface{}) (interface{}, error) {
+	responseChannel := make(chan interface{}, 1)
+
+	client.pendingLock.Lock()
+	client.lastRequestID++
+	requestID := client.lastRequestID
+	client.pending[requestID] = responseChannel
+	client.pendingLock.Unlock()
+
+	removePendingRequest := func() {
+		client.pendingLock.Lock()
+		delete(client.pending, requestID)
+		client.pendingLock.Unlock()
+	}
+
+	deadline := time.Now().Add(client.Timeout)
+	commandMarshalled, err := commonbackend.MarshalWithDeadline(requestID, deadline, commandType, command)
+
+	if err != nil {
+		removePendingRequest()
+		return nil, fmt.Errorf("failed to marshal command: %s", err.Error())
+	}
+
+	client.writeLock.Lock()
+	client.conn.SetWriteDeadline(deadline)
+	_, err = client.conn.Write(commandMarshalled)
+	client.writeLock.Unlock()
+
+	if err != nil {
+		removePendingRequest()
+		return nil, fmt.Errorf("failed to write command: %s", err.Error())
+	}
+
+	select {
+	case response := <-responseChannel:
+		if err, ok := response.(error); ok {
+			return nil, err
+		}
+
+		return response, nil
+	case <-time.After(time.Until(deadline)):
+		removePendingRequest()
+		return nil, fmt.Errorf("timed out waiting for response to '%s'", commandType)
+	}
+}
+
+func requestAs[T any](client *Client, commandType string, command interface{}) (T, error) {
+	var empty T
+	response, err := client.Request(commandType, command)
+
+	if err != nil {
+		return empty, err
+	}
+
+	typedResponse, ok := response.(T)
+
+	if !ok {
+		return empty, fmt.Errorf("got illegal response type for '%s': %T", commandType, response)
+	}
+
+	return typedResponse, nil
+}
+
+// Starts the backend with the given arguments (usually JSON)
+func (client *Client) Start(arguments []byte) (*commonbackend.BackendStatusResponse, error) {
+	return requestAs[*commonbackend.BackendStatusResponse](client, "start", &commonbackend.Start{
+		Type:      "start",
+		Arguments: arguments,
+	})
+}
+
+func (client *Client) Stop() (*commonbackend.BackendStatusResponse, error) {
+	return requestAs[*commonbackend.BackendStatusResponse](client, "stop", &commonbackend.Stop{
+		Type: "stop",
+	})
+}
+
+func (client *Client) Status() (*commonbackend.BackendStatusResponse, error) {
+	return requestAs[*commonbackend.BackendStatusResponse](client, "backendStatusRequest", &commonbackend.BackendStatusRequest{
+		Type: "backendStatusRequest",
+	})
+}
+
+func (client *Client) CheckServerParameters(arguments []byte) (*commonbackend.CheckParametersResponse, error) {
+	return requestAs[*commonbackend.CheckParametersResponse](client, "checkServerParameters", &commonbackend.CheckServerParameters{
+		Type:      "checkServerParameters",
+		Arguments: arguments,
+	})
+}
+
+// Type is filled in for you
+func (client *Client) CheckClientParameters(command *commonbackend.CheckClientParameters) (*commonbackend.CheckParametersResponse, error) {
+	command.Type = "checkClientParameters"
+	return requestAs[*commonbackend.CheckParametersResponse](client, command.Type, command)
+}
+
+// Type is filled in for you
+func (client *Client) AddProxy(command *commonbackend.AddProxy) (*commonbackend.ProxyStatusResponse, error) {
+	command.Type = "addProxy"
+	return requestAs[*commonbackend.ProxyStatusResponse](client, command.Type, command)
+}
+
+// Type is filled in for you
+func (client *Client) RemoveProxy(command *commonbackend.RemoveProxy) (*commonbackend.ProxyStatusResponse, error) {
+	command.Type = "removeProxy"
+	return requestAs[*commonbackend.ProxyStatusResponse](client, command.Type, command)
+}
+
+// Gets every connection open across all of the backend's proxies
+func (client *Client) Connections() ([]*commonbackend.ProxyClientConnection, error) {
+	response, err := requestAs[*commonbackend.ProxyConnectionsResponse](client, "proxyConnectionsRequest", &commonbackend.ProxyConnectionsRequest{
+		Type: "proxyConnectionsRequest",
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return response.Connections, nil
+}
+
+// Events (ex. *commonbackend.ConnectionOpened) sent by the backend. Events that arrive while the channel is full are dropped.
+func (client *Client) Events() <-chan interface{} {
+	return client.events
+}
+
+// Waits for the next event that has the same type as T (ex. *commonbackend.ConnectionOpened), skipping any others
+func WaitForEvent[T any](client *Client, timeout time.Duration) (T, error) {
+	var empty T
+	timeoutChannel := time.After(timeout)
+
+	for {
+		select {
+		case event := <-client.events:
+			if typedEvent, ok := event.(T); ok {
+				return typedEvent, nil
+			}
+		case <-timeoutChannel:
+			return empty, fmt.Errorf("timed out waiting for %T event", empty)
+		}
+	}
+}
+
+// Disconnects from the backend. This is done for you when the test ends.
+func (client *Client) Close() {
+	client.closeOnce.Do(func() {
+		client.conn.Close()
+	})
+}
@@ -0,0 +1,225 @@
+package backendutil
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"git.terah.dev/imterah/hermes/backend/commonbackend"
+	"github.com/charmbracelet/log"
+)
+
+// How long a UDP session can go without any traffic before it's closed, if UDPRelayOptions.SessionTimeout isn't set
+const DefaultUDPSessionTimeout = 60 * time.Second
+
+// How many datagrams can be queued up for a UDP session before new ones get dropped
+const udpSessionQueueSize = 64
+
+type UDPRelayOptions struct {
+	// How long a session can go without any traffic before it's closed. Defaults to DefaultUDPSessionTimeout.
+	SessionTimeout time.Duration
+	// Most sessions that can be open at once. Datagrams from new clients past this are dropped. If zero, there is no limit.
+	MaxSessions int
+}
+
+// Relays UDP datagrams received on packetConn to a proxy's source, until packetConn is closed.
+//
+// Each client address gets its own session, with its own socket to the source (from dialSource), so that replies make
+// it back to the right client. Sessions are relayed and tracked like TCP connections are, so they show up in
+// Connections, and can be killed and drained.
+func (tracker *ConnectionTracker) RelayUDP(packetConn net.PacketConn, proxy *commonbackend.AddProxy, dialSource func() (net.Conn, error), options UDPRelayOptions) error {
+	if options.SessionTimeout == 0 {
+		options.SessionTimeout = DefaultUDPSessionTimeout
+	}
+
+	var sessionsLock sync.Mutex
+	sessions := map[string]*udpSessionConn{}
+
+	defer func() {
+		sessionsLock.Lock()
+		defer sessionsLock.Unlock()
+
+		for _, session := range sessions {
+			session.stop()
+		}
+	}()
+
+	buffer := make([]byte, relayBufferSize)
+
+	for {
+		n, clientAddr, err := packetConn.ReadFrom(buffer)
+
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+
+			return err
+		}
+
+		sessionsLock.Lock()
+		session, ok := sessions[clientAddr.String()]
+		sessionCount := len(sessions)
+		sessionsLock.Unlock()
+
+		if !ok {
+			if options.MaxSessions > 0 && sessionCount >= options.MaxSessions {
+				log.Debugf("dropping datagram from %s, as there are too many UDP sessions open (%d)", clientAddr.String(), sessionCount)
+				continue
+			}
+
+			connection, err := NewProxyClientConnection(clientAddr, proxy)
+
+			if err != nil {
+				log.Warnf("failed to parse client address: %s", err.Error())
+				continue
+			}
+
+			session = &udpSessionConn{
+				packetConn: packetConn,
+				clientAddr: clientAddr,
+				datagrams:  make(chan []byte, udpSessionQueueSize),
+				closed:     make(chan struct{}),
+			}
+
+			sessionsLock.Lock()
+			sessions[clientAddr.String()] = session
+			sessionsLock.Unlock()
+
+			// Dialing the source can take a while (ex. resolving a hostname), so it's done here, to not hold up the
+			// other sessions. Datagrams for this session queue up until the relay starts, and get dropped past that.
+			go func() {
+				defer func() {
+					sessionsLock.Lock()
+
+					if sessions[clientAddr.String()] == session {
+						delete(sessions, clientAddr.String())
+					}
+
+					sessionsLock.Unlock()
+				}()
+
+				sourceConn, err := dialSource()
+
+				if err != nil {
+					log.Warnf("failed to dial source connection: %s", err.Error())
+					session.Close()
+					return
+				}
+
+				relay := session.startRelay(func() *Relay {
+					return tracker.StartRelay(session, sourceConn, connection, options.SessionTimeout)
+				})
+
+				if relay == nil {
+					sourceConn.Close()
+					return
+				}
+
+				<-relay.Done()
+			}()
+		}
+
+		datagram := make([]byte, n)
+		copy(datagram, buffer[:n])
+
+		select {
+		case session.datagrams <- datagram:
+		default:
+			log.Debugf("dropping datagram from %s, as its session can't keep up", clientAddr.String())
+		}
+	}
+}
+
+// Makes a single client of a shared PacketConn look like a connection, so that it can be relayed like one.
+// Reads return datagrams from the client, and writes send datagrams to it.
+type udpSessionConn struct {
+	packetConn net.PacketConn
+	clientAddr net.Addr
+	datagrams  chan []byte
+
+	// The relay is started once the source has been dialed
+	relayLock sync.Mutex
+	relay     *Relay
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// Starts the session's relay with startRelay, unless the session was closed while the source was being dialed, in
+// which case it returns nil.
+func (session *udpSessionConn) startRelay(startRelay func() *Relay) *Relay {
+	session.relayLock.Lock()
+	defer session.relayLock.Unlock()
+
+	select {
+	case <-session.closed:
+		return nil
+	default:
+	}
+
+	session.relay = startRelay()
+	return session.relay
+}
+
+// Closes the session, along with its relay if it has started
+func (session *udpSessionConn) stop() {
+	session.relayLock.Lock()
+	defer session.relayLock.Unlock()
+
+	session.Close()
+
+	if session.relay != nil {
+		session.relay.Close()
+	}
+}
+
+func (session *udpSessionConn) Read(buffer []byte) (int, error) {
+	select {
+	case datagram := <-session.datagrams:
+		return copy(buffer, datagram), nil
+	case <-session.closed:
+		return 0, net.ErrClosed
+	}
+}
+
+func (session *udpSessionConn) Write(buffer []byte) (int, error) {
+	select {
+	case <-session.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+
+	return session.packetConn.WriteTo(buffer, session.clientAddr)
+}
+
+// Only closes the session. The PacketConn is shared with the other sessions, so it's left open.
+func (session *udpSessionConn) Close() error {
+	session.closeOnce.Do(func() {
+		close(session.closed)
+	})
+
+	return nil
+}
+
+func (session *udpSessionConn) LocalAddr() net.Addr {
+	return session.packetConn.LocalAddr()
+}
+
+func (session *udpSessionConn) RemoteAddr() net.Addr {
+	return session.clientAddr
+}
+
+// Deadlines aren't supported, as the relay doesn't need them
+func (session *udpSessionConn) SetDeadline(deadline time.Time) error {
+	return nil
+}
+
+func (session *udpSessionConn) SetReadDeadline(deadline time.Time) error {
+	return nil
+}
+
+func (session *udpSessionConn) SetWriteDeadline(deadline time.Time) error {
+	return nil
+}
@@ -0,0 +1,52 @@
+package commonbackend
+
+import (
+	"net"
+	"strings"
+)
+
+// Checks if an address is a Unix socket path. Unix sockets are written as absolute paths (ex. /var/run/docker.sock),
+// which can never be mistaken for an IP address or a hostname. Their port is unused, and should be zero.
+func IsUnixSocketPath(address string) bool {
+	return strings.HasPrefix(address, "/")
+}
+
+// Checks if a string is a valid proxy source address: an IP address, a hostname, or an absolute Unix socket path.
+// This is what marshalAddress accepts, so anything the API lets through can be sent to the backends.
+func IsValidAddress(address string) bool {
+	return IsUnixSocketPath(address) || net.ParseIP(address) != nil || IsValidHostname(address)
+}
+
+// Checks if a string is a valid DNS name (RFC 1123). A trailing dot (fully qualified name) is allowed. The last label
+// can't be all numbers, so that malformed IP addresses (ex. 1.2.3.999) aren't mistaken for hostnames.
+func IsValidHostname(hostname string) bool {
+	hostname = strings.TrimSuffix(hostname, ".")
+
+	if len(hostname) == 0 || len(hostname) > 253 {
+		return false
+	}
+
+	labels := strings.Split(hostname, ".")
+
+	if strings.Trim(labels[len(labels)-1], "0123456789") == "" {
+		return false
+	}
+
+	for _, label := range labels {
+		if len(label) == 0 || len(label) > 63 {
+			return false
+		}
+
+		if label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+
+		for _, char := range label {
+			if !(char >= 'a' && char <= 'z') && !(char >= 'A' && char <= 'Z') && !(char >= '0' && char <= '9') && char != '-' {
+				return false
+			}
+		}
+	}
+
+	return true
+}
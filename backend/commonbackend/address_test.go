@@ -0,0 +1,56 @@
+package commonbackend
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIsValidAddress(t *testing.T) {
+	addresses := []struct {
+		address string
+		isValid bool
+	}{
+		{"192.168.0.1", true},
+		{"::1", true},
+		{"2001:db8::1", true},
+		{"example.com", true},
+		{"example.com.", true},
+		{"localhost", true},
+		{"my-service.internal", true},
+		{"1password.com", true},
+		{"/var/run/docker.sock", true},
+
+		// Malformed IPv4 addresses look like hostnames made out of numbers, so they need to be rejected as hostnames too
+		{"1.2.3.999", false},
+		{"1.2.3", false},
+		{"256.0.0.1", false},
+		{"1.2.3.4.", false},
+
+		{"", false},
+		{".", false},
+		{"example..com", false},
+		{".example.com", false},
+		{"example.com..", false},
+		{"-example.com", false},
+		{"example-.com", false},
+		{"exa_mple.com", false},
+		{"example.com:80", false},
+		{strings.Repeat("a", 63) + ".com", true},
+		{strings.Repeat("a", 64) + ".com", false},
+		{strings.Repeat("a.", 127) + "com", false},
+	}
+
+	for _, test := range addresses {
+		if isValid := IsValidAddress(test.address); isValid != test.isValid {
+			t.Errorf("IsValidAddress(%q) = %t, expected %t", test.address, isValid, test.isValid)
+		}
+	}
+}
+
+func TestInvalidAddressesCannotBeMarshalled(t *testing.T) {
+	for _, address := range []string{"1.2.3.999", "example..com", strings.Repeat("a", 64) + ".com"} {
+		if _, err := marshalAddress(address); err == nil {
+			t.Errorf("expected marshalling %q to fail", address)
+		}
+	}
+}
@@ -1,5 +1,11 @@
 package commonbackend
 
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
 type Start struct {
 	Type      string // Will be 'start' always
 	Arguments []byte
@@ -18,11 +24,12 @@ type AddProxy struct {
 }
 
 type RemoveProxy struct {
-	Type       string // Will be 'removeProxy' always
-	SourceIP   string
-	SourcePort uint16
-	DestPort   uint16
-	Protocol   string // Will be either 'tcp' or 'udp'
+	Type         string // Will be 'removeProxy' always
+	SourceIP     string
+	SourcePort   uint16
+	DestPort     uint16
+	Protocol     string        // Will be either 'tcp' or 'udp'
+	DrainTimeout time.Duration // If set, stop accepting new connections, and give existing ones this long to finish (millisecond precision)
 }
 
 type ProxyStatusRequest struct {
@@ -34,12 +41,14 @@ type ProxyStatusRequest struct {
 }
 
 type ProxyStatusResponse struct {
-	Type       string // Will be 'proxyStatusResponse' always
-	SourceIP   string
-	SourcePort uint16
-	DestPort   uint16
-	Protocol   string // Will be either 'tcp' or 'udp'
-	IsActive   bool
+	Type                 string // Will be 'proxyStatusResponse' always
+	SourceIP             string
+	SourcePort           uint16
+	DestPort             uint16
+	Protocol             string // Will be either 'tcp' or 'udp'
+	IsActive             bool
+	RemainingConnections uint32 // When draining, how many connections were still open when the drain ended
+	Message              string // String message from the client, if the request failed (ex. address already in use)
 }
 
 type ProxyInstance struct {
@@ -75,11 +84,15 @@ type ProxyConnectionsRequest struct {
 
 // Client's connection to a specific proxy
 type ProxyClientConnection struct {
-	SourceIP   string
-	SourcePort uint16
-	DestPort   uint16
-	ClientIP   string
-	ClientPort uint16
+	SourceIP          string
+	SourcePort        uint16
+	DestPort          uint16
+	ClientIP          string
+	ClientPort        uint16
+	Protocol          string    // Will be either 'tcp' or 'udp'
+	ConnectionStarted time.Time // Sent with millisecond precision
+	BytesSent         uint64    // Bytes sent to the client
+	BytesReceived     uint64    // Bytes received from the client
 }
 
 type ProxyConnectionsResponse struct {
@@ -108,6 +121,124 @@ type CheckParametersResponse struct {
 	Message      string // String message from the client (ex. failed to unmarshal JSON: x is not defined)
 }
 
+// Sent by the API to the backend as soon as the socket connects
+type Hello struct {
+	Type            string // Will be 'hello' always
+	ProtocolVersion uint16 // Protocol version the API speaks
+}
+
+// Sent by the backend as a response to Hello
+type HelloResponse struct {
+	Type            string   // Will be 'helloResponse' always
+	ProtocolVersion uint16   // Protocol version the backend speaks
+	BackendName     string   // Name of the backend (ex. ssh)
+	BackendVersion  string   // Version of the backend (ex. 1.0.0)
+	Capabilities    []string // List of supported capabilities (ex. 'tcp', 'udp', 'connectionEvents')
+}
+
+// Sent by the backend, unprompted, when a client connects to a proxy
+type ConnectionOpened struct {
+	Type       string // Will be 'connectionOpened' always
+	Connection *ProxyClientConnection
+}
+
+// Sent by the backend, unprompted, when a client disconnects from a proxy
+type ConnectionClosed struct {
+	Type       string // Will be 'connectionClosed' always
+	Connection *ProxyClientConnection
+}
+
+// Sent by the backend, unprompted, when a running proxy stops working
+type ProxyFailed struct {
+	Type       string // Will be 'proxyFailed' always
+	SourceIP   string
+	SourcePort uint16
+	DestPort   uint16
+	Protocol   string // Will be either 'tcp' or 'udp'
+	Message    string // String message from the client (ex. failed to listen on port)
+}
+
+// Sent by the backend, unprompted, when it loses its upstream connection (ex. the SSH server)
+type UpstreamDisconnected struct {
+	Type    string // Will be 'upstreamDisconnected' always
+	Message string // String message from the client (ex. connection reset by peer)
+}
+
+// Sent by the API to disconnect a single client from a proxy
+type KillConnection struct {
+	Type       string // Will be 'killConnection' always
+	SourceIP   string
+	SourcePort uint16
+	DestPort   uint16
+	ClientIP   string
+	ClientPort uint16
+	Protocol   string // Will be either 'tcp' or 'udp'
+}
+
+// Sent by the backend as a response to KillConnection
+type KillConnectionResponse struct {
+	Type     string // Will be 'killConnectionResponse' always
+	IsKilled bool   // If true, the connection was found and closed
+	Message  string // String message from the client (ex. could not find the connection)
+}
+
+// Sent by the API to ask the backend what its parameters (the arguments to Start) look like
+type ParametersSchemaRequest struct {
+	Type string // Will be 'parametersSchemaRequest' always
+}
+
+// Sent by the backend as a response to ParametersSchemaRequest
+type ParametersSchemaResponse struct {
+	Type   string // Will be 'parametersSchemaResponse' always
+	Schema []byte // JSON Schema document. Sensitive fields (ex. private keys) are marked with "writeOnly": true
+}
+
+// Sent by the API to start multiple proxies in one round trip
+type AddProxies struct {
+	Type    string // Will be 'addProxies' always
+	Proxies []*AddProxy
+}
+
+// Sent by the API to stop multiple proxies in one round trip
+type RemoveProxies struct {
+	Type    string // Will be 'removeProxies' always
+	Proxies []*RemoveProxy
+}
+
+// Sent by the backend as a response to AddProxies and RemoveProxies. Has one result per proxy, in the same order as the request
+type ProxiesStatusResponse struct {
+	Type    string // Will be 'proxiesStatusResponse' always
+	Results []*ProxyStatusResponse
+}
+
+// Sent by the API periodically to check that the backend is still responsive
+type Ping struct {
+	Type      string    // Will be 'ping' always
+	Nonce     uint64    // Random value that has to be echoed back in the Pong
+	Timestamp time.Time // When the ping was sent (nanosecond precision)
+}
+
+// Sent by the backend as a response to Ping. Both fields are copied from the Ping as-is
+type Pong struct {
+	Type      string // Will be 'pong' always
+	Nonce     uint64
+	Timestamp time.Time
+}
+
+type LogField struct {
+	Key   string
+	Value string
+}
+
+// Sent by the backend, unprompted, for every line it logs
+type LogMessage struct {
+	Type    string // Will be 'logMessage' always
+	Time    time.Time
+	Level   string // Will be either 'debug', 'info', 'warn', 'error' or 'fatal'
+	Message string
+	Fields  []*LogField // Key/value pairs attached to the log line (ex. err=connection refused)
+}
+
 const (
 	StartID = iota
 	StopID
@@ -124,6 +255,63 @@ const (
 	ProxyStatusResponseID
 	ProxyInstanceResponseID
 	ProxyInstanceRequestID
+	HelloID
+	HelloResponseID
+	ConnectionOpenedID
+	ConnectionClosedID
+	ProxyFailedID
+	UpstreamDisconnectedID
+	LogMessageID
+	KillConnectionID
+	KillConnectionResponseID
+	ParametersSchemaRequestID
+	ParametersSchemaResponseID
+	PingID
+	PongID
+	AddProxiesID
+	RemoveProxiesID
+	ProxiesStatusResponseID
+)
+
+const (
+	// Protocol version spoken by this package. Bump this when making breaking changes to the wire format.
+	ProtocolVersion = 8
+	// Oldest protocol version we can still talk to
+	MinimumProtocolVersion = 7
+)
+
+const (
+	// Version of the frame envelope every message is wrapped in
+	FrameVersion = 1
+	// Version of the frame envelope for messages carrying a deadline. Only send these to peers speaking protocol version 8 or newer
+	FrameVersionWithDeadline = 2
+	// Size of the frame header (version + payload length)
+	FrameHeaderSize = 1 + 4
+)
+
+// Largest frame payload we are willing to send or accept. Frames bigger than this are rejected before being buffered.
+// Can be overridden with the HERMES_MAX_FRAME_SIZE environment variable. The API passes its limit on to the backends it
+// starts through the same variable, so that both sides agree on it.
+var MaxFrameSize uint32 = 16 * 1024 * 1024
+
+func init() {
+	maxFrameSize, err := strconv.ParseUint(os.Getenv("HERMES_MAX_FRAME_SIZE"), 10, 32)
+
+	if err == nil && maxFrameSize != 0 {
+		MaxFrameSize = uint32(maxFrameSize)
+	}
+}
+
+const (
+	CapabilityTCP              = "tcp"
+	CapabilityUDP              = "udp"
+	CapabilityConnectionEvents = "connectionEvents"
+	CapabilityKillConnection   = "killConnection"
+	CapabilityParametersSchema = "parametersSchema"
+	CapabilityProxyDrain       = "proxyDrain"
+	CapabilityPing             = "ping"
+	CapabilityBatchProxies     = "batchProxies"
+	CapabilityUnixSockets      = "unixSockets"
 )
 
 const (
@@ -137,9 +325,12 @@ const (
 )
 
 const (
-	// IP versions
-	IPv4 = 4
-	IPv6 = 6
+	// Address types. IP versions double as their address type. These must never be '\r' or '\n', as those
+	// are used as list delimiters.
+	Hostname   = 1
+	UnixSocket = 2
+	IPv4       = 4
+	IPv6       = 6
 
 	// TODO: net has these constants defined already. We should switch to these
 	IPv4Size = 4
@@ -0,0 +1,111 @@
+package commonbackend
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Marks a line a backend printed to stdout/stderr as a LogMessage (encoded as JSON), for when it couldn't send it as a
+// frame. Lines without it are always plain text, even if they happen to be JSON.
+const LogLinePrefix = "\x1ehermes-log "
+
+// Encodes a LogMessage as a line to print to stdout/stderr, starting with LogLinePrefix
+func EncodeLogLine(logMessage *LogMessage) ([]byte, error) {
+	logMessageBytes, err := json.Marshal(logMessage)
+
+	if err != nil {
+		return nil, err
+	}
+
+	line := append([]byte(LogLinePrefix), logMessageBytes...)
+	return append(line, '\n'), nil
+}
+
+// Decodes a line encoded with EncodeLogLine. Returns false if the line isn't one.
+func DecodeLogLine(line []byte) (*LogMessage, bool) {
+	logMessageBytes, ok := bytes.CutPrefix(line, []byte(LogLinePrefix))
+
+	if !ok {
+		return nil, false
+	}
+
+	logMessage := &LogMessage{}
+
+	if err := json.Unmarshal(logMessageBytes, logMessage); err != nil || logMessage.Type != "logMessage" {
+		return nil, false
+	}
+
+	return logMessage, true
+}
+
+// Parses a single log line written by charmbracelet/log's JSON formatter into a LogMessage
+func ParseJSONLogLine(line []byte) (*LogMessage, error) {
+	var entry map[string]interface{}
+
+	if err := json.Unmarshal(line, &entry); err != nil {
+		return nil, err
+	}
+
+	message, ok := entry["msg"].(string)
+
+	if !ok {
+		return nil, fmt.Errorf("log line is missing a message")
+	}
+
+	level, _ := entry["level"].(string)
+	logTime := time.Now()
+
+	if timeString, ok := entry["time"].(string); ok {
+		if parsedTime, err := time.Parse(time.RFC3339Nano, timeString); err == nil {
+			logTime = parsedTime
+		}
+	}
+
+	delete(entry, "msg")
+	delete(entry, "level")
+	delete(entry, "time")
+
+	// JSON objects don't keep their key order, so we sort the keys to keep the output stable
+	keys := make([]string, 0, len(entry))
+
+	for key := range entry {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	fields := make([]*LogField, len(keys))
+
+	for keyIndex, key := range keys {
+		var value string
+
+		switch rawValue := entry[key].(type) {
+		case string:
+			value = rawValue
+		default:
+			valueBytes, err := json.Marshal(rawValue)
+
+			if err != nil {
+				value = fmt.Sprint(rawValue)
+			} else {
+				value = string(valueBytes)
+			}
+		}
+
+		fields[keyIndex] = &LogField{
+			Key:   key,
+			Value: value,
+		}
+	}
+
+	return &LogMessage{
+		Type:    "logMessage",
+		Time:    logTime,
+		Level:   level,
+		Message: message,
+		Fields:  fields,
+	}, nil
+}
@@ -3,71 +3,64 @@ package commonbackend
 import (
 	"encoding/binary"
 	"fmt"
+	"math"
 	"net"
+	"time"
 )
 
-func marshalIndividualConnectionStruct(conn *ProxyClientConnection) []byte {
-	sourceIPOriginal := net.ParseIP(conn.SourceIP)
-	clientIPOriginal := net.ParseIP(conn.ClientIP)
+func marshalIndividualConnectionStruct(conn *ProxyClientConnection) ([]byte, error) {
+	sourceIP, err := marshalAddress(conn.SourceIP)
 
-	var serverIPVer uint8
-	var sourceIP []byte
-
-	if sourceIPOriginal.To4() == nil {
-		serverIPVer = IPv6
-		sourceIP = sourceIPOriginal.To16()
-	} else {
-		serverIPVer = IPv4
-		sourceIP = sourceIPOriginal.To4()
+	if err != nil {
+		return nil, err
 	}
 
-	var clientIPVer uint8
-	var clientIP []byte
+	clientIP, err := marshalAddress(conn.ClientIP)
 
-	if clientIPOriginal.To4() == nil {
-		clientIPVer = IPv6
-		clientIP = clientIPOriginal.To16()
-	} else {
-		clientIPVer = IPv4
-		clientIP = clientIPOriginal.To4()
+	if err != nil {
+		return nil, err
 	}
 
-	connectionBlock := make([]byte, 8+len(sourceIP)+len(clientIP))
+	connectionBlock := make([]byte, 6+len(sourceIP)+len(clientIP)+1+8+8+8)
+
+	copy(connectionBlock[0:len(sourceIP)], sourceIP)
 
-	connectionBlock[0] = serverIPVer
-	copy(connectionBlock[1:len(sourceIP)+1], sourceIP)
+	binary.BigEndian.PutUint16(connectionBlock[len(sourceIP):2+len(sourceIP)], conn.SourcePort)
+	binary.BigEndian.PutUint16(connectionBlock[2+len(sourceIP):4+len(sourceIP)], conn.DestPort)
 
-	binary.BigEndian.PutUint16(connectionBlock[1+len(sourceIP):3+len(sourceIP)], conn.SourcePort)
-	binary.BigEndian.PutUint16(connectionBlock[3+len(sourceIP):5+len(sourceIP)], conn.DestPort)
+	copy(connectionBlock[4+len(sourceIP):4+len(sourceIP)+len(clientIP)], clientIP)
+	binary.BigEndian.PutUint16(connectionBlock[4+len(sourceIP)+len(clientIP):6+len(sourceIP)+len(clientIP)], conn.ClientPort)
+
+	currentPosition := 6 + len(sourceIP) + len(clientIP)
+
+	if conn.Protocol == "tcp" {
+		connectionBlock[currentPosition] = TCP
+	} else if conn.Protocol == "udp" {
+		connectionBlock[currentPosition] = UDP
+	} else {
+		return nil, fmt.Errorf("invalid protocol recieved")
+	}
 
-	connectionBlock[5+len(sourceIP)] = clientIPVer
-	copy(connectionBlock[6+len(sourceIP):6+len(sourceIP)+len(clientIP)], clientIP)
-	binary.BigEndian.PutUint16(connectionBlock[6+len(sourceIP)+len(clientIP):8+len(sourceIP)+len(clientIP)], conn.ClientPort)
+	binary.BigEndian.PutUint64(connectionBlock[currentPosition+1:currentPosition+9], uint64(conn.ConnectionStarted.UnixMilli()))
+	binary.BigEndian.PutUint64(connectionBlock[currentPosition+9:currentPosition+17], conn.BytesSent)
+	binary.BigEndian.PutUint64(connectionBlock[currentPosition+17:currentPosition+25], conn.BytesReceived)
 
-	return connectionBlock
+	return connectionBlock, nil
 }
 
 func marshalIndividualProxyStruct(conn *ProxyInstance) ([]byte, error) {
-	sourceIPOriginal := net.ParseIP(conn.SourceIP)
-
-	var sourceIPVer uint8
-	var sourceIP []byte
+	sourceIP, err := marshalAddress(conn.SourceIP)
 
-	if sourceIPOriginal.To4() == nil {
-		sourceIPVer = IPv6
-		sourceIP = sourceIPOriginal.To16()
-	} else {
-		sourceIPVer = IPv4
-		sourceIP = sourceIPOriginal.To4()
+	if err != nil {
+		return nil, err
 	}
 
-	proxyBlock := make([]byte, 6+len(sourceIP))
+	proxyBlock := make([]byte, 5+len(sourceIP))
 
-	proxyBlock[0] = sourceIPVer
-	copy(proxyBlock[1:len(sourceIP)+1], sourceIP)
+	copy(proxyBlock[0:len(sourceIP)], sourceIP)
 
-	binary.BigEndian.PutUint16(proxyBlock[1+len(sourceIP):3+len(sourceIP)], conn.SourcePort)
-	binary.BigEndian.PutUint16(proxyBlock[3+len(sourceIP):5+len(sourceIP)], conn.DestPort)
+	binary.BigEndian.PutUint16(proxyBlock[len(sourceIP):2+len(sourceIP)], conn.SourcePort)
+	binary.BigEndian.PutUint16(proxyBlock[2+len(sourceIP):4+len(sourceIP)], conn.DestPort)
 
 	var protocolVersion uint8
 
@@ -79,12 +72,120 @@ func marshalIndividualProxyStruct(conn *ProxyInstance) ([]byte, error) {
 		return proxyBlock, fmt.Errorf("invalid protocol recieved")
 	}
 
-	proxyBlock[5+len(sourceIP)] = protocolVersion
+	proxyBlock[4+len(sourceIP)] = protocolVersion
 
 	return proxyBlock, nil
 }
 
+// Marshals an address: [IPv4][4 bytes], [IPv6][16 bytes], [Hostname][u16 length][name], or [UnixSocket][u16 length][path].
+// Hostnames are sent as-is, and resolved by the backend when it dials out.
+func marshalAddress(address string) ([]byte, error) {
+	if IsUnixSocketPath(address) {
+		return appendString([]byte{UnixSocket}, address)
+	}
+
+	ip := net.ParseIP(address)
+
+	if ip != nil {
+		if ip.To4() == nil {
+			return append([]byte{IPv6}, ip.To16()...), nil
+		}
+
+		return append([]byte{IPv4}, ip.To4()...), nil
+	}
+
+	if !IsValidHostname(address) {
+		return nil, fmt.Errorf("invalid address: %s", address)
+	}
+
+	return appendString([]byte{Hostname}, address)
+}
+
+// Marshals a batch of messages of the same type: [command ID][u32 count][message]...
+// Each message is marshalled as-is (including its own command ID), so that it can be read back with unmarshalMessage.
+func marshalBatch(commandID uint8, itemType string, items []interface{}) ([]byte, error) {
+	batchBytes := make([]byte, 1+4)
+	batchBytes[0] = commandID
+	binary.BigEndian.PutUint32(batchBytes[1:5], uint32(len(items)))
+
+	for _, item := range items {
+		itemBytes, err := marshalMessage(itemType, item)
+
+		if err != nil {
+			return nil, err
+		}
+
+		batchBytes = append(batchBytes, itemBytes...)
+	}
+
+	return batchBytes, nil
+}
+
+// Appends a string prefixed with its length. This is the counterpart of unmarshalString.
+func appendString(buffer []byte, value string) ([]byte, error) {
+	if len(value) > math.MaxUint16 {
+		return nil, fmt.Errorf("string is too long (maximum is %d bytes)", math.MaxUint16)
+	}
+
+	buffer = binary.BigEndian.AppendUint16(buffer, uint16(len(value)))
+	return append(buffer, value...), nil
+}
+
+// Marshals a command with a request ID of 0. Use this when there is only ever one command in flight.
 func Marshal(commandType string, command interface{}) ([]byte, error) {
+	return MarshalWithRequestID(0, commandType, command)
+}
+
+// Marshals a command, prefixed with a request ID. Responses carry the ID of the request they are in response to,
+// which lets multiple commands be in flight at once.
+//
+// The result is wrapped in a frame: [frame version][u32 payload length][u32 request ID][message]
+func MarshalWithRequestID(requestID uint32, commandType string, command interface{}) ([]byte, error) {
+	return MarshalWithDeadline(requestID, time.Time{}, commandType, command)
+}
+
+// Marshals a command with a request ID, along with the deadline the command has to be handled by. A zero deadline means
+// there is none, and produces the same frame as MarshalWithRequestID.
+//
+// The deadline is sent as the time remaining until it (in milliseconds), so the clocks on both ends don't have to agree:
+// [frame version 2][u32 payload length][u32 request ID][u32 milliseconds remaining][message]
+func MarshalWithDeadline(requestID uint32, deadline time.Time, commandType string, command interface{}) ([]byte, error) {
+	message, err := marshalMessage(commandType, command)
+
+	if err != nil {
+		return nil, err
+	}
+
+	frameVersion := uint8(FrameVersion)
+	payloadSize := 4 + len(message)
+
+	if !deadline.IsZero() {
+		frameVersion = FrameVersionWithDeadline
+		payloadSize += 4
+	}
+
+	if uint64(payloadSize) > uint64(MaxFrameSize) {
+		return nil, fmt.Errorf("frame size %d exceeds maximum of %d", payloadSize, MaxFrameSize)
+	}
+
+	frame := make([]byte, FrameHeaderSize+payloadSize)
+	frame[0] = frameVersion
+	binary.BigEndian.PutUint32(frame[1:5], uint32(payloadSize))
+	binary.BigEndian.PutUint32(frame[5:9], requestID)
+
+	if frameVersion == FrameVersionWithDeadline {
+		// Zero is reserved for "no deadline", so a deadline that has already passed gets sent as 1ms
+		remainingTime := min(max(time.Until(deadline).Milliseconds(), 1), math.MaxUint32)
+		binary.BigEndian.PutUint32(frame[9:13], uint32(remainingTime))
+		copy(frame[13:], message)
+	} else {
+		copy(frame[9:], message)
+	}
+
+	return frame, nil
+}
+
+func marshalMessage(commandType string, command interface{}) ([]byte, error) {
 	switch commandType {
 	case "start":
 		startCommand, ok := command.(*Start)
@@ -93,10 +194,10 @@ func Marshal(commandType string, command interface{}) ([]byte, error) {
 			return nil, fmt.Errorf("failed to typecast")
 		}
 
-		startCommandBytes := make([]byte, 1+2+len(startCommand.Arguments))
+		startCommandBytes := make([]byte, 1+4+len(startCommand.Arguments))
 		startCommandBytes[0] = StartID
-		binary.BigEndian.PutUint16(startCommandBytes[1:3], uint16(len(startCommand.Arguments)))
-		copy(startCommandBytes[3:], startCommand.Arguments)
+		binary.BigEndian.PutUint32(startCommandBytes[1:5], uint32(len(startCommand.Arguments)))
+		copy(startCommandBytes[5:], startCommand.Arguments)
 
 		return startCommandBytes, nil
 	case "stop":
@@ -114,28 +215,20 @@ func Marshal(commandType string, command interface{}) ([]byte, error) {
 			return nil, fmt.Errorf("failed to typecast")
 		}
 
-		sourceIP := net.ParseIP(addConnectionCommand.SourceIP)
+		address, err := marshalAddress(addConnectionCommand.SourceIP)
 
-		var ipVer uint8
-		var ipBytes []byte
-
-		if sourceIP.To4() == nil {
-			ipBytes = sourceIP.To16()
-			ipVer = IPv6
-		} else {
-			ipBytes = sourceIP.To4()
-			ipVer = IPv4
+		if err != nil {
+			return nil, err
 		}
 
-		addConnectionBytes := make([]byte, 1+1+len(ipBytes)+2+2+1)
+		addConnectionBytes := make([]byte, 1+len(address)+2+2+1)
 
 		addConnectionBytes[0] = AddProxyID
-		addConnectionBytes[1] = ipVer
 
-		copy(addConnectionBytes[2:2+len(ipBytes)], ipBytes)
+		copy(addConnectionBytes[1:1+len(address)], address)
 
-		binary.BigEndian.PutUint16(addConnectionBytes[2+len(ipBytes):4+len(ipBytes)], addConnectionCommand.SourcePort)
-		binary.BigEndian.PutUint16(addConnectionBytes[4+len(ipBytes):6+len(ipBytes)], addConnectionCommand.DestPort)
+		binary.BigEndian.PutUint16(addConnectionBytes[1+len(address):3+len(address)], addConnectionCommand.SourcePort)
+		binary.BigEndian.PutUint16(addConnectionBytes[3+len(address):5+len(address)], addConnectionCommand.DestPort)
 
 		var protocol uint8
 
@@ -147,7 +240,7 @@ func Marshal(commandType string, command interface{}) ([]byte, error) {
 			return nil, fmt.Errorf("invalid protocol")
 		}
 
-		addConnectionBytes[6+len(ipBytes)] = protocol
+		addConnectionBytes[5+len(address)] = protocol
 
 		return addConnectionBytes, nil
 	case "removeProxy":
@@ -157,26 +250,18 @@ func Marshal(commandType string, command interface{}) ([]byte, error) {
 			return nil, fmt.Errorf("failed to typecast")
 		}
 
-		sourceIP := net.ParseIP(removeConnectionCommand.SourceIP)
-
-		var ipVer uint8
-		var ipBytes []byte
+		address, err := marshalAddress(removeConnectionCommand.SourceIP)
 
-		if sourceIP.To4() == nil {
-			ipBytes = sourceIP.To16()
-			ipVer = IPv6
-		} else {
-			ipBytes = sourceIP.To4()
-			ipVer = IPv4
+		if err != nil {
+			return nil, err
 		}
 
-		removeConnectionBytes := make([]byte, 1+1+len(ipBytes)+2+2+1)
+		removeConnectionBytes := make([]byte, 1+len(address)+2+2+1+4)
 
 		removeConnectionBytes[0] = RemoveProxyID
-		removeConnectionBytes[1] = ipVer
-		copy(removeConnectionBytes[2:2+len(ipBytes)], ipBytes)
-		binary.BigEndian.PutUint16(removeConnectionBytes[2+len(ipBytes):4+len(ipBytes)], removeConnectionCommand.SourcePort)
-		binary.BigEndian.PutUint16(removeConnectionBytes[4+len(ipBytes):6+len(ipBytes)], removeConnectionCommand.DestPort)
+		copy(removeConnectionBytes[1:1+len(address)], address)
+		binary.BigEndian.PutUint16(removeConnectionBytes[1+len(address):3+len(address)], removeConnectionCommand.SourcePort)
+		binary.BigEndian.PutUint16(removeConnectionBytes[3+len(address):5+len(address)], removeConnectionCommand.DestPort)
 
 		var protocol uint8
 
@@ -188,7 +273,8 @@ func Marshal(commandType string, command interface{}) ([]byte, error) {
 			return nil, fmt.Errorf("invalid protocol")
 		}
 
-		removeConnectionBytes[6+len(ipBytes)] = protocol
+		removeConnectionBytes[5+len(address)] = protocol
+		binary.BigEndian.PutUint32(removeConnectionBytes[6+len(address):10+len(address)], uint32(removeConnectionCommand.DrainTimeout.Milliseconds()))
 
 		return removeConnectionBytes, nil
 	case "proxyConnectionsResponse":
@@ -202,7 +288,13 @@ func Marshal(commandType string, command interface{}) ([]byte, error) {
 		totalSize := 0
 
 		for connIndex, conn := range allConnectionsCommand.Connections {
-			connectionsArray[connIndex] = marshalIndividualConnectionStruct(conn)
+			var err error
+			connectionsArray[connIndex], err = marshalIndividualConnectionStruct(conn)
+
+			if err != nil {
+				return nil, err
+			}
+
 			totalSize += len(connectionsArray[connIndex]) + 1
 		}
 
@@ -230,26 +322,18 @@ func Marshal(commandType string, command interface{}) ([]byte, error) {
 			return nil, fmt.Errorf("failed to typecast")
 		}
 
-		sourceIP := net.ParseIP(checkClientCommand.SourceIP)
+		address, err := marshalAddress(checkClientCommand.SourceIP)
 
-		var ipVer uint8
-		var ipBytes []byte
-
-		if sourceIP.To4() == nil {
-			ipBytes = sourceIP.To16()
-			ipVer = IPv6
-		} else {
-			ipBytes = sourceIP.To4()
-			ipVer = IPv4
+		if err != nil {
+			return nil, err
 		}
 
-		checkClientBytes := make([]byte, 1+1+len(ipBytes)+2+2+1)
+		checkClientBytes := make([]byte, 1+len(address)+2+2+1)
 
 		checkClientBytes[0] = CheckClientParametersID
-		checkClientBytes[1] = ipVer
-		copy(checkClientBytes[2:2+len(ipBytes)], ipBytes)
-		binary.BigEndian.PutUint16(checkClientBytes[2+len(ipBytes):4+len(ipBytes)], checkClientCommand.SourcePort)
-		binary.BigEndian.PutUint16(checkClientBytes[4+len(ipBytes):6+len(ipBytes)], checkClientCommand.DestPort)
+		copy(checkClientBytes[1:1+len(address)], address)
+		binary.BigEndian.PutUint16(checkClientBytes[1+len(address):3+len(address)], checkClientCommand.SourcePort)
+		binary.BigEndian.PutUint16(checkClientBytes[3+len(address):5+len(address)], checkClientCommand.DestPort)
 
 		var protocol uint8
 
@@ -261,7 +345,7 @@ func Marshal(commandType string, command interface{}) ([]byte, error) {
 			return nil, fmt.Errorf("invalid protocol")
 		}
 
-		checkClientBytes[6+len(ipBytes)] = protocol
+		checkClientBytes[5+len(address)] = protocol
 
 		return checkClientBytes, nil
 	case "checkServerParameters":
@@ -271,10 +355,10 @@ func Marshal(commandType string, command interface{}) ([]byte, error) {
 			return nil, fmt.Errorf("failed to typecast")
 		}
 
-		serverCommandBytes := make([]byte, 1+2+len(checkServerCommand.Arguments))
+		serverCommandBytes := make([]byte, 1+4+len(checkServerCommand.Arguments))
 		serverCommandBytes[0] = CheckServerParametersID
-		binary.BigEndian.PutUint16(serverCommandBytes[1:3], uint16(len(checkServerCommand.Arguments)))
-		copy(serverCommandBytes[3:], checkServerCommand.Arguments)
+		binary.BigEndian.PutUint32(serverCommandBytes[1:5], uint32(len(checkServerCommand.Arguments)))
+		copy(serverCommandBytes[5:], checkServerCommand.Arguments)
 
 		return serverCommandBytes, nil
 	case "checkParametersResponse":
@@ -294,6 +378,10 @@ func Marshal(commandType string, command interface{}) ([]byte, error) {
 			return nil, fmt.Errorf("invalid mode recieved (must be either checkClientParameters or checkServerParameters)")
 		}
 
+		if len(checkParametersCommand.Message) > math.MaxUint16 {
+			return nil, fmt.Errorf("message is too long")
+		}
+
 		var isValid uint8
 
 		if checkParametersCommand.IsValid {
@@ -319,6 +407,10 @@ func Marshal(commandType string, command interface{}) ([]byte, error) {
 			return nil, fmt.Errorf("failed to typecast")
 		}
 
+		if len(backendStatusResponse.Message) > math.MaxUint16 {
+			return nil, fmt.Errorf("message is too long")
+		}
+
 		var isRunning uint8
 
 		if backendStatusResponse.IsRunning {
@@ -357,28 +449,20 @@ func Marshal(commandType string, command interface{}) ([]byte, error) {
 			return nil, fmt.Errorf("failed to typecast")
 		}
 
-		sourceIP := net.ParseIP(proxyStatusRequest.SourceIP)
-
-		var ipVer uint8
-		var ipBytes []byte
+		address, err := marshalAddress(proxyStatusRequest.SourceIP)
 
-		if sourceIP.To4() == nil {
-			ipBytes = sourceIP.To16()
-			ipVer = IPv6
-		} else {
-			ipBytes = sourceIP.To4()
-			ipVer = IPv4
+		if err != nil {
+			return nil, err
 		}
 
-		proxyStatusRequestBytes := make([]byte, 1+1+len(ipBytes)+2+2+1)
+		proxyStatusRequestBytes := make([]byte, 1+len(address)+2+2+1)
 
 		proxyStatusRequestBytes[0] = ProxyStatusRequestID
-		proxyStatusRequestBytes[1] = ipVer
 
-		copy(proxyStatusRequestBytes[2:2+len(ipBytes)], ipBytes)
+		copy(proxyStatusRequestBytes[1:1+len(address)], address)
 
-		binary.BigEndian.PutUint16(proxyStatusRequestBytes[2+len(ipBytes):4+len(ipBytes)], proxyStatusRequest.SourcePort)
-		binary.BigEndian.PutUint16(proxyStatusRequestBytes[4+len(ipBytes):6+len(ipBytes)], proxyStatusRequest.DestPort)
+		binary.BigEndian.PutUint16(proxyStatusRequestBytes[1+len(address):3+len(address)], proxyStatusRequest.SourcePort)
+		binary.BigEndian.PutUint16(proxyStatusRequestBytes[3+len(address):5+len(address)], proxyStatusRequest.DestPort)
 
 		var protocol uint8
 
@@ -390,7 +474,7 @@ func Marshal(commandType string, command interface{}) ([]byte, error) {
 			return nil, fmt.Errorf("invalid protocol")
 		}
 
-		proxyStatusRequestBytes[6+len(ipBytes)] = protocol
+		proxyStatusRequestBytes[5+len(address)] = protocol
 
 		return proxyStatusRequestBytes, nil
 	case "proxyStatusResponse":
@@ -400,28 +484,20 @@ func Marshal(commandType string, command interface{}) ([]byte, error) {
 			return nil, fmt.Errorf("failed to typecast")
 		}
 
-		sourceIP := net.ParseIP(proxyStatusResponse.SourceIP)
-
-		var ipVer uint8
-		var ipBytes []byte
+		address, err := marshalAddress(proxyStatusResponse.SourceIP)
 
-		if sourceIP.To4() == nil {
-			ipBytes = sourceIP.To16()
-			ipVer = IPv6
-		} else {
-			ipBytes = sourceIP.To4()
-			ipVer = IPv4
+		if err != nil {
+			return nil, err
 		}
 
-		proxyStatusResponseBytes := make([]byte, 1+1+len(ipBytes)+2+2+1+1)
+		proxyStatusResponseBytes := make([]byte, 1+len(address)+2+2+1+1+4)
 
 		proxyStatusResponseBytes[0] = ProxyStatusResponseID
-		proxyStatusResponseBytes[1] = ipVer
 
-		copy(proxyStatusResponseBytes[2:2+len(ipBytes)], ipBytes)
+		copy(proxyStatusResponseBytes[1:1+len(address)], address)
 
-		binary.BigEndian.PutUint16(proxyStatusResponseBytes[2+len(ipBytes):4+len(ipBytes)], proxyStatusResponse.SourcePort)
-		binary.BigEndian.PutUint16(proxyStatusResponseBytes[4+len(ipBytes):6+len(ipBytes)], proxyStatusResponse.DestPort)
+		binary.BigEndian.PutUint16(proxyStatusResponseBytes[1+len(address):3+len(address)], proxyStatusResponse.SourcePort)
+		binary.BigEndian.PutUint16(proxyStatusResponseBytes[3+len(address):5+len(address)], proxyStatusResponse.DestPort)
 
 		var protocol uint8
 
@@ -433,7 +509,7 @@ func Marshal(commandType string, command interface{}) ([]byte, error) {
 			return nil, fmt.Errorf("invalid protocol")
 		}
 
-		proxyStatusResponseBytes[6+len(ipBytes)] = protocol
+		proxyStatusResponseBytes[5+len(address)] = protocol
 
 		var isActive uint8
 
@@ -443,9 +519,10 @@ func Marshal(commandType string, command interface{}) ([]byte, error) {
 			isActive = 0
 		}
 
-		proxyStatusResponseBytes[7+len(ipBytes)] = isActive
+		proxyStatusResponseBytes[6+len(address)] = isActive
+		binary.BigEndian.PutUint32(proxyStatusResponseBytes[7+len(address):11+len(address)], proxyStatusResponse.RemainingConnections)
 
-		return proxyStatusResponseBytes, nil
+		return appendString(proxyStatusResponseBytes, proxyStatusResponse.Message)
 	case "proxyInstanceResponse":
 		proxyConectionResponse, ok := command.(*ProxyInstanceResponse)
 
@@ -501,6 +578,305 @@ func Marshal(commandType string, command interface{}) ([]byte, error) {
 		}
 
 		return []byte{ProxyConnectionsRequestID}, nil
+	case "hello":
+		helloCommand, ok := command.(*Hello)
+
+		if !ok {
+			return nil, fmt.Errorf("failed to typecast")
+		}
+
+		helloBytes := make([]byte, 1+2)
+		helloBytes[0] = HelloID
+		binary.BigEndian.PutUint16(helloBytes[1:3], helloCommand.ProtocolVersion)
+
+		return helloBytes, nil
+	case "helloResponse":
+		helloResponse, ok := command.(*HelloResponse)
+
+		if !ok {
+			return nil, fmt.Errorf("failed to typecast")
+		}
+
+		if len(helloResponse.Capabilities) > 255 {
+			return nil, fmt.Errorf("too many capabilities (maximum is 255)")
+		}
+
+		if len(helloResponse.BackendName) > math.MaxUint16 || len(helloResponse.BackendVersion) > math.MaxUint16 {
+			return nil, fmt.Errorf("backend name or version is too long")
+		}
+
+		totalSize := 1 + 2 + 2 + len(helloResponse.BackendName) + 2 + len(helloResponse.BackendVersion) + 1
+
+		for _, capability := range helloResponse.Capabilities {
+			if len(capability) > math.MaxUint16 {
+				return nil, fmt.Errorf("capability is too long")
+			}
+
+			totalSize += 2 + len(capability)
+		}
+
+		helloResponseBytes := make([]byte, totalSize)
+		helloResponseBytes[0] = HelloResponseID
+		binary.BigEndian.PutUint16(helloResponseBytes[1:3], helloResponse.ProtocolVersion)
+
+		currentPosition := 3
+
+		binary.BigEndian.PutUint16(helloResponseBytes[currentPosition:currentPosition+2], uint16(len(helloResponse.BackendName)))
+		copy(helloResponseBytes[currentPosition+2:], helloResponse.BackendName)
+		currentPosition += 2 + len(helloResponse.BackendName)
+
+		binary.BigEndian.PutUint16(helloResponseBytes[currentPosition:currentPosition+2], uint16(len(helloResponse.BackendVersion)))
+		copy(helloResponseBytes[currentPosition+2:], helloResponse.BackendVersion)
+		currentPosition += 2 + len(helloResponse.BackendVersion)
+
+		helloResponseBytes[currentPosition] = uint8(len(helloResponse.Capabilities))
+		currentPosition++
+
+		for _, capability := range helloResponse.Capabilities {
+			binary.BigEndian.PutUint16(helloResponseBytes[currentPosition:currentPosition+2], uint16(len(capability)))
+			copy(helloResponseBytes[currentPosition+2:], capability)
+			currentPosition += 2 + len(capability)
+		}
+
+		return helloResponseBytes, nil
+	case "connectionOpened":
+		connectionOpened, ok := command.(*ConnectionOpened)
+
+		if !ok {
+			return nil, fmt.Errorf("failed to typecast")
+		}
+
+		connectionBlock, err := marshalIndividualConnectionStruct(connectionOpened.Connection)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return append([]byte{ConnectionOpenedID}, connectionBlock...), nil
+	case "connectionClosed":
+		connectionClosed, ok := command.(*ConnectionClosed)
+
+		if !ok {
+			return nil, fmt.Errorf("failed to typecast")
+		}
+
+		connectionBlock, err := marshalIndividualConnectionStruct(connectionClosed.Connection)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return append([]byte{ConnectionClosedID}, connectionBlock...), nil
+	case "proxyFailed":
+		proxyFailed, ok := command.(*ProxyFailed)
+
+		if !ok {
+			return nil, fmt.Errorf("failed to typecast")
+		}
+
+		proxyBlock, err := marshalIndividualProxyStruct(&ProxyInstance{
+			SourceIP:   proxyFailed.SourceIP,
+			SourcePort: proxyFailed.SourcePort,
+			DestPort:   proxyFailed.DestPort,
+			Protocol:   proxyFailed.Protocol,
+		})
+
+		if err != nil {
+			return nil, err
+		}
+
+		if len(proxyFailed.Message) > math.MaxUint16 {
+			return nil, fmt.Errorf("message is too long")
+		}
+
+		proxyFailedBytes := make([]byte, 1+len(proxyBlock)+2+len(proxyFailed.Message))
+		proxyFailedBytes[0] = ProxyFailedID
+		copy(proxyFailedBytes[1:1+len(proxyBlock)], proxyBlock)
+
+		binary.BigEndian.PutUint16(proxyFailedBytes[1+len(proxyBlock):3+len(proxyBlock)], uint16(len(proxyFailed.Message)))
+		copy(proxyFailedBytes[3+len(proxyBlock):], proxyFailed.Message)
+
+		return proxyFailedBytes, nil
+	case "upstreamDisconnected":
+		upstreamDisconnected, ok := command.(*UpstreamDisconnected)
+
+		if !ok {
+			return nil, fmt.Errorf("failed to typecast")
+		}
+
+		if len(upstreamDisconnected.Message) > math.MaxUint16 {
+			return nil, fmt.Errorf("message is too long")
+		}
+
+		upstreamDisconnectedBytes := make([]byte, 1+2+len(upstreamDisconnected.Message))
+		upstreamDisconnectedBytes[0] = UpstreamDisconnectedID
+
+		binary.BigEndian.PutUint16(upstreamDisconnectedBytes[1:3], uint16(len(upstreamDisconnected.Message)))
+		copy(upstreamDisconnectedBytes[3:], upstreamDisconnected.Message)
+
+		return upstreamDisconnectedBytes, nil
+	case "killConnection":
+		killConnectionCommand, ok := command.(*KillConnection)
+
+		if !ok {
+			return nil, fmt.Errorf("failed to typecast")
+		}
+
+		connectionBlock, err := marshalIndividualConnectionStruct(&ProxyClientConnection{
+			SourceIP:   killConnectionCommand.SourceIP,
+			SourcePort: killConnectionCommand.SourcePort,
+			DestPort:   killConnectionCommand.DestPort,
+			ClientIP:   killConnectionCommand.ClientIP,
+			ClientPort: killConnectionCommand.ClientPort,
+			Protocol:   killConnectionCommand.Protocol,
+		})
+
+		if err != nil {
+			return nil, err
+		}
+
+		return append([]byte{KillConnectionID}, connectionBlock...), nil
+	case "killConnectionResponse":
+		killConnectionResponse, ok := command.(*KillConnectionResponse)
+
+		if !ok {
+			return nil, fmt.Errorf("failed to typecast")
+		}
+
+		var isKilled uint8
+
+		if killConnectionResponse.IsKilled {
+			isKilled = 1
+		}
+
+		return appendString([]byte{KillConnectionResponseID, isKilled}, killConnectionResponse.Message)
+	case "parametersSchemaRequest":
+		_, ok := command.(*ParametersSchemaRequest)
+
+		if !ok {
+			return nil, fmt.Errorf("failed to typecast")
+		}
+
+		return []byte{ParametersSchemaRequestID}, nil
+	case "parametersSchemaResponse":
+		parametersSchemaResponse, ok := command.(*ParametersSchemaResponse)
+
+		if !ok {
+			return nil, fmt.Errorf("failed to typecast")
+		}
+
+		schemaResponseBytes := make([]byte, 1+4+len(parametersSchemaResponse.Schema))
+		schemaResponseBytes[0] = ParametersSchemaResponseID
+		binary.BigEndian.PutUint32(schemaResponseBytes[1:5], uint32(len(parametersSchemaResponse.Schema)))
+		copy(schemaResponseBytes[5:], parametersSchemaResponse.Schema)
+
+		return schemaResponseBytes, nil
+	case "addProxies":
+		addProxiesCommand, ok := command.(*AddProxies)
+
+		if !ok {
+			return nil, fmt.Errorf("failed to typecast")
+		}
+
+		items := make([]interface{}, len(addProxiesCommand.Proxies))
+
+		for proxyIndex, proxy := range addProxiesCommand.Proxies {
+			items[proxyIndex] = proxy
+		}
+
+		return marshalBatch(AddProxiesID, "addProxy", items)
+	case "removeProxies":
+		removeProxiesCommand, ok := command.(*RemoveProxies)
+
+		if !ok {
+			return nil, fmt.Errorf("failed to typecast")
+		}
+
+		items := make([]interface{}, len(removeProxiesCommand.Proxies))
+
+		for proxyIndex, proxy := range removeProxiesCommand.Proxies {
+			items[proxyIndex] = proxy
+		}
+
+		return marshalBatch(RemoveProxiesID, "removeProxy", items)
+	case "proxiesStatusResponse":
+		proxiesStatusResponse, ok := command.(*ProxiesStatusResponse)
+
+		if !ok {
+			return nil, fmt.Errorf("failed to typecast")
+		}
+
+		items := make([]interface{}, len(proxiesStatusResponse.Results))
+
+		for resultIndex, result := range proxiesStatusResponse.Results {
+			items[resultIndex] = result
+		}
+
+		return marshalBatch(ProxiesStatusResponseID, "proxyStatusResponse", items)
+	case "ping":
+		ping, ok := command.(*Ping)
+
+		if !ok {
+			return nil, fmt.Errorf("failed to typecast")
+		}
+
+		pingBytes := make([]byte, 1+8+8)
+		pingBytes[0] = PingID
+		binary.BigEndian.PutUint64(pingBytes[1:9], ping.Nonce)
+		binary.BigEndian.PutUint64(pingBytes[9:17], uint64(ping.Timestamp.UnixNano()))
+
+		return pingBytes, nil
+	case "pong":
+		pong, ok := command.(*Pong)
+
+		if !ok {
+			return nil, fmt.Errorf("failed to typecast")
+		}
+
+		pongBytes := make([]byte, 1+8+8)
+		pongBytes[0] = PongID
+		binary.BigEndian.PutUint64(pongBytes[1:9], pong.Nonce)
+		binary.BigEndian.PutUint64(pongBytes[9:17], uint64(pong.Timestamp.UnixNano()))
+
+		return pongBytes, nil
+	case "logMessage":
+		logMessage, ok := command.(*LogMessage)
+
+		if !ok {
+			return nil, fmt.Errorf("failed to typecast")
+		}
+
+		if len(logMessage.Fields) > math.MaxUint16 {
+			return nil, fmt.Errorf("too many fields (maximum is %d)", math.MaxUint16)
+		}
+
+		logMessageBytes := make([]byte, 1+8, 1+8+2+len(logMessage.Level)+2+len(logMessage.Message)+2)
+		logMessageBytes[0] = LogMessageID
+		binary.BigEndian.PutUint64(logMessageBytes[1:9], uint64(logMessage.Time.UnixNano()))
+
+		var err error
+
+		if logMessageBytes, err = appendString(logMessageBytes, logMessage.Level); err != nil {
+			return nil, err
+		}
+
+		if logMessageBytes, err = appendString(logMessageBytes, logMessage.Message); err != nil {
+			return nil, err
+		}
+
+		logMessageBytes = binary.BigEndian.AppendUint16(logMessageBytes, uint16(len(logMessage.Fields)))
+
+		for _, field := range logMessage.Fields {
+			if logMessageBytes, err = appendString(logMessageBytes, field.Key); err != nil {
+				return nil, err
+			}
+
+			if logMessageBytes, err = appendString(logMessageBytes, field.Value); err != nil {
+				return nil, err
+			}
+		}
+
+		return logMessageBytes, nil
 	}
 
 	return nil, fmt.Errorf("couldn't match command name")
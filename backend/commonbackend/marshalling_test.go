@@ -3,8 +3,10 @@ package commonbackend
 import (
 	"bytes"
 	"log"
+	"net"
 	"os"
 	"testing"
+	"time"
 )
 
 var logLevel = os.Getenv("HERMES_LOG_LEVEL")
@@ -155,10 +157,10 @@ func TestAddConnectionCommandMarshalSupport(t *testing.T) {
 	}
 }
 
-func TestRemoveConnectionCommandMarshalSupport(t *testing.T) {
-	commandInput := &RemoveProxy{
-		Type:       "removeProxy",
-		SourceIP:   "192.168.0.139",
+func TestAddConnectionAddressTypesMarshalSupport(t *testing.T) {
+	commandInput := &AddProxy{
+		Type:       "addProxy",
+		SourceIP:   "minecraft.example.com",
 		SourcePort: 19132,
 		DestPort:   19132,
 		Protocol:   "tcp",
@@ -166,6 +168,83 @@ func TestRemoveConnectionCommandMarshalSupport(t *testing.T) {
 
 	commandMarshalled, err := Marshal(commandInput.Type, commandInput)
 
+	if logLevel == "debug" {
+		log.Printf("Generated array contents: %v", commandMarshalled)
+	}
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	buf := bytes.NewBuffer(commandMarshalled)
+	_, commandUnmarshalledRaw, err := Unmarshal(buf)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	commandUnmarshalled, ok := commandUnmarshalledRaw.(*AddProxy)
+
+	if !ok {
+		t.Fatal("failed typecast")
+	}
+
+	if commandInput.SourceIP != commandUnmarshalled.SourceIP {
+		t.Fail()
+		log.Printf("SourceIP's are not equal (orig: %s, unmsh: %s)", commandInput.SourceIP, commandUnmarshalled.SourceIP)
+	}
+
+	if commandInput.SourcePort != commandUnmarshalled.SourcePort {
+		t.Fail()
+		log.Printf("SourcePort's are not equal (orig: %d, unmsh: %d)", commandInput.SourcePort, commandUnmarshalled.SourcePort)
+	}
+
+	commandInput.SourceIP = "/var/run/docker.sock"
+	commandInput.SourcePort = 0
+
+	commandMarshalled, err = Marshal(commandInput.Type, commandInput)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	_, commandUnmarshalledRaw, err = Unmarshal(bytes.NewBuffer(commandMarshalled))
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	commandUnmarshalled, ok = commandUnmarshalledRaw.(*AddProxy)
+
+	if !ok {
+		t.Fatal("failed typecast")
+	}
+
+	if commandInput.SourceIP != commandUnmarshalled.SourceIP {
+		t.Fail()
+		log.Printf("Unix socket paths are not equal (orig: %s, unmsh: %s)", commandInput.SourceIP, commandUnmarshalled.SourceIP)
+	}
+
+	commandInput.SourceIP = "not a hostname!"
+
+	if _, err := Marshal(commandInput.Type, commandInput); err == nil {
+		t.Fail()
+		log.Print("invalid address was marshalled without an error")
+	}
+}
+
+func TestRemoveConnectionCommandMarshalSupport(t *testing.T) {
+	commandInput := &RemoveProxy{
+		Type:         "removeProxy",
+		SourceIP:     "192.168.0.139",
+		SourcePort:   19132,
+		DestPort:     19132,
+		Protocol:     "tcp",
+		DrainTimeout: 30 * time.Second,
+	}
+
+	commandMarshalled, err := Marshal(commandInput.Type, commandInput)
+
 	if err != nil {
 		t.Fatal(err.Error())
 	}
@@ -216,6 +295,11 @@ func TestRemoveConnectionCommandMarshalSupport(t *testing.T) {
 		t.Fail()
 		log.Printf("Protocols are not equal (orig: %s, unmsh: %s)", commandInput.Protocol, commandUnmarshalled.Protocol)
 	}
+
+	if commandInput.DrainTimeout != commandUnmarshalled.DrainTimeout {
+		t.Fail()
+		log.Printf("DrainTimeout's are not equal (orig: %s, unmsh: %s)", commandInput.DrainTimeout, commandUnmarshalled.DrainTimeout)
+	}
 }
 
 func TestGetAllConnectionsCommandMarshalSupport(t *testing.T) {
@@ -228,13 +312,18 @@ func TestGetAllConnectionsCommandMarshalSupport(t *testing.T) {
 				DestPort:   19132,
 				ClientIP:   "127.0.0.1",
 				ClientPort: 12321,
+				Protocol:   "tcp",
 			},
 			{
-				SourceIP:   "127.0.0.1",
-				SourcePort: 19132,
-				DestPort:   19132,
-				ClientIP:   "192.168.0.168",
-				ClientPort: 23457,
+				SourceIP:          "127.0.0.1",
+				SourcePort:        19132,
+				DestPort:          19132,
+				ClientIP:          "192.168.0.168",
+				ClientPort:        23457,
+				Protocol:          "udp",
+				ConnectionStarted: time.UnixMilli(1734990011000),
+				BytesSent:         1 << 33,
+				BytesReceived:     1337,
 			},
 			{
 				SourceIP:   "127.0.0.1",
@@ -242,6 +331,7 @@ func TestGetAllConnectionsCommandMarshalSupport(t *testing.T) {
 				DestPort:   19132,
 				ClientIP:   "68.42.203.47",
 				ClientPort: 38721,
+				Protocol:   "tcp",
 			},
 		},
 	}
@@ -306,6 +396,26 @@ func TestGetAllConnectionsCommandMarshalSupport(t *testing.T) {
 			t.Fail()
 			log.Printf("(in #%d) ClientPort's are not equal (orig: %d, unmsh: %d)", commandIndex, originalConnection.ClientPort, remoteConnection.ClientPort)
 		}
+
+		if originalConnection.Protocol != remoteConnection.Protocol {
+			t.Fail()
+			log.Printf("(in #%d) Protocols are not equal (orig: %s, unmsh: %s)", commandIndex, originalConnection.Protocol, remoteConnection.Protocol)
+		}
+
+		if !originalConnection.ConnectionStarted.Equal(remoteConnection.ConnectionStarted) {
+			t.Fail()
+			log.Printf("(in #%d) ConnectionStarted's are not equal (orig: %s, unmsh: %s)", commandIndex, originalConnection.ConnectionStarted, remoteConnection.ConnectionStarted)
+		}
+
+		if originalConnection.BytesSent != remoteConnection.BytesSent {
+			t.Fail()
+			log.Printf("(in #%d) BytesSent's are not equal (orig: %d, unmsh: %d)", commandIndex, originalConnection.BytesSent, remoteConnection.BytesSent)
+		}
+
+		if originalConnection.BytesReceived != remoteConnection.BytesReceived {
+			t.Fail()
+			log.Printf("(in #%d) BytesReceived's are not equal (orig: %d, unmsh: %d)", commandIndex, originalConnection.BytesReceived, remoteConnection.BytesReceived)
+		}
 	}
 }
 
@@ -634,12 +744,14 @@ func TestProxyStatusRequestMarshalSupport(t *testing.T) {
 
 func TestProxyStatusResponseMarshalSupport(t *testing.T) {
 	commandInput := &ProxyStatusResponse{
-		Type:       "proxyStatusResponse",
-		SourceIP:   "192.168.0.139",
-		SourcePort: 19132,
-		DestPort:   19132,
-		Protocol:   "tcp",
-		IsActive:   true,
+		Type:                 "proxyStatusResponse",
+		SourceIP:             "192.168.0.139",
+		SourcePort:           19132,
+		DestPort:             19132,
+		Protocol:             "tcp",
+		IsActive:             true,
+		RemainingConnections: 3,
+		Message:              "address already in use",
 	}
 
 	commandMarshalled, err := Marshal(commandInput.Type, commandInput)
@@ -699,6 +811,16 @@ func TestProxyStatusResponseMarshalSupport(t *testing.T) {
 		t.Fail()
 		log.Printf("IsActive's are not equal (orig: %t, unmsh: %t)", commandInput.IsActive, commandUnmarshalled.IsActive)
 	}
+
+	if commandInput.RemainingConnections != commandUnmarshalled.RemainingConnections {
+		t.Fail()
+		log.Printf("RemainingConnections are not equal (orig: %d, unmsh: %d)", commandInput.RemainingConnections, commandUnmarshalled.RemainingConnections)
+	}
+
+	if commandInput.Message != commandUnmarshalled.Message {
+		t.Fail()
+		log.Printf("Messages are not equal (orig: %s, unmsh: %s)", commandInput.Message, commandUnmarshalled.Message)
+	}
 }
 
 func TestProxyConnectionRequestMarshalSupport(t *testing.T) {
@@ -822,3 +944,902 @@ func TestProxyConnectionResponseMarshalSupport(t *testing.T) {
 		}
 	}
 }
+
+func TestHelloMarshalSupport(t *testing.T) {
+	commandInput := &Hello{
+		Type:            "hello",
+		ProtocolVersion: ProtocolVersion,
+	}
+
+	commandMarshalled, err := Marshal(commandInput.Type, commandInput)
+
+	if logLevel == "debug" {
+		log.Printf("Generated array contents: %v", commandMarshalled)
+	}
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	buf := bytes.NewBuffer(commandMarshalled)
+	commandType, commandUnmarshalledRaw, err := Unmarshal(buf)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if commandType != commandInput.Type {
+		t.Fail()
+		log.Print("command type does not match up!")
+	}
+
+	commandUnmarshalled, ok := commandUnmarshalledRaw.(*Hello)
+
+	if !ok {
+		t.Fatal("failed typecast")
+	}
+
+	if commandInput.Type != commandUnmarshalled.Type {
+		t.Fail()
+		log.Printf("Types are not equal (orig: %s, unmsh: %s)", commandInput.Type, commandUnmarshalled.Type)
+	}
+
+	if commandInput.ProtocolVersion != commandUnmarshalled.ProtocolVersion {
+		t.Fail()
+		log.Printf("ProtocolVersion's are not equal (orig: %d, unmsh: %d)", commandInput.ProtocolVersion, commandUnmarshalled.ProtocolVersion)
+	}
+}
+
+func TestHelloResponseMarshalSupport(t *testing.T) {
+	commandInput := &HelloResponse{
+		Type:            "helloResponse",
+		ProtocolVersion: ProtocolVersion,
+		BackendName:     "automated-testing",
+		BackendVersion:  "1.0.0",
+		Capabilities:    []string{CapabilityTCP, CapabilityUDP, CapabilityConnectionEvents},
+	}
+
+	commandMarshalled, err := Marshal(commandInput.Type, commandInput)
+
+	if logLevel == "debug" {
+		log.Printf("Generated array contents: %v", commandMarshalled)
+	}
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	buf := bytes.NewBuffer(commandMarshalled)
+	commandType, commandUnmarshalledRaw, err := Unmarshal(buf)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if commandType != commandInput.Type {
+		t.Fail()
+		log.Print("command type does not match up!")
+	}
+
+	commandUnmarshalled, ok := commandUnmarshalledRaw.(*HelloResponse)
+
+	if !ok {
+		t.Fatal("failed typecast")
+	}
+
+	if commandInput.Type != commandUnmarshalled.Type {
+		t.Fail()
+		log.Printf("Types are not equal (orig: %s, unmsh: %s)", commandInput.Type, commandUnmarshalled.Type)
+	}
+
+	if commandInput.ProtocolVersion != commandUnmarshalled.ProtocolVersion {
+		t.Fail()
+		log.Printf("ProtocolVersion's are not equal (orig: %d, unmsh: %d)", commandInput.ProtocolVersion, commandUnmarshalled.ProtocolVersion)
+	}
+
+	if commandInput.BackendName != commandUnmarshalled.BackendName {
+		t.Fail()
+		log.Printf("BackendName's are not equal (orig: %s, unmsh: %s)", commandInput.BackendName, commandUnmarshalled.BackendName)
+	}
+
+	if commandInput.BackendVersion != commandUnmarshalled.BackendVersion {
+		t.Fail()
+		log.Printf("BackendVersion's are not equal (orig: %s, unmsh: %s)", commandInput.BackendVersion, commandUnmarshalled.BackendVersion)
+	}
+
+	if len(commandInput.Capabilities) != len(commandUnmarshalled.Capabilities) {
+		t.Fatalf("Capability counts are not equal (orig: %d, unmsh: %d)", len(commandInput.Capabilities), len(commandUnmarshalled.Capabilities))
+	}
+
+	for capabilityIndex, originalCapability := range commandInput.Capabilities {
+		if originalCapability != commandUnmarshalled.Capabilities[capabilityIndex] {
+			t.Fail()
+			log.Printf("(in #%d) Capabilities are not equal (orig: %s, unmsh: %s)", capabilityIndex, originalCapability, commandUnmarshalled.Capabilities[capabilityIndex])
+		}
+	}
+}
+
+func TestRequestIDMarshalSupport(t *testing.T) {
+	commandInput := &BackendStatusRequest{
+		Type: "backendStatusRequest",
+	}
+
+	var requestID uint32 = 1337
+
+	commandMarshalled, err := MarshalWithRequestID(requestID, commandInput.Type, commandInput)
+
+	if logLevel == "debug" {
+		log.Printf("Generated array contents: %v", commandMarshalled)
+	}
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	buf := bytes.NewBuffer(commandMarshalled)
+	unmarshalledRequestID, commandType, _, err := UnmarshalWithRequestID(buf)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if commandType != commandInput.Type {
+		t.Fail()
+		log.Print("command type does not match up!")
+	}
+
+	if requestID != unmarshalledRequestID {
+		t.Fail()
+		log.Printf("Request ID's are not equal (orig: %d, unmsh: %d)", requestID, unmarshalledRequestID)
+	}
+}
+
+func TestLargeStartCommandMarshalSupport(t *testing.T) {
+	commandInput := &Start{
+		Type:      "start",
+		Arguments: bytes.Repeat([]byte("A"), 128*1024),
+	}
+
+	commandMarshalled, err := Marshal(commandInput.Type, commandInput)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	buf := bytes.NewBuffer(commandMarshalled)
+	_, commandUnmarshalledRaw, err := Unmarshal(buf)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	commandUnmarshalled, ok := commandUnmarshalledRaw.(*Start)
+
+	if !ok {
+		t.Fatal("failed typecast")
+	}
+
+	if !bytes.Equal(commandInput.Arguments, commandUnmarshalled.Arguments) {
+		t.Fail()
+		log.Printf("Arguments are not equal (orig len: %d, unmsh len: %d)", len(commandInput.Arguments), len(commandUnmarshalled.Arguments))
+	}
+}
+
+func TestUnknownCommandIsSkipped(t *testing.T) {
+	// A frame carrying a command ID from the future, followed by one we understand
+	unknownFrame := []byte{FrameVersion, 0, 0, 0, 7, 0, 0, 0, 1, 255, 1, 2}
+
+	commandMarshalled, err := MarshalWithRequestID(2, "stop", &Stop{
+		Type: "stop",
+	})
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	buf := bytes.NewBuffer(append(unknownFrame, commandMarshalled...))
+	requestID, commandType, _, err := UnmarshalWithRequestID(buf)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if commandType != "stop" || requestID != 2 {
+		t.Fail()
+		log.Printf("unknown command was not skipped (type: %s, request ID: %d)", commandType, requestID)
+	}
+}
+
+func TestDeadlineFrameMarshalSupport(t *testing.T) {
+	deadline := time.Now().Add(30 * time.Second)
+
+	commandMarshalled, err := MarshalWithDeadline(3, deadline, "stop", &Stop{
+		Type: "stop",
+	})
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if commandMarshalled[0] != FrameVersionWithDeadline {
+		t.Fail()
+		log.Printf("frame version is not correct (expected %d, got %d)", FrameVersionWithDeadline, commandMarshalled[0])
+	}
+
+	buf := bytes.NewBuffer(commandMarshalled)
+	requestID, deadlineUnmarshalled, commandType, _, err := UnmarshalWithDeadline(buf)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if commandType != "stop" || requestID != 3 {
+		t.Fail()
+		log.Printf("command was not read correctly (type: %s, request ID: %d)", commandType, requestID)
+	}
+
+	// The deadline is sent with millisecond precision, and the time it took to read the frame counts against it
+	if deadlineUnmarshalled.Sub(deadline).Abs() > time.Second {
+		t.Fail()
+		log.Printf("Deadlines are not equal (orig: %s, unmsh: %s)", deadline, deadlineUnmarshalled)
+	}
+
+	// Frames without a deadline should read back as not having one
+	commandMarshalled, err = MarshalWithRequestID(4, "stop", &Stop{
+		Type: "stop",
+	})
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	_, deadlineUnmarshalled, _, _, err = UnmarshalWithDeadline(bytes.NewBuffer(commandMarshalled))
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if !deadlineUnmarshalled.IsZero() {
+		t.Fail()
+		log.Printf("frame without a deadline was read with one: %s", deadlineUnmarshalled)
+	}
+}
+
+func TestOversizedFrameIsRejected(t *testing.T) {
+	oversizedFrame := []byte{FrameVersion, 0xFF, 0xFF, 0xFF, 0xFF}
+
+	if _, _, err := Unmarshal(bytes.NewBuffer(oversizedFrame)); err == nil {
+		t.Fatal("oversized frame was accepted")
+	}
+}
+
+func TestConnectionOpenedMarshalSupport(t *testing.T) {
+	commandInput := &ConnectionOpened{
+		Type: "connectionOpened",
+		Connection: &ProxyClientConnection{
+			SourceIP:   "127.0.0.1",
+			SourcePort: 19132,
+			DestPort:   19132,
+			ClientIP:   "68.42.203.47",
+			ClientPort: 38175,
+			Protocol:   "tcp",
+		},
+	}
+
+	commandMarshalled, err := Marshal(commandInput.Type, commandInput)
+
+	if logLevel == "debug" {
+		log.Printf("Generated array contents: %v", commandMarshalled)
+	}
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	buf := bytes.NewBuffer(commandMarshalled)
+	commandType, commandUnmarshalledRaw, err := Unmarshal(buf)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if commandType != commandInput.Type {
+		t.Fail()
+		log.Print("command type does not match up!")
+	}
+
+	commandUnmarshalled, ok := commandUnmarshalledRaw.(*ConnectionOpened)
+
+	if !ok {
+		t.Fatal("failed typecast")
+	}
+
+	if commandInput.Type != commandUnmarshalled.Type {
+		t.Fail()
+		log.Printf("Types are not equal (orig: %s, unmsh: %s)", commandInput.Type, commandUnmarshalled.Type)
+	}
+
+	originalConnection := commandInput.Connection
+	remoteConnection := commandUnmarshalled.Connection
+
+	if originalConnection.SourceIP != remoteConnection.SourceIP {
+		t.Fail()
+		log.Printf("SourceIP's are not equal (orig: %s, unmsh: %s)", originalConnection.SourceIP, remoteConnection.SourceIP)
+	}
+
+	if originalConnection.SourcePort != remoteConnection.SourcePort {
+		t.Fail()
+		log.Printf("SourcePort's are not equal (orig: %d, unmsh: %d)", originalConnection.SourcePort, remoteConnection.SourcePort)
+	}
+
+	if originalConnection.DestPort != remoteConnection.DestPort {
+		t.Fail()
+		log.Printf("DestPort's are not equal (orig: %d, unmsh: %d)", originalConnection.DestPort, remoteConnection.DestPort)
+	}
+
+	if originalConnection.ClientIP != remoteConnection.ClientIP {
+		t.Fail()
+		log.Printf("ClientIP's are not equal (orig: %s, unmsh: %s)", originalConnection.ClientIP, remoteConnection.ClientIP)
+	}
+
+	if originalConnection.ClientPort != remoteConnection.ClientPort {
+		t.Fail()
+		log.Printf("ClientPort's are not equal (orig: %d, unmsh: %d)", originalConnection.ClientPort, remoteConnection.ClientPort)
+	}
+}
+
+func TestProxyFailedMarshalSupport(t *testing.T) {
+	commandInput := &ProxyFailed{
+		Type:       "proxyFailed",
+		SourceIP:   "192.168.0.139",
+		SourcePort: 19132,
+		DestPort:   19132,
+		Protocol:   "udp",
+		Message:    "Hello from automated testing",
+	}
+
+	commandMarshalled, err := Marshal(commandInput.Type, commandInput)
+
+	if logLevel == "debug" {
+		log.Printf("Generated array contents: %v", commandMarshalled)
+	}
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	buf := bytes.NewBuffer(commandMarshalled)
+	commandType, commandUnmarshalledRaw, err := Unmarshal(buf)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if commandType != commandInput.Type {
+		t.Fail()
+		log.Print("command type does not match up!")
+	}
+
+	commandUnmarshalled, ok := commandUnmarshalledRaw.(*ProxyFailed)
+
+	if !ok {
+		t.Fatal("failed typecast")
+	}
+
+	if commandInput.SourceIP != commandUnmarshalled.SourceIP {
+		t.Fail()
+		log.Printf("SourceIP's are not equal (orig: %s, unmsh: %s)", commandInput.SourceIP, commandUnmarshalled.SourceIP)
+	}
+
+	if commandInput.SourcePort != commandUnmarshalled.SourcePort {
+		t.Fail()
+		log.Printf("SourcePort's are not equal (orig: %d, unmsh: %d)", commandInput.SourcePort, commandUnmarshalled.SourcePort)
+	}
+
+	if commandInput.DestPort != commandUnmarshalled.DestPort {
+		t.Fail()
+		log.Printf("DestPort's are not equal (orig: %d, unmsh: %d)", commandInput.DestPort, commandUnmarshalled.DestPort)
+	}
+
+	if commandInput.Protocol != commandUnmarshalled.Protocol {
+		t.Fail()
+		log.Printf("Protocols are not equal (orig: %s, unmsh: %s)", commandInput.Protocol, commandUnmarshalled.Protocol)
+	}
+
+	if commandInput.Message != commandUnmarshalled.Message {
+		t.Fail()
+		log.Printf("Messages are not equal (orig: %s, unmsh: %s)", commandInput.Message, commandUnmarshalled.Message)
+	}
+}
+
+func TestUpstreamDisconnectedMarshalSupport(t *testing.T) {
+	commandInput := &UpstreamDisconnected{
+		Type:    "upstreamDisconnected",
+		Message: "Hello from automated testing",
+	}
+
+	commandMarshalled, err := Marshal(commandInput.Type, commandInput)
+
+	if logLevel == "debug" {
+		log.Printf("Generated array contents: %v", commandMarshalled)
+	}
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	buf := bytes.NewBuffer(commandMarshalled)
+	commandType, commandUnmarshalledRaw, err := Unmarshal(buf)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if commandType != commandInput.Type {
+		t.Fail()
+		log.Print("command type does not match up!")
+	}
+
+	commandUnmarshalled, ok := commandUnmarshalledRaw.(*UpstreamDisconnected)
+
+	if !ok {
+		t.Fatal("failed typecast")
+	}
+
+	if commandInput.Message != commandUnmarshalled.Message {
+		t.Fail()
+		log.Printf("Messages are not equal (orig: %s, unmsh: %s)", commandInput.Message, commandUnmarshalled.Message)
+	}
+}
+
+func TestLogMessageMarshalSupport(t *testing.T) {
+	commandInput := &LogMessage{
+		Type:    "logMessage",
+		Time:    time.Unix(1700000000, 123456789),
+		Level:   "warn",
+		Message: "Hello from automated testing",
+		Fields: []*LogField{
+			{
+				Key:   "err",
+				Value: "connection refused",
+			},
+			{
+				Key:   "port",
+				Value: "19132",
+			},
+		},
+	}
+
+	commandMarshalled, err := Marshal(commandInput.Type, commandInput)
+
+	if logLevel == "debug" {
+		log.Printf("Generated array contents: %v", commandMarshalled)
+	}
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	buf := bytes.NewBuffer(commandMarshalled)
+	commandType, commandUnmarshalledRaw, err := Unmarshal(buf)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if commandType != commandInput.Type {
+		t.Fail()
+		log.Print("command type does not match up!")
+	}
+
+	commandUnmarshalled, ok := commandUnmarshalledRaw.(*LogMessage)
+
+	if !ok {
+		t.Fatal("failed typecast")
+	}
+
+	if !commandInput.Time.Equal(commandUnmarshalled.Time) {
+		t.Fail()
+		log.Printf("Times are not equal (orig: %s, unmsh: %s)", commandInput.Time, commandUnmarshalled.Time)
+	}
+
+	if commandInput.Level != commandUnmarshalled.Level {
+		t.Fail()
+		log.Printf("Levels are not equal (orig: %s, unmsh: %s)", commandInput.Level, commandUnmarshalled.Level)
+	}
+
+	if commandInput.Message != commandUnmarshalled.Message {
+		t.Fail()
+		log.Printf("Messages are not equal (orig: %s, unmsh: %s)", commandInput.Message, commandUnmarshalled.Message)
+	}
+
+	if len(commandInput.Fields) != len(commandUnmarshalled.Fields) {
+		t.Fatalf("Field lengths are not the same (orig: %d, unmsh: %d)", len(commandInput.Fields), len(commandUnmarshalled.Fields))
+	}
+
+	for fieldIndex, originalField := range commandInput.Fields {
+		unmarshalledField := commandUnmarshalled.Fields[fieldIndex]
+
+		if originalField.Key != unmarshalledField.Key || originalField.Value != unmarshalledField.Value {
+			t.Fail()
+			log.Printf("Fields are not equal (orig: %s=%s, unmsh: %s=%s)", originalField.Key, originalField.Value, unmarshalledField.Key, unmarshalledField.Value)
+		}
+	}
+}
+
+func TestKillConnectionMarshalSupport(t *testing.T) {
+	commandInput := &KillConnection{
+		Type:       "killConnection",
+		SourceIP:   "192.168.0.139",
+		SourcePort: 19132,
+		DestPort:   19132,
+		ClientIP:   "68.42.203.47",
+		ClientPort: 38175,
+		Protocol:   "tcp",
+	}
+
+	commandMarshalled, err := Marshal(commandInput.Type, commandInput)
+
+	if logLevel == "debug" {
+		log.Printf("Generated array contents: %v", commandMarshalled)
+	}
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	buf := bytes.NewBuffer(commandMarshalled)
+	commandType, commandUnmarshalledRaw, err := Unmarshal(buf)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if commandType != commandInput.Type {
+		t.Fail()
+		log.Print("command type does not match up!")
+	}
+
+	commandUnmarshalled, ok := commandUnmarshalledRaw.(*KillConnection)
+
+	if !ok {
+		t.Fatal("failed typecast")
+	}
+
+	if *commandInput != *commandUnmarshalled {
+		t.Fail()
+		log.Printf("Commands are not equal (orig: %+v, unmsh: %+v)", commandInput, commandUnmarshalled)
+	}
+}
+
+func TestKillConnectionResponseMarshalSupport(t *testing.T) {
+	commandInput := &KillConnectionResponse{
+		Type:     "killConnectionResponse",
+		IsKilled: true,
+		Message:  "Hello from automated testing",
+	}
+
+	commandMarshalled, err := Marshal(commandInput.Type, commandInput)
+
+	if logLevel == "debug" {
+		log.Printf("Generated array contents: %v", commandMarshalled)
+	}
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	buf := bytes.NewBuffer(commandMarshalled)
+	commandType, commandUnmarshalledRaw, err := Unmarshal(buf)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if commandType != commandInput.Type {
+		t.Fail()
+		log.Print("command type does not match up!")
+	}
+
+	commandUnmarshalled, ok := commandUnmarshalledRaw.(*KillConnectionResponse)
+
+	if !ok {
+		t.Fatal("failed typecast")
+	}
+
+	if commandInput.IsKilled != commandUnmarshalled.IsKilled {
+		t.Fail()
+		log.Printf("IsKilled is not equal (orig: %t, unmsh: %t)", commandInput.IsKilled, commandUnmarshalled.IsKilled)
+	}
+
+	if commandInput.Message != commandUnmarshalled.Message {
+		t.Fail()
+		log.Printf("Messages are not equal (orig: %s, unmsh: %s)", commandInput.Message, commandUnmarshalled.Message)
+	}
+}
+
+func TestParametersSchemaResponseMarshalSupport(t *testing.T) {
+	commandInput := &ParametersSchemaResponse{
+		Type:   "parametersSchemaResponse",
+		Schema: []byte(`{"type":"object","properties":{"privateKey":{"type":"string","writeOnly":true}}}`),
+	}
+
+	commandMarshalled, err := Marshal(commandInput.Type, commandInput)
+
+	if logLevel == "debug" {
+		log.Printf("Generated array contents: %v", commandMarshalled)
+	}
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	buf := bytes.NewBuffer(commandMarshalled)
+	commandType, commandUnmarshalledRaw, err := Unmarshal(buf)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if commandType != commandInput.Type {
+		t.Fail()
+		log.Print("command type does not match up!")
+	}
+
+	commandUnmarshalled, ok := commandUnmarshalledRaw.(*ParametersSchemaResponse)
+
+	if !ok {
+		t.Fatal("failed typecast")
+	}
+
+	if !bytes.Equal(commandInput.Schema, commandUnmarshalled.Schema) {
+		t.Fail()
+		log.Printf("Schemas are not equal (orig: %s, unmsh: %s)", commandInput.Schema, commandUnmarshalled.Schema)
+	}
+}
+
+func TestPingMarshalSupport(t *testing.T) {
+	commandInput := &Ping{
+		Type:      "ping",
+		Nonce:     0xDEADBEEFCAFE,
+		Timestamp: time.Unix(1734990011, 123456789),
+	}
+
+	commandMarshalled, err := Marshal(commandInput.Type, commandInput)
+
+	if logLevel == "debug" {
+		log.Printf("Generated array contents: %v", commandMarshalled)
+	}
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	buf := bytes.NewBuffer(commandMarshalled)
+	commandType, commandUnmarshalledRaw, err := Unmarshal(buf)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if commandType != commandInput.Type {
+		t.Fail()
+		log.Print("command type does not match up!")
+	}
+
+	commandUnmarshalled, ok := commandUnmarshalledRaw.(*Ping)
+
+	if !ok {
+		t.Fatal("failed typecast")
+	}
+
+	if commandInput.Nonce != commandUnmarshalled.Nonce {
+		t.Fail()
+		log.Printf("Nonces are not equal (orig: %d, unmsh: %d)", commandInput.Nonce, commandUnmarshalled.Nonce)
+	}
+
+	if !commandInput.Timestamp.Equal(commandUnmarshalled.Timestamp) {
+		t.Fail()
+		log.Printf("Timestamps are not equal (orig: %s, unmsh: %s)", commandInput.Timestamp, commandUnmarshalled.Timestamp)
+	}
+}
+
+func TestPongMarshalSupport(t *testing.T) {
+	commandInput := &Pong{
+		Type:      "pong",
+		Nonce:     0xDEADBEEFCAFE,
+		Timestamp: time.Unix(1734990011, 123456789),
+	}
+
+	commandMarshalled, err := Marshal(commandInput.Type, commandInput)
+
+	if logLevel == "debug" {
+		log.Printf("Generated array contents: %v", commandMarshalled)
+	}
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	buf := bytes.NewBuffer(commandMarshalled)
+	commandType, commandUnmarshalledRaw, err := Unmarshal(buf)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if commandType != commandInput.Type {
+		t.Fail()
+		log.Print("command type does not match up!")
+	}
+
+	commandUnmarshalled, ok := commandUnmarshalledRaw.(*Pong)
+
+	if !ok {
+		t.Fatal("failed typecast")
+	}
+
+	if commandInput.Nonce != commandUnmarshalled.Nonce {
+		t.Fail()
+		log.Printf("Nonces are not equal (orig: %d, unmsh: %d)", commandInput.Nonce, commandUnmarshalled.Nonce)
+	}
+
+	if !commandInput.Timestamp.Equal(commandUnmarshalled.Timestamp) {
+		t.Fail()
+		log.Printf("Timestamps are not equal (orig: %s, unmsh: %s)", commandInput.Timestamp, commandUnmarshalled.Timestamp)
+	}
+}
+
+func TestAddProxiesMarshalSupport(t *testing.T) {
+	commandInput := &AddProxies{
+		Type: "addProxies",
+		Proxies: []*AddProxy{
+			{
+				Type:       "addProxy",
+				SourceIP:   "192.168.0.139",
+				SourcePort: 19132,
+				DestPort:   19132,
+				Protocol:   "tcp",
+			},
+			{
+				Type:       "addProxy",
+				SourceIP:   "fe80::1",
+				SourcePort: 25565,
+				DestPort:   25565,
+				Protocol:   "udp",
+			},
+		},
+	}
+
+	commandMarshalled, err := Marshal(commandInput.Type, commandInput)
+
+	if logLevel == "debug" {
+		log.Printf("Generated array contents: %v", commandMarshalled)
+	}
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	buf := bytes.NewBuffer(commandMarshalled)
+	commandType, commandUnmarshalledRaw, err := Unmarshal(buf)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if commandType != commandInput.Type {
+		t.Fail()
+		log.Print("command type does not match up!")
+	}
+
+	commandUnmarshalled, ok := commandUnmarshalledRaw.(*AddProxies)
+
+	if !ok {
+		t.Fatal("failed typecast")
+	}
+
+	if len(commandInput.Proxies) != len(commandUnmarshalled.Proxies) {
+		t.Fatalf("Proxy counts are not equal (orig: %d, unmsh: %d)", len(commandInput.Proxies), len(commandUnmarshalled.Proxies))
+	}
+
+	for proxyIndex, originalProxy := range commandInput.Proxies {
+		unmarshalledProxy := commandUnmarshalled.Proxies[proxyIndex]
+
+		if !net.ParseIP(originalProxy.SourceIP).Equal(net.ParseIP(unmarshalledProxy.SourceIP)) {
+			t.Fail()
+			log.Printf("(proxy #%d) SourceIP's are not equal (orig: %s, unmsh: %s)", proxyIndex, originalProxy.SourceIP, unmarshalledProxy.SourceIP)
+		}
+
+		if originalProxy.SourcePort != unmarshalledProxy.SourcePort {
+			t.Fail()
+			log.Printf("(proxy #%d) SourcePort's are not equal (orig: %d, unmsh: %d)", proxyIndex, originalProxy.SourcePort, unmarshalledProxy.SourcePort)
+		}
+
+		if originalProxy.DestPort != unmarshalledProxy.DestPort {
+			t.Fail()
+			log.Printf("(proxy #%d) DestPort's are not equal (orig: %d, unmsh: %d)", proxyIndex, originalProxy.DestPort, unmarshalledProxy.DestPort)
+		}
+
+		if originalProxy.Protocol != unmarshalledProxy.Protocol {
+			t.Fail()
+			log.Printf("(proxy #%d) Protocols are not equal (orig: %s, unmsh: %s)", proxyIndex, originalProxy.Protocol, unmarshalledProxy.Protocol)
+		}
+	}
+}
+
+func TestProxiesStatusResponseMarshalSupport(t *testing.T) {
+	commandInput := &ProxiesStatusResponse{
+		Type: "proxiesStatusResponse",
+		Results: []*ProxyStatusResponse{
+			{
+				Type:       "proxyStatusResponse",
+				SourceIP:   "192.168.0.139",
+				SourcePort: 19132,
+				DestPort:   19132,
+				Protocol:   "tcp",
+				IsActive:   true,
+			},
+			{
+				Type:       "proxyStatusResponse",
+				SourceIP:   "192.168.0.139",
+				SourcePort: 25565,
+				DestPort:   25565,
+				Protocol:   "udp",
+				IsActive:   false,
+				Message:    "address already in use",
+			},
+		},
+	}
+
+	commandMarshalled, err := Marshal(commandInput.Type, commandInput)
+
+	if logLevel == "debug" {
+		log.Printf("Generated array contents: %v", commandMarshalled)
+	}
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	buf := bytes.NewBuffer(commandMarshalled)
+	commandType, commandUnmarshalledRaw, err := Unmarshal(buf)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if commandType != commandInput.Type {
+		t.Fail()
+		log.Print("command type does not match up!")
+	}
+
+	commandUnmarshalled, ok := commandUnmarshalledRaw.(*ProxiesStatusResponse)
+
+	if !ok {
+		t.Fatal("failed typecast")
+	}
+
+	if len(commandInput.Results) != len(commandUnmarshalled.Results) {
+		t.Fatalf("Result counts are not equal (orig: %d, unmsh: %d)", len(commandInput.Results), len(commandUnmarshalled.Results))
+	}
+
+	for resultIndex, originalResult := range commandInput.Results {
+		unmarshalledResult := commandUnmarshalled.Results[resultIndex]
+
+		if originalResult.SourcePort != unmarshalledResult.SourcePort {
+			t.Fail()
+			log.Printf("(result #%d) SourcePort's are not equal (orig: %d, unmsh: %d)", resultIndex, originalResult.SourcePort, unmarshalledResult.SourcePort)
+		}
+
+		if originalResult.IsActive != unmarshalledResult.IsActive {
+			t.Fail()
+			log.Printf("(result #%d) IsActive's are not equal (orig: %t, unmsh: %t)", resultIndex, originalResult.IsActive, unmarshalledResult.IsActive)
+		}
+
+		if originalResult.Message != unmarshalledResult.Message {
+			t.Fail()
+			log.Printf("(result #%d) Messages are not equal (orig: %s, unmsh: %s)", resultIndex, originalResult.Message, unmarshalledResult.Message)
+		}
+	}
+}
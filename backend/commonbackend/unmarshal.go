@@ -1,126 +1,106 @@
 package commonbackend
 
 import (
+	"bytes"
 	"encoding/binary"
 	"fmt"
 	"io"
 	"net"
+	"time"
 )
 
+var errUnknownCommand = fmt.Errorf("couldn't match command ID")
+
 func unmarshalIndividualConnectionStruct(conn io.Reader) (*ProxyClientConnection, error) {
-	serverIPVersion := make([]byte, 1)
+	// unmarshalAddress returns "no data found" as-is when it hits the end of the list, so don't wrap it here.
+	sourceIP, err := unmarshalAddress(conn)
 
-	if _, err := conn.Read(serverIPVersion); err != nil {
-		return nil, fmt.Errorf("couldn't read server IP version")
+	if err != nil {
+		return nil, err
 	}
 
-	var serverIPSize uint8
+	sourcePort := make([]byte, 2)
 
-	if serverIPVersion[0] == 4 {
-		serverIPSize = IPv4Size
-	} else if serverIPVersion[0] == 6 {
-		serverIPSize = IPv6Size
-	} else if serverIPVersion[0] == '\n' {
-		return nil, fmt.Errorf("no data found")
-	} else {
-		return nil, fmt.Errorf("invalid server IP version recieved")
+	if _, err := io.ReadFull(conn, sourcePort); err != nil {
+		return nil, fmt.Errorf("couldn't read source port")
 	}
 
-	serverIP := make(net.IP, serverIPSize)
+	destinationPort := make([]byte, 2)
 
-	if _, err := conn.Read(serverIP); err != nil {
-		return nil, fmt.Errorf("couldn't read server IP")
+	if _, err := io.ReadFull(conn, destinationPort); err != nil {
+		return nil, fmt.Errorf("couldn't read source port")
 	}
 
-	sourcePort := make([]byte, 2)
+	clientIP, err := unmarshalAddress(conn)
 
-	if _, err := conn.Read(sourcePort); err != nil {
-		return nil, fmt.Errorf("couldn't read source port")
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read client address: %s", err.Error())
 	}
 
-	destinationPort := make([]byte, 2)
+	clientPort := make([]byte, 2)
 
-	if _, err := conn.Read(destinationPort); err != nil {
+	if _, err := io.ReadFull(conn, clientPort); err != nil {
 		return nil, fmt.Errorf("couldn't read source port")
 	}
 
-	clientIPVersion := make([]byte, 1)
+	protocolBytes := make([]byte, 1)
 
-	if _, err := conn.Read(clientIPVersion); err != nil {
-		return nil, fmt.Errorf("couldn't read server IP version")
+	if _, err := io.ReadFull(conn, protocolBytes); err != nil {
+		return nil, fmt.Errorf("couldn't read protocol")
 	}
 
-	var clientIPSize uint8
+	var protocol string
 
-	if clientIPVersion[0] == 4 {
-		clientIPSize = IPv4Size
-	} else if clientIPVersion[0] == 6 {
-		clientIPSize = IPv6Size
+	if protocolBytes[0] == TCP {
+		protocol = "tcp"
+	} else if protocolBytes[0] == UDP {
+		protocol = "udp"
 	} else {
-		return nil, fmt.Errorf("invalid server IP version recieved")
-	}
-
-	clientIP := make(net.IP, clientIPSize)
-
-	if _, err := conn.Read(clientIP); err != nil {
-		return nil, fmt.Errorf("couldn't read server IP")
+		return nil, fmt.Errorf("invalid protocol")
 	}
 
-	clientPort := make([]byte, 2)
+	// Connection start time, bytes sent, and bytes received
+	statistics := make([]byte, 8+8+8)
 
-	if _, err := conn.Read(clientPort); err != nil {
-		return nil, fmt.Errorf("couldn't read source port")
+	if _, err := io.ReadFull(conn, statistics); err != nil {
+		return nil, fmt.Errorf("couldn't read connection statistics")
 	}
 
 	return &ProxyClientConnection{
-		SourceIP:   serverIP.String(),
-		SourcePort: binary.BigEndian.Uint16(sourcePort),
-		DestPort:   binary.BigEndian.Uint16(destinationPort),
-		ClientIP:   clientIP.String(),
-		ClientPort: binary.BigEndian.Uint16(clientPort),
+		SourceIP:          sourceIP,
+		SourcePort:        binary.BigEndian.Uint16(sourcePort),
+		DestPort:          binary.BigEndian.Uint16(destinationPort),
+		ClientIP:          clientIP,
+		ClientPort:        binary.BigEndian.Uint16(clientPort),
+		Protocol:          protocol,
+		ConnectionStarted: time.UnixMilli(int64(binary.BigEndian.Uint64(statistics[0:8]))),
+		BytesSent:         binary.BigEndian.Uint64(statistics[8:16]),
+		BytesReceived:     binary.BigEndian.Uint64(statistics[16:24]),
 	}, nil
 }
 
 func unmarshalIndividualProxyStruct(conn io.Reader) (*ProxyInstance, error) {
-	ipVersion := make([]byte, 1)
+	sourceIP, err := unmarshalAddress(conn)
 
-	if _, err := conn.Read(ipVersion); err != nil {
-		return nil, fmt.Errorf("couldn't read ip version")
-	}
-
-	var ipSize uint8
-
-	if ipVersion[0] == 4 {
-		ipSize = IPv4Size
-	} else if ipVersion[0] == 6 {
-		ipSize = IPv6Size
-	} else if ipVersion[0] == '\n' {
-		return nil, fmt.Errorf("no data found")
-	} else {
-		return nil, fmt.Errorf("invalid IP version recieved")
-	}
-
-	ip := make(net.IP, ipSize)
-
-	if _, err := conn.Read(ip); err != nil {
-		return nil, fmt.Errorf("couldn't read source IP")
+	if err != nil {
+		return nil, err
 	}
 
 	sourcePort := make([]byte, 2)
 
-	if _, err := conn.Read(sourcePort); err != nil {
+	if _, err := io.ReadFull(conn, sourcePort); err != nil {
 		return nil, fmt.Errorf("couldn't read source port")
 	}
 
 	destPort := make([]byte, 2)
 
-	if _, err := conn.Read(destPort); err != nil {
+	if _, err := io.ReadFull(conn, destPort); err != nil {
 		return nil, fmt.Errorf("couldn't read destination port")
 	}
 
 	protocolBytes := make([]byte, 1)
 
-	if _, err := conn.Read(protocolBytes); err != nil {
+	if _, err := io.ReadFull(conn, protocolBytes); err != nil {
 		return nil, fmt.Errorf("couldn't read protocol")
 	}
 
@@ -135,31 +115,225 @@ func unmarshalIndividualProxyStruct(conn io.Reader) (*ProxyInstance, error) {
 	}
 
 	return &ProxyInstance{
-		SourceIP:   ip.String(),
+		SourceIP:   sourceIP,
 		SourcePort: binary.BigEndian.Uint16(sourcePort),
 		DestPort:   binary.BigEndian.Uint16(destPort),
 		Protocol:   protocol,
 	}, nil
 }
 
+// Reads an address written by marshalAddress. IP addresses are returned in their string form, and hostnames and Unix socket paths as-is.
+func unmarshalAddress(conn io.Reader) (string, error) {
+	addressType := make([]byte, 1)
+
+	if _, err := io.ReadFull(conn, addressType); err != nil {
+		return "", fmt.Errorf("couldn't read address type")
+	}
+
+	var ipSize uint8
+
+	switch addressType[0] {
+	case IPv4:
+		ipSize = IPv4Size
+	case IPv6:
+		ipSize = IPv6Size
+	case Hostname:
+		hostname, err := unmarshalString(conn)
+
+		if err != nil {
+			return "", fmt.Errorf("couldn't read hostname")
+		}
+
+		if !IsValidHostname(hostname) {
+			return "", fmt.Errorf("invalid hostname recieved")
+		}
+
+		return hostname, nil
+	case UnixSocket:
+		path, err := unmarshalString(conn)
+
+		if err != nil {
+			return "", fmt.Errorf("couldn't read Unix socket path")
+		}
+
+		if !IsUnixSocketPath(path) {
+			return "", fmt.Errorf("unix socket path is not absolute")
+		}
+
+		return path, nil
+	case '\n':
+		return "", fmt.Errorf("no data found")
+	default:
+		return "", fmt.Errorf("invalid address type recieved")
+	}
+
+	ip := make(net.IP, ipSize)
+
+	if _, err := io.ReadFull(conn, ip); err != nil {
+		return "", fmt.Errorf("couldn't read IP address")
+	}
+
+	return ip.String(), nil
+}
+
+func unmarshalString(conn io.Reader) (string, error) {
+	stringLengthBytes := make([]byte, 2)
+
+	if _, err := io.ReadFull(conn, stringLengthBytes); err != nil {
+		return "", fmt.Errorf("couldn't read string length")
+	}
+
+	stringLength := binary.BigEndian.Uint16(stringLengthBytes)
+
+	if stringLength == 0 {
+		return "", nil
+	}
+
+	stringBytes := make([]byte, stringLength)
+
+	if _, err := io.ReadFull(conn, stringBytes); err != nil {
+		return "", fmt.Errorf("couldn't read string")
+	}
+
+	return string(stringBytes), nil
+}
+
+// Unmarshals a command, discarding its request ID
 func Unmarshal(conn io.Reader) (string, interface{}, error) {
+	_, commandType, command, err := UnmarshalWithRequestID(conn)
+	return commandType, command, err
+}
+
+// Unmarshals a command along with the request ID it was tagged with. Frames carrying a command ID we don't know
+// about are skipped, so that newer peers can send us messages without desyncing the stream.
+func UnmarshalWithRequestID(conn io.Reader) (uint32, string, interface{}, error) {
+	requestID, _, commandType, command, err := UnmarshalWithDeadline(conn)
+	return requestID, commandType, command, err
+}
+
+// Same as UnmarshalWithRequestID, but also returns the deadline the command has to be handled by. The deadline is zero
+// if the sender didn't set one.
+func UnmarshalWithDeadline(conn io.Reader) (uint32, time.Time, string, interface{}, error) {
+	for {
+		frameVersion, frame, err := readFrame(conn)
+
+		if err != nil {
+			return 0, time.Time{}, "", nil, err
+		}
+
+		headerSize := 4
+
+		if frameVersion == FrameVersionWithDeadline {
+			headerSize += 4
+		}
+
+		if len(frame) < headerSize {
+			return 0, time.Time{}, "", nil, fmt.Errorf("frame is too small to contain its header")
+		}
+
+		requestID := binary.BigEndian.Uint32(frame[0:4])
+		var deadline time.Time
+
+		if frameVersion == FrameVersionWithDeadline {
+			if remainingTime := binary.BigEndian.Uint32(frame[4:8]); remainingTime != 0 {
+				deadline = time.Now().Add(time.Duration(remainingTime) * time.Millisecond)
+			}
+		}
+
+		commandType, command, err := unmarshalMessage(bytes.NewReader(frame[headerSize:]))
+
+		if err == errUnknownCommand {
+			continue
+		}
+
+		return requestID, deadline, commandType, command, err
+	}
+}
+
+// Reads a single frame from the connection, returning its version and its payload (the request ID, the deadline if
+// there is one, and the message)
+func readFrame(conn io.Reader) (uint8, []byte, error) {
+	header := make([]byte, FrameHeaderSize)
+
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return 0, nil, fmt.Errorf("couldn't read frame header: %s", err.Error())
+	}
+
+	if header[0] != FrameVersion && header[0] != FrameVersionWithDeadline {
+		return 0, nil, fmt.Errorf("unsupported frame version %d", header[0])
+	}
+
+	frameSize := binary.BigEndian.Uint32(header[1:5])
+
+	if frameSize > MaxFrameSize {
+		return 0, nil, fmt.Errorf("frame size %d exceeds maximum of %d", frameSize, MaxFrameSize)
+	}
+
+	frame := make([]byte, frameSize)
+
+	if _, err := io.ReadFull(conn, frame); err != nil {
+		return 0, nil, fmt.Errorf("couldn't read frame: %s", err.Error())
+	}
+
+	return header[0], frame, nil
+}
+
+// Reads a batch of messages written by marshalBatch. Every message has to have the command ID itemCommandID.
+func unmarshalBatch(conn io.Reader, itemCommandID uint8) ([]interface{}, error) {
+	itemCount := make([]byte, 4)
+
+	if _, err := io.ReadFull(conn, itemCount); err != nil {
+		return nil, fmt.Errorf("couldn't read batch item count")
+	}
+
+	// We don't preallocate here, so that a bogus count can't make us allocate a huge slice. It'll just run out of data instead
+	items := []interface{}{}
+
+	for range binary.BigEndian.Uint32(itemCount) {
+		itemID := make([]byte, 1)
+
+		if _, err := io.ReadFull(conn, itemID); err != nil {
+			return nil, fmt.Errorf("couldn't read batch item command")
+		}
+
+		if itemID[0] != itemCommandID {
+			return nil, fmt.Errorf("batch item has the wrong command type")
+		}
+
+		_, item, err := unmarshalMessage(io.MultiReader(bytes.NewReader(itemID), conn))
+
+		if err != nil {
+			return nil, fmt.Errorf("couldn't read batch item: %s", err.Error())
+		}
+
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+func unmarshalMessage(conn io.Reader) (string, interface{}, error) {
 	commandType := make([]byte, 1)
 
-	if _, err := conn.Read(commandType); err != nil {
+	if _, err := io.ReadFull(conn, commandType); err != nil {
 		return "", nil, fmt.Errorf("couldn't read command")
 	}
 
 	switch commandType[0] {
 	case StartID:
-		argumentsLength := make([]byte, 2)
+		argumentsLength := make([]byte, 4)
 
-		if _, err := conn.Read(argumentsLength); err != nil {
+		if _, err := io.ReadFull(conn, argumentsLength); err != nil {
 			return "", nil, fmt.Errorf("couldn't read argument length")
 		}
 
-		arguments := make([]byte, binary.BigEndian.Uint16(argumentsLength))
+		if binary.BigEndian.Uint32(argumentsLength) > MaxFrameSize {
+			return "", nil, fmt.Errorf("argument length exceeds maximum frame size")
+		}
+
+		arguments := make([]byte, binary.BigEndian.Uint32(argumentsLength))
 
-		if _, err := conn.Read(arguments); err != nil {
+		if _, err := io.ReadFull(conn, arguments); err != nil {
 			return "", nil, fmt.Errorf("couldn't read arguments")
 		}
 
@@ -172,43 +346,27 @@ func Unmarshal(conn io.Reader) (string, interface{}, error) {
 			Type: "stop",
 		}, nil
 	case AddProxyID:
-		ipVersion := make([]byte, 1)
-
-		if _, err := conn.Read(ipVersion); err != nil {
-			return "", nil, fmt.Errorf("couldn't read ip version")
-		}
-
-		var ipSize uint8
-
-		if ipVersion[0] == 4 {
-			ipSize = IPv4Size
-		} else if ipVersion[0] == 6 {
-			ipSize = IPv6Size
-		} else {
-			return "", nil, fmt.Errorf("invalid IP version recieved")
-		}
-
-		ip := make(net.IP, ipSize)
+		sourceIP, err := unmarshalAddress(conn)
 
-		if _, err := conn.Read(ip); err != nil {
-			return "", nil, fmt.Errorf("couldn't read source IP")
+		if err != nil {
+			return "", nil, fmt.Errorf("couldn't read source address: %s", err.Error())
 		}
 
 		sourcePort := make([]byte, 2)
 
-		if _, err := conn.Read(sourcePort); err != nil {
+		if _, err := io.ReadFull(conn, sourcePort); err != nil {
 			return "", nil, fmt.Errorf("couldn't read source port")
 		}
 
 		destPort := make([]byte, 2)
 
-		if _, err := conn.Read(destPort); err != nil {
+		if _, err := io.ReadFull(conn, destPort); err != nil {
 			return "", nil, fmt.Errorf("couldn't read destination port")
 		}
 
 		protocolBytes := make([]byte, 1)
 
-		if _, err := conn.Read(protocolBytes); err != nil {
+		if _, err := io.ReadFull(conn, protocolBytes); err != nil {
 			return "", nil, fmt.Errorf("couldn't read protocol")
 		}
 
@@ -216,7 +374,7 @@ func Unmarshal(conn io.Reader) (string, interface{}, error) {
 
 		if protocolBytes[0] == TCP {
 			protocol = "tcp"
-		} else if protocolBytes[1] == UDP {
+		} else if protocolBytes[0] == UDP {
 			protocol = "udp"
 		} else {
 			return "", nil, fmt.Errorf("invalid protocol")
@@ -224,49 +382,33 @@ func Unmarshal(conn io.Reader) (string, interface{}, error) {
 
 		return "addProxy", &AddProxy{
 			Type:       "addProxy",
-			SourceIP:   ip.String(),
+			SourceIP:   sourceIP,
 			SourcePort: binary.BigEndian.Uint16(sourcePort),
 			DestPort:   binary.BigEndian.Uint16(destPort),
 			Protocol:   protocol,
 		}, nil
 	case RemoveProxyID:
-		ipVersion := make([]byte, 1)
+		sourceIP, err := unmarshalAddress(conn)
 
-		if _, err := conn.Read(ipVersion); err != nil {
-			return "", nil, fmt.Errorf("couldn't read ip version")
-		}
-
-		var ipSize uint8
-
-		if ipVersion[0] == 4 {
-			ipSize = IPv4Size
-		} else if ipVersion[0] == 6 {
-			ipSize = IPv6Size
-		} else {
-			return "", nil, fmt.Errorf("invalid IP version recieved")
-		}
-
-		ip := make(net.IP, ipSize)
-
-		if _, err := conn.Read(ip); err != nil {
-			return "", nil, fmt.Errorf("couldn't read source IP")
+		if err != nil {
+			return "", nil, fmt.Errorf("couldn't read source address: %s", err.Error())
 		}
 
 		sourcePort := make([]byte, 2)
 
-		if _, err := conn.Read(sourcePort); err != nil {
+		if _, err := io.ReadFull(conn, sourcePort); err != nil {
 			return "", nil, fmt.Errorf("couldn't read source port")
 		}
 
 		destPort := make([]byte, 2)
 
-		if _, err := conn.Read(destPort); err != nil {
+		if _, err := io.ReadFull(conn, destPort); err != nil {
 			return "", nil, fmt.Errorf("couldn't read destination port")
 		}
 
 		protocolBytes := make([]byte, 1)
 
-		if _, err := conn.Read(protocolBytes); err != nil {
+		if _, err := io.ReadFull(conn, protocolBytes); err != nil {
 			return "", nil, fmt.Errorf("couldn't read protocol")
 		}
 
@@ -274,18 +416,25 @@ func Unmarshal(conn io.Reader) (string, interface{}, error) {
 
 		if protocolBytes[0] == TCP {
 			protocol = "tcp"
-		} else if protocolBytes[1] == UDP {
+		} else if protocolBytes[0] == UDP {
 			protocol = "udp"
 		} else {
 			return "", nil, fmt.Errorf("invalid protocol")
 		}
 
+		drainTimeout := make([]byte, 4)
+
+		if _, err := io.ReadFull(conn, drainTimeout); err != nil {
+			return "", nil, fmt.Errorf("couldn't read drain timeout")
+		}
+
 		return "removeProxy", &RemoveProxy{
-			Type:       "removeProxy",
-			SourceIP:   ip.String(),
-			SourcePort: binary.BigEndian.Uint16(sourcePort),
-			DestPort:   binary.BigEndian.Uint16(destPort),
-			Protocol:   protocol,
+			Type:         "removeProxy",
+			SourceIP:     sourceIP,
+			SourcePort:   binary.BigEndian.Uint16(sourcePort),
+			DestPort:     binary.BigEndian.Uint16(destPort),
+			Protocol:     protocol,
+			DrainTimeout: time.Duration(binary.BigEndian.Uint32(drainTimeout)) * time.Millisecond,
 		}, nil
 	case ProxyConnectionsResponseID:
 		connections := []*ProxyClientConnection{}
@@ -306,7 +455,7 @@ func Unmarshal(conn io.Reader) (string, interface{}, error) {
 
 			connections = append(connections, connection)
 
-			if _, err := conn.Read(delimiter); err != nil {
+			if _, err := io.ReadFull(conn, delimiter); err != nil {
 				return "", nil, fmt.Errorf("couldn't read delimiter")
 			}
 
@@ -326,43 +475,27 @@ func Unmarshal(conn io.Reader) (string, interface{}, error) {
 			Connections: connections,
 		}, errorReturn
 	case CheckClientParametersID:
-		ipVersion := make([]byte, 1)
+		sourceIP, err := unmarshalAddress(conn)
 
-		if _, err := conn.Read(ipVersion); err != nil {
-			return "", nil, fmt.Errorf("couldn't read ip version")
-		}
-
-		var ipSize uint8
-
-		if ipVersion[0] == 4 {
-			ipSize = IPv4Size
-		} else if ipVersion[0] == 6 {
-			ipSize = IPv6Size
-		} else {
-			return "", nil, fmt.Errorf("invalid IP version recieved")
-		}
-
-		ip := make(net.IP, ipSize)
-
-		if _, err := conn.Read(ip); err != nil {
-			return "", nil, fmt.Errorf("couldn't read source IP")
+		if err != nil {
+			return "", nil, fmt.Errorf("couldn't read source address: %s", err.Error())
 		}
 
 		sourcePort := make([]byte, 2)
 
-		if _, err := conn.Read(sourcePort); err != nil {
+		if _, err := io.ReadFull(conn, sourcePort); err != nil {
 			return "", nil, fmt.Errorf("couldn't read source port")
 		}
 
 		destPort := make([]byte, 2)
 
-		if _, err := conn.Read(destPort); err != nil {
+		if _, err := io.ReadFull(conn, destPort); err != nil {
 			return "", nil, fmt.Errorf("couldn't read destination port")
 		}
 
 		protocolBytes := make([]byte, 1)
 
-		if _, err := conn.Read(protocolBytes); err != nil {
+		if _, err := io.ReadFull(conn, protocolBytes); err != nil {
 			return "", nil, fmt.Errorf("couldn't read protocol")
 		}
 
@@ -370,7 +503,7 @@ func Unmarshal(conn io.Reader) (string, interface{}, error) {
 
 		if protocolBytes[0] == TCP {
 			protocol = "tcp"
-		} else if protocolBytes[1] == UDP {
+		} else if protocolBytes[0] == UDP {
 			protocol = "udp"
 		} else {
 			return "", nil, fmt.Errorf("invalid protocol")
@@ -378,21 +511,25 @@ func Unmarshal(conn io.Reader) (string, interface{}, error) {
 
 		return "checkClientParameters", &CheckClientParameters{
 			Type:       "checkClientParameters",
-			SourceIP:   ip.String(),
+			SourceIP:   sourceIP,
 			SourcePort: binary.BigEndian.Uint16(sourcePort),
 			DestPort:   binary.BigEndian.Uint16(destPort),
 			Protocol:   protocol,
 		}, nil
 	case CheckServerParametersID:
-		argumentsLength := make([]byte, 2)
+		argumentsLength := make([]byte, 4)
 
-		if _, err := conn.Read(argumentsLength); err != nil {
+		if _, err := io.ReadFull(conn, argumentsLength); err != nil {
 			return "", nil, fmt.Errorf("couldn't read argument length")
 		}
 
-		arguments := make([]byte, binary.BigEndian.Uint16(argumentsLength))
+		if binary.BigEndian.Uint32(argumentsLength) > MaxFrameSize {
+			return "", nil, fmt.Errorf("argument length exceeds maximum frame size")
+		}
 
-		if _, err := conn.Read(arguments); err != nil {
+		arguments := make([]byte, binary.BigEndian.Uint32(argumentsLength))
+
+		if _, err := io.ReadFull(conn, arguments); err != nil {
 			return "", nil, fmt.Errorf("couldn't read arguments")
 		}
 
@@ -403,7 +540,7 @@ func Unmarshal(conn io.Reader) (string, interface{}, error) {
 	case CheckParametersResponseID:
 		checkMethodByte := make([]byte, 1)
 
-		if _, err := conn.Read(checkMethodByte); err != nil {
+		if _, err := io.ReadFull(conn, checkMethodByte); err != nil {
 			return "", nil, fmt.Errorf("couldn't read check method byte")
 		}
 
@@ -419,13 +556,13 @@ func Unmarshal(conn io.Reader) (string, interface{}, error) {
 
 		isValid := make([]byte, 1)
 
-		if _, err := conn.Read(isValid); err != nil {
+		if _, err := io.ReadFull(conn, isValid); err != nil {
 			return "", nil, fmt.Errorf("couldn't read isValid byte")
 		}
 
 		messageLengthBytes := make([]byte, 2)
 
-		if _, err := conn.Read(messageLengthBytes); err != nil {
+		if _, err := io.ReadFull(conn, messageLengthBytes); err != nil {
 			return "", nil, fmt.Errorf("couldn't read message length")
 		}
 
@@ -435,7 +572,7 @@ func Unmarshal(conn io.Reader) (string, interface{}, error) {
 		if messageLength != 0 {
 			messageBytes := make([]byte, messageLength)
 
-			if _, err := conn.Read(messageBytes); err != nil {
+			if _, err := io.ReadFull(conn, messageBytes); err != nil {
 				return "", nil, fmt.Errorf("couldn't read message")
 			}
 
@@ -451,19 +588,19 @@ func Unmarshal(conn io.Reader) (string, interface{}, error) {
 	case BackendStatusResponseID:
 		isRunning := make([]byte, 1)
 
-		if _, err := conn.Read(isRunning); err != nil {
+		if _, err := io.ReadFull(conn, isRunning); err != nil {
 			return "", nil, fmt.Errorf("couldn't read isRunning field")
 		}
 
 		statusCode := make([]byte, 1)
 
-		if _, err := conn.Read(statusCode); err != nil {
+		if _, err := io.ReadFull(conn, statusCode); err != nil {
 			return "", nil, fmt.Errorf("couldn't read status code field")
 		}
 
 		messageLengthBytes := make([]byte, 2)
 
-		if _, err := conn.Read(messageLengthBytes); err != nil {
+		if _, err := io.ReadFull(conn, messageLengthBytes); err != nil {
 			return "", nil, fmt.Errorf("couldn't read message length")
 		}
 
@@ -473,7 +610,7 @@ func Unmarshal(conn io.Reader) (string, interface{}, error) {
 		if messageLength != 0 {
 			messageBytes := make([]byte, messageLength)
 
-			if _, err := conn.Read(messageBytes); err != nil {
+			if _, err := io.ReadFull(conn, messageBytes); err != nil {
 				return "", nil, fmt.Errorf("couldn't read message")
 			}
 
@@ -491,43 +628,27 @@ func Unmarshal(conn io.Reader) (string, interface{}, error) {
 			Type: "backendStatusRequest",
 		}, nil
 	case ProxyStatusRequestID:
-		ipVersion := make([]byte, 1)
-
-		if _, err := conn.Read(ipVersion); err != nil {
-			return "", nil, fmt.Errorf("couldn't read ip version")
-		}
-
-		var ipSize uint8
+		sourceIP, err := unmarshalAddress(conn)
 
-		if ipVersion[0] == 4 {
-			ipSize = IPv4Size
-		} else if ipVersion[0] == 6 {
-			ipSize = IPv6Size
-		} else {
-			return "", nil, fmt.Errorf("invalid IP version recieved")
-		}
-
-		ip := make(net.IP, ipSize)
-
-		if _, err := conn.Read(ip); err != nil {
-			return "", nil, fmt.Errorf("couldn't read source IP")
+		if err != nil {
+			return "", nil, fmt.Errorf("couldn't read source address: %s", err.Error())
 		}
 
 		sourcePort := make([]byte, 2)
 
-		if _, err := conn.Read(sourcePort); err != nil {
+		if _, err := io.ReadFull(conn, sourcePort); err != nil {
 			return "", nil, fmt.Errorf("couldn't read source port")
 		}
 
 		destPort := make([]byte, 2)
 
-		if _, err := conn.Read(destPort); err != nil {
+		if _, err := io.ReadFull(conn, destPort); err != nil {
 			return "", nil, fmt.Errorf("couldn't read destination port")
 		}
 
 		protocolBytes := make([]byte, 1)
 
-		if _, err := conn.Read(protocolBytes); err != nil {
+		if _, err := io.ReadFull(conn, protocolBytes); err != nil {
 			return "", nil, fmt.Errorf("couldn't read protocol")
 		}
 
@@ -535,7 +656,7 @@ func Unmarshal(conn io.Reader) (string, interface{}, error) {
 
 		if protocolBytes[0] == TCP {
 			protocol = "tcp"
-		} else if protocolBytes[1] == UDP {
+		} else if protocolBytes[0] == UDP {
 			protocol = "udp"
 		} else {
 			return "", nil, fmt.Errorf("invalid protocol")
@@ -543,49 +664,33 @@ func Unmarshal(conn io.Reader) (string, interface{}, error) {
 
 		return "proxyStatusRequest", &ProxyStatusRequest{
 			Type:       "proxyStatusRequest",
-			SourceIP:   ip.String(),
+			SourceIP:   sourceIP,
 			SourcePort: binary.BigEndian.Uint16(sourcePort),
 			DestPort:   binary.BigEndian.Uint16(destPort),
 			Protocol:   protocol,
 		}, nil
 	case ProxyStatusResponseID:
-		ipVersion := make([]byte, 1)
-
-		if _, err := conn.Read(ipVersion); err != nil {
-			return "", nil, fmt.Errorf("couldn't read ip version")
-		}
+		sourceIP, err := unmarshalAddress(conn)
 
-		var ipSize uint8
-
-		if ipVersion[0] == 4 {
-			ipSize = IPv4Size
-		} else if ipVersion[0] == 6 {
-			ipSize = IPv6Size
-		} else {
-			return "", nil, fmt.Errorf("invalid IP version recieved")
-		}
-
-		ip := make(net.IP, ipSize)
-
-		if _, err := conn.Read(ip); err != nil {
-			return "", nil, fmt.Errorf("couldn't read source IP")
+		if err != nil {
+			return "", nil, fmt.Errorf("couldn't read source address: %s", err.Error())
 		}
 
 		sourcePort := make([]byte, 2)
 
-		if _, err := conn.Read(sourcePort); err != nil {
+		if _, err := io.ReadFull(conn, sourcePort); err != nil {
 			return "", nil, fmt.Errorf("couldn't read source port")
 		}
 
 		destPort := make([]byte, 2)
 
-		if _, err := conn.Read(destPort); err != nil {
+		if _, err := io.ReadFull(conn, destPort); err != nil {
 			return "", nil, fmt.Errorf("couldn't read destination port")
 		}
 
 		protocolBytes := make([]byte, 1)
 
-		if _, err := conn.Read(protocolBytes); err != nil {
+		if _, err := io.ReadFull(conn, protocolBytes); err != nil {
 			return "", nil, fmt.Errorf("couldn't read protocol")
 		}
 
@@ -593,7 +698,7 @@ func Unmarshal(conn io.Reader) (string, interface{}, error) {
 
 		if protocolBytes[0] == TCP {
 			protocol = "tcp"
-		} else if protocolBytes[1] == UDP {
+		} else if protocolBytes[0] == UDP {
 			protocol = "udp"
 		} else {
 			return "", nil, fmt.Errorf("invalid protocol")
@@ -601,17 +706,31 @@ func Unmarshal(conn io.Reader) (string, interface{}, error) {
 
 		isActive := make([]byte, 1)
 
-		if _, err := conn.Read(isActive); err != nil {
+		if _, err := io.ReadFull(conn, isActive); err != nil {
 			return "", nil, fmt.Errorf("couldn't read isActive field")
 		}
 
+		remainingConnections := make([]byte, 4)
+
+		if _, err := io.ReadFull(conn, remainingConnections); err != nil {
+			return "", nil, fmt.Errorf("couldn't read remaining connections")
+		}
+
+		message, err := unmarshalString(conn)
+
+		if err != nil {
+			return "", nil, fmt.Errorf("couldn't read message: %s", err.Error())
+		}
+
 		return "proxyStatusResponse", &ProxyStatusResponse{
-			Type:       "proxyStatusResponse",
-			SourceIP:   ip.String(),
-			SourcePort: binary.BigEndian.Uint16(sourcePort),
-			DestPort:   binary.BigEndian.Uint16(destPort),
-			Protocol:   protocol,
-			IsActive:   isActive[0] == 1,
+			Type:                 "proxyStatusResponse",
+			SourceIP:             sourceIP,
+			SourcePort:           binary.BigEndian.Uint16(sourcePort),
+			DestPort:             binary.BigEndian.Uint16(destPort),
+			Protocol:             protocol,
+			IsActive:             isActive[0] == 1,
+			RemainingConnections: binary.BigEndian.Uint32(remainingConnections),
+			Message:              message,
 		}, nil
 	case ProxyInstanceRequestID:
 		return "proxyInstanceRequest", &ProxyInstanceRequest{
@@ -636,7 +755,7 @@ func Unmarshal(conn io.Reader) (string, interface{}, error) {
 
 			proxies = append(proxies, proxy)
 
-			if _, err := conn.Read(delimiter); err != nil {
+			if _, err := io.ReadFull(conn, delimiter); err != nil {
 				return "", nil, fmt.Errorf("couldn't read delimiter")
 			}
 
@@ -659,7 +778,303 @@ func Unmarshal(conn io.Reader) (string, interface{}, error) {
 		return "proxyConnectionsRequest", &ProxyConnectionsRequest{
 			Type: "proxyConnectionsRequest",
 		}, nil
+	case HelloID:
+		protocolVersion := make([]byte, 2)
+
+		if _, err := io.ReadFull(conn, protocolVersion); err != nil {
+			return "", nil, fmt.Errorf("couldn't read protocol version")
+		}
+
+		return "hello", &Hello{
+			Type:            "hello",
+			ProtocolVersion: binary.BigEndian.Uint16(protocolVersion),
+		}, nil
+	case HelloResponseID:
+		protocolVersion := make([]byte, 2)
+
+		if _, err := io.ReadFull(conn, protocolVersion); err != nil {
+			return "", nil, fmt.Errorf("couldn't read protocol version")
+		}
+
+		backendName, err := unmarshalString(conn)
+
+		if err != nil {
+			return "", nil, fmt.Errorf("couldn't read backend name: %s", err.Error())
+		}
+
+		backendVersion, err := unmarshalString(conn)
+
+		if err != nil {
+			return "", nil, fmt.Errorf("couldn't read backend version: %s", err.Error())
+		}
+
+		capabilityCount := make([]byte, 1)
+
+		if _, err := io.ReadFull(conn, capabilityCount); err != nil {
+			return "", nil, fmt.Errorf("couldn't read capability count")
+		}
+
+		capabilities := make([]string, capabilityCount[0])
+
+		for capabilityIndex := range capabilities {
+			capabilities[capabilityIndex], err = unmarshalString(conn)
+
+			if err != nil {
+				return "", nil, fmt.Errorf("couldn't read capability: %s", err.Error())
+			}
+		}
+
+		return "helloResponse", &HelloResponse{
+			Type:            "helloResponse",
+			ProtocolVersion: binary.BigEndian.Uint16(protocolVersion),
+			BackendName:     backendName,
+			BackendVersion:  backendVersion,
+			Capabilities:    capabilities,
+		}, nil
+	case ConnectionOpenedID:
+		connection, err := unmarshalIndividualConnectionStruct(conn)
+
+		if err != nil {
+			return "", nil, err
+		}
+
+		return "connectionOpened", &ConnectionOpened{
+			Type:       "connectionOpened",
+			Connection: connection,
+		}, nil
+	case ConnectionClosedID:
+		connection, err := unmarshalIndividualConnectionStruct(conn)
+
+		if err != nil {
+			return "", nil, err
+		}
+
+		return "connectionClosed", &ConnectionClosed{
+			Type:       "connectionClosed",
+			Connection: connection,
+		}, nil
+	case ProxyFailedID:
+		proxy, err := unmarshalIndividualProxyStruct(conn)
+
+		if err != nil {
+			return "", nil, err
+		}
+
+		message, err := unmarshalString(conn)
+
+		if err != nil {
+			return "", nil, fmt.Errorf("couldn't read message: %s", err.Error())
+		}
+
+		return "proxyFailed", &ProxyFailed{
+			Type:       "proxyFailed",
+			SourceIP:   proxy.SourceIP,
+			SourcePort: proxy.SourcePort,
+			DestPort:   proxy.DestPort,
+			Protocol:   proxy.Protocol,
+			Message:    message,
+		}, nil
+	case UpstreamDisconnectedID:
+		message, err := unmarshalString(conn)
+
+		if err != nil {
+			return "", nil, fmt.Errorf("couldn't read message: %s", err.Error())
+		}
+
+		return "upstreamDisconnected", &UpstreamDisconnected{
+			Type:    "upstreamDisconnected",
+			Message: message,
+		}, nil
+	case KillConnectionID:
+		connection, err := unmarshalIndividualConnectionStruct(conn)
+
+		if err != nil {
+			return "", nil, err
+		}
+
+		return "killConnection", &KillConnection{
+			Type:       "killConnection",
+			SourceIP:   connection.SourceIP,
+			SourcePort: connection.SourcePort,
+			DestPort:   connection.DestPort,
+			ClientIP:   connection.ClientIP,
+			ClientPort: connection.ClientPort,
+			Protocol:   connection.Protocol,
+		}, nil
+	case KillConnectionResponseID:
+		isKilled := make([]byte, 1)
+
+		if _, err := io.ReadFull(conn, isKilled); err != nil {
+			return "", nil, fmt.Errorf("couldn't read isKilled byte")
+		}
+
+		message, err := unmarshalString(conn)
+
+		if err != nil {
+			return "", nil, fmt.Errorf("couldn't read message: %s", err.Error())
+		}
+
+		return "killConnectionResponse", &KillConnectionResponse{
+			Type:     "killConnectionResponse",
+			IsKilled: isKilled[0] == 1,
+			Message:  message,
+		}, nil
+	case ParametersSchemaRequestID:
+		return "parametersSchemaRequest", &ParametersSchemaRequest{
+			Type: "parametersSchemaRequest",
+		}, nil
+	case ParametersSchemaResponseID:
+		schemaLength := make([]byte, 4)
+
+		if _, err := io.ReadFull(conn, schemaLength); err != nil {
+			return "", nil, fmt.Errorf("couldn't read schema length")
+		}
+
+		if binary.BigEndian.Uint32(schemaLength) > MaxFrameSize {
+			return "", nil, fmt.Errorf("schema length exceeds maximum frame size")
+		}
+
+		schema := make([]byte, binary.BigEndian.Uint32(schemaLength))
+
+		if _, err := io.ReadFull(conn, schema); err != nil {
+			return "", nil, fmt.Errorf("couldn't read schema")
+		}
+
+		return "parametersSchemaResponse", &ParametersSchemaResponse{
+			Type:   "parametersSchemaResponse",
+			Schema: schema,
+		}, nil
+	case AddProxiesID:
+		items, err := unmarshalBatch(conn, AddProxyID)
+
+		if err != nil {
+			return "", nil, err
+		}
+
+		proxies := make([]*AddProxy, len(items))
+
+		for itemIndex, item := range items {
+			proxies[itemIndex] = item.(*AddProxy)
+		}
+
+		return "addProxies", &AddProxies{
+			Type:    "addProxies",
+			Proxies: proxies,
+		}, nil
+	case RemoveProxiesID:
+		items, err := unmarshalBatch(conn, RemoveProxyID)
+
+		if err != nil {
+			return "", nil, err
+		}
+
+		proxies := make([]*RemoveProxy, len(items))
+
+		for itemIndex, item := range items {
+			proxies[itemIndex] = item.(*RemoveProxy)
+		}
+
+		return "removeProxies", &RemoveProxies{
+			Type:    "removeProxies",
+			Proxies: proxies,
+		}, nil
+	case ProxiesStatusResponseID:
+		items, err := unmarshalBatch(conn, ProxyStatusResponseID)
+
+		if err != nil {
+			return "", nil, err
+		}
+
+		results := make([]*ProxyStatusResponse, len(items))
+
+		for itemIndex, item := range items {
+			results[itemIndex] = item.(*ProxyStatusResponse)
+		}
+
+		return "proxiesStatusResponse", &ProxiesStatusResponse{
+			Type:    "proxiesStatusResponse",
+			Results: results,
+		}, nil
+	case PingID, PongID:
+		nonce := make([]byte, 8)
+
+		if _, err := io.ReadFull(conn, nonce); err != nil {
+			return "", nil, fmt.Errorf("couldn't read nonce")
+		}
+
+		timestamp := make([]byte, 8)
+
+		if _, err := io.ReadFull(conn, timestamp); err != nil {
+			return "", nil, fmt.Errorf("couldn't read timestamp")
+		}
+
+		if commandType[0] == PingID {
+			return "ping", &Ping{
+				Type:      "ping",
+				Nonce:     binary.BigEndian.Uint64(nonce),
+				Timestamp: time.Unix(0, int64(binary.BigEndian.Uint64(timestamp))),
+			}, nil
+		}
+
+		return "pong", &Pong{
+			Type:      "pong",
+			Nonce:     binary.BigEndian.Uint64(nonce),
+			Timestamp: time.Unix(0, int64(binary.BigEndian.Uint64(timestamp))),
+		}, nil
+	case LogMessageID:
+		timestamp := make([]byte, 8)
+
+		if _, err := io.ReadFull(conn, timestamp); err != nil {
+			return "", nil, fmt.Errorf("couldn't read timestamp")
+		}
+
+		level, err := unmarshalString(conn)
+
+		if err != nil {
+			return "", nil, fmt.Errorf("couldn't read level: %s", err.Error())
+		}
+
+		message, err := unmarshalString(conn)
+
+		if err != nil {
+			return "", nil, fmt.Errorf("couldn't read message: %s", err.Error())
+		}
+
+		fieldCount := make([]byte, 2)
+
+		if _, err := io.ReadFull(conn, fieldCount); err != nil {
+			return "", nil, fmt.Errorf("couldn't read field count")
+		}
+
+		fields := make([]*LogField, binary.BigEndian.Uint16(fieldCount))
+
+		for fieldIndex := range fields {
+			key, err := unmarshalString(conn)
+
+			if err != nil {
+				return "", nil, fmt.Errorf("couldn't read field key: %s", err.Error())
+			}
+
+			value, err := unmarshalString(conn)
+
+			if err != nil {
+				return "", nil, fmt.Errorf("couldn't read field value: %s", err.Error())
+			}
+
+			fields[fieldIndex] = &LogField{
+				Key:   key,
+				Value: value,
+			}
+		}
+
+		return "logMessage", &LogMessage{
+			Type:    "logMessage",
+			Time:    time.Unix(0, int64(binary.BigEndian.Uint64(timestamp))),
+			Level:   level,
+			Message: message,
+			Fields:  fields,
+		}, nil
 	}
 
-	return "", nil, fmt.Errorf("couldn't match command ID")
+	return "", nil, errUnknownCommand
 }
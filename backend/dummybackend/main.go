@@ -53,6 +53,11 @@ func (backend *DummyBackend) CheckParametersForBackend(arguments []byte) *common
 	}
 }
 
+func (backend *DummyBackend) GetParametersSchema() []byte {
+	// This is optional. It lets the API (and its users) know what parameters we take, as a JSON Schema.
+	return []byte(`{"type": "object"}`)
+}
+
 func main() {
 	// When using logging, you should use charmbracelet/log, because that's what everything else uses in this ecosystem of a project. - imterah
 	logLevel := os.Getenv("HERMES_LOG_LEVEL")
@@ -79,6 +84,10 @@ func main() {
 	backend := &DummyBackend{}
 
 	application := backendutil.NewHelper(backend)
+	application.Name = "dummy"
+	application.Capabilities = []string{commonbackend.CapabilityTCP, commonbackend.CapabilityUDP}
+	application.ForwardLogs()
+
 	err := application.Start()
 
 	if err != nil {
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"git.terah.dev/imterah/hermes/backend/backendutil/testkit"
+	"git.terah.dev/imterah/hermes/backend/commonbackend"
+)
+
+func TestDummyBackend(t *testing.T) {
+	client := testkit.New(t, &DummyBackend{})
+
+	if !slices.Contains(client.Hello.Capabilities, commonbackend.CapabilityParametersSchema) {
+		t.Errorf("backend does not advertise the '%s' capability (got: %v)", commonbackend.CapabilityParametersSchema, client.Hello.Capabilities)
+	}
+
+	startResponse, err := client.Start([]byte("{}"))
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if !startResponse.IsRunning {
+		t.Errorf("backend did not start: %s", startResponse.Message)
+	}
+
+	statusResponse, err := client.Status()
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if !statusResponse.IsRunning {
+		t.Errorf("backend is not running after starting")
+	}
+
+	source := testkit.StartTCPEchoServer(t)
+
+	proxyStatus, err := client.AddProxy(&commonbackend.AddProxy{
+		SourceIP:   source.IP,
+		SourcePort: source.Port,
+		DestPort:   testkit.FreePort(t, "tcp"),
+		Protocol:   "tcp",
+	})
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if !proxyStatus.IsActive {
+		t.Errorf("proxy is not active: %s", proxyStatus.Message)
+	}
+
+	connections, err := client.Connections()
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if len(connections) != 0 {
+		t.Errorf("dummy backend reported %d connections", len(connections))
+	}
+
+	proxyStatus, err = client.RemoveProxy(&commonbackend.RemoveProxy{
+		SourceIP:   source.IP,
+		SourcePort: source.Port,
+		DestPort:   proxyStatus.DestPort,
+		Protocol:   "tcp",
+	})
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if proxyStatus.IsActive {
+		t.Errorf("proxy is still active after removing it: %s", proxyStatus.Message)
+	}
+}
+
+func TestDummyBackendSpeaksOlderAPIsVersion(t *testing.T) {
+	client := testkit.New(t, &DummyBackend{})
+
+	if client.Hello.ProtocolVersion != commonbackend.ProtocolVersion {
+		t.Fatalf("expected protocol version %d (got %d)", commonbackend.ProtocolVersion, client.Hello.ProtocolVersion)
+	}
+
+	response, err := client.Request("hello", &commonbackend.Hello{
+		Type:            "hello",
+		ProtocolVersion: commonbackend.ProtocolVersion - 1,
+	})
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	helloResponse, ok := response.(*commonbackend.HelloResponse)
+
+	if !ok {
+		t.Fatalf("got illegal response type to hello: %T", response)
+	}
+
+	if helloResponse.ProtocolVersion != commonbackend.ProtocolVersion-1 {
+		t.Fatalf("expected the backend to downgrade to protocol version %d (got %d)", commonbackend.ProtocolVersion-1, helloResponse.ProtocolVersion)
+	}
+}
@@ -0,0 +1,660 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"time"
+
+	"git.terah.dev/imterah/hermes/backend/backendlauncher"
+	"git.terah.dev/imterah/hermes/backend/commonbackend"
+	"github.com/urfave/cli/v2"
+)
+
+type conformanceResult int
+
+const (
+	conformancePass conformanceResult = iota
+	conformanceWarn
+	conformanceFail
+	conformanceSkip
+)
+
+func (result conformanceResult) String() string {
+	switch result {
+	case conformancePass:
+		return "PASS"
+	case conformanceWarn:
+		return "WARN"
+	case conformanceFail:
+		return "FAIL"
+	default:
+		return "SKIP"
+	}
+}
+
+type conformanceCheck struct {
+	Name    string
+	Result  conformanceResult
+	Message string
+}
+
+type conformanceRunner struct {
+	sock          net.Conn
+	timeout       time.Duration
+	lastRequestID uint32
+
+	checks       []*conformanceCheck
+	capabilities []string
+}
+
+func (runner *conformanceRunner) record(name string, result conformanceResult, format string, args ...interface{}) {
+	runner.checks = append(runner.checks, &conformanceCheck{
+		Name:    name,
+		Result:  result,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+func (runner *conformanceRunner) writeFrame(frame []byte) error {
+	if err := runner.sock.SetWriteDeadline(time.Now().Add(runner.timeout)); err != nil {
+		return err
+	}
+
+	_, err := runner.sock.Write(frame)
+	return err
+}
+
+// Sends a command to the backend, and waits for the response with the matching request ID
+func (runner *conformanceRunner) request(commandType string, command interface{}) (string, interface{}, error) {
+	runner.lastRequestID++
+	requestID := runner.lastRequestID
+
+	commandMarshalled, err := commonbackend.MarshalWithRequestID(requestID, commandType, command)
+
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to marshal command: %s", err.Error())
+	}
+
+	if err := runner.writeFrame(commandMarshalled); err != nil {
+		return "", nil, fmt.Errorf("failed to write command: %s", err.Error())
+	}
+
+	if err := runner.sock.SetReadDeadline(time.Now().Add(runner.timeout)); err != nil {
+		return "", nil, err
+	}
+
+	defer runner.sock.SetReadDeadline(time.Time{})
+
+	for {
+		responseRequestID, responseType, responseRaw, err := commonbackend.UnmarshalWithRequestID(runner.sock)
+
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to read response: %s", err.Error())
+		}
+
+		if responseRequestID == 0 && printBackendEvent(responseType, responseRaw) {
+			continue
+		}
+
+		if responseRequestID != requestID {
+			return "", nil, fmt.Errorf("got response for request ID #%d, expected #%d", responseRequestID, requestID)
+		}
+
+		return responseType, responseRaw, nil
+	}
+}
+
+// Sends a command, and type checks the response
+func requestAs[T any](runner *conformanceRunner, commandType string, command interface{}) (T, error) {
+	var empty T
+	responseType, responseRaw, err := runner.request(commandType, command)
+
+	if err != nil {
+		return empty, err
+	}
+
+	response, ok := responseRaw.(T)
+
+	if !ok {
+		return empty, fmt.Errorf("got unexpected response '%s' (%T)", responseType, responseRaw)
+	}
+
+	return response, nil
+}
+
+// Gets a port on the loopback interface that nothing is listening on (as far as we can tell)
+func getFreePort(ip string) (uint16, error) {
+	listener, err := net.Listen("tcp", net.JoinHostPort(ip, "0"))
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer listener.Close()
+
+	return uint16(listener.Addr().(*net.TCPAddr).Port), nil
+}
+
+// Dials the proxy's destination, and checks that data makes it through to the source listener and back.
+// Both ends of the connection are left open, so that the connection shows up in the backend.
+func checkTraffic(listener net.Listener, destAddress string, timeout time.Duration) (net.Conn, net.Conn, error) {
+	clientConn, err := net.DialTimeout("tcp", destAddress, timeout)
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to dial destination: %s", err.Error())
+	}
+
+	listener.(*net.TCPListener).SetDeadline(time.Now().Add(timeout))
+	sourceConn, err := listener.Accept()
+	listener.(*net.TCPListener).SetDeadline(time.Time{})
+
+	if err != nil {
+		clientConn.Close()
+		return nil, nil, fmt.Errorf("connection never made it to the source: %s", err.Error())
+	}
+
+	closeConns := func() {
+		clientConn.Close()
+		sourceConn.Close()
+	}
+
+	clientConn.SetDeadline(time.Now().Add(timeout))
+	sourceConn.SetDeadline(time.Now().Add(timeout))
+
+	payload := []byte("hermes conformance test")
+	readBuffer := make([]byte, len(payload))
+
+	if _, err := clientConn.Write(payload); err != nil {
+		closeConns()
+		return nil, nil, fmt.Errorf("failed to write to destination: %s", err.Error())
+	}
+
+	if _, err := io.ReadFull(sourceConn, readBuffer); err != nil || !bytes.Equal(readBuffer, payload) {
+		closeConns()
+		return nil, nil, fmt.Errorf("source didn't receive the data sent to the destination")
+	}
+
+	if _, err := sourceConn.Write(payload); err != nil {
+		closeConns()
+		return nil, nil, fmt.Errorf("failed to write to source: %s", err.Error())
+	}
+
+	if _, err := io.ReadFull(clientConn, readBuffer); err != nil || !bytes.Equal(readBuffer, payload) {
+		closeConns()
+		return nil, nil, fmt.Errorf("destination didn't receive the data sent by the source")
+	}
+
+	clientConn.SetDeadline(time.Time{})
+	sourceConn.SetDeadline(time.Time{})
+
+	return clientConn, sourceConn, nil
+}
+
+func (runner *conformanceRunner) run(backendParameters []byte, sourceIP, destHost string, shouldCheckTraffic bool) {
+	// Handshake & backend lifecycle
+
+	helloResponse, err := requestAs[*commonbackend.HelloResponse](runner, "hello", &commonbackend.Hello{
+		Type:            "hello",
+		ProtocolVersion: commonbackend.ProtocolVersion,
+	})
+
+	if err != nil {
+		runner.record("hello", conformanceFail, "%s", err.Error())
+		return
+	}
+
+	if helloResponse.ProtocolVersion < commonbackend.MinimumProtocolVersion {
+		runner.record("hello", conformanceFail, "backend speaks protocol version %d, but the oldest supported version is %d", helloResponse.ProtocolVersion, commonbackend.MinimumProtocolVersion)
+		return
+	}
+
+	runner.capabilities = helloResponse.Capabilities
+	runner.record("hello", conformancePass, "backend '%s' (version '%s', protocol version %d)", helloResponse.BackendName, helloResponse.BackendVersion, helloResponse.ProtocolVersion)
+
+	if !slices.Contains(runner.capabilities, commonbackend.CapabilityTCP) {
+		runner.record("capabilities", conformanceFail, "backend doesn't advertise the '%s' capability, which the remaining checks need", commonbackend.CapabilityTCP)
+		return
+	}
+
+	serverParametersResponse, err := requestAs[*commonbackend.CheckParametersResponse](runner, "checkServerParameters", &commonbackend.CheckServerParameters{
+		Type:      "checkServerParameters",
+		Arguments: backendParameters,
+	})
+
+	if err != nil {
+		runner.record("checkServerParameters", conformanceFail, "%s", err.Error())
+	} else if !serverParametersResponse.IsValid {
+		runner.record("checkServerParameters", conformanceFail, "backend rejected the supplied parameters: %s", serverParametersResponse.Message)
+	} else {
+		runner.record("checkServerParameters", conformancePass, "")
+	}
+
+	serverParametersResponse, err = requestAs[*commonbackend.CheckParametersResponse](runner, "checkServerParameters", &commonbackend.CheckServerParameters{
+		Type:      "checkServerParameters",
+		Arguments: []byte("{ this is not valid JSON"),
+	})
+
+	if err != nil {
+		runner.record("checkServerParameters (bad parameters)", conformanceFail, "%s", err.Error())
+	} else if serverParametersResponse.IsValid {
+		runner.record("checkServerParameters (bad parameters)", conformanceWarn, "backend accepted parameters that aren't valid JSON")
+	} else {
+		runner.record("checkServerParameters (bad parameters)", conformancePass, "rejected: %s", serverParametersResponse.Message)
+	}
+
+	startResponse, err := requestAs[*commonbackend.BackendStatusResponse](runner, "start", &commonbackend.Start{
+		Type:      "start",
+		Arguments: backendParameters,
+	})
+
+	if err != nil {
+		runner.record("start", conformanceFail, "%s", err.Error())
+		return
+	}
+
+	if !startResponse.IsRunning {
+		runner.record("start", conformanceFail, "backend failed to start: %s", startResponse.Message)
+		return
+	}
+
+	runner.record("start", conformancePass, "")
+
+	statusResponse, err := requestAs[*commonbackend.BackendStatusResponse](runner, "backendStatusRequest", &commonbackend.BackendStatusRequest{
+		Type: "backendStatusRequest",
+	})
+
+	if err != nil {
+		runner.record("backendStatusRequest", conformanceFail, "%s", err.Error())
+	} else if !statusResponse.IsRunning {
+		runner.record("backendStatusRequest", conformanceFail, "backend says it isn't running after a successful start: %s", statusResponse.Message)
+	} else {
+		runner.record("backendStatusRequest", conformancePass, "")
+	}
+
+	// Backends have to skip over commands they don't understand, so that newer APIs can talk to them.
+	// We send a frame with an unused command ID, and check that the backend still answers the next request.
+	runner.lastRequestID++
+	unknownCommandFrame := []byte{commonbackend.FrameVersion, 0, 0, 0, 5, 0, 0, 0, 0, 255}
+	binary.BigEndian.PutUint32(unknownCommandFrame[5:9], runner.lastRequestID)
+
+	if err := runner.writeFrame(unknownCommandFrame); err != nil {
+		runner.record("unknown command", conformanceFail, "failed to write command: %s", err.Error())
+		return
+	}
+
+	if _, err = requestAs[*commonbackend.BackendStatusResponse](runner, "backendStatusRequest", &commonbackend.BackendStatusRequest{
+		Type: "backendStatusRequest",
+	}); err != nil {
+		runner.record("unknown command", conformanceFail, "backend didn't recover from an unknown command: %s", err.Error())
+		return
+	}
+
+	runner.record("unknown command", conformancePass, "")
+
+	// Proxies
+
+	sourceListener, err := net.Listen("tcp", net.JoinHostPort(sourceIP, "0"))
+
+	if err != nil {
+		runner.record("addProxy", conformanceFail, "failed to listen on the source address: %s", err.Error())
+		return
+	}
+
+	defer sourceListener.Close()
+
+	destPort, err := getFreePort(destHost)
+
+	if err != nil {
+		runner.record("addProxy", conformanceFail, "failed to find a free destination port: %s", err.Error())
+		return
+	}
+
+	sourcePort := uint16(sourceListener.Addr().(*net.TCPAddr).Port)
+	destAddress := net.JoinHostPort(destHost, strconv.Itoa(int(destPort)))
+
+	clientParametersResponse, err := requestAs[*commonbackend.CheckParametersResponse](runner, "checkClientParameters", &commonbackend.CheckClientParameters{
+		Type:       "checkClientParameters",
+		SourceIP:   sourceIP,
+		SourcePort: sourcePort,
+		DestPort:   destPort,
+		Protocol:   "tcp",
+	})
+
+	if err != nil {
+		runner.record("checkClientParameters", conformanceFail, "%s", err.Error())
+	} else if !clientParametersResponse.IsValid {
+		runner.record("checkClientParameters", conformanceFail, "backend rejected a TCP proxy: %s", clientParametersResponse.Message)
+	} else {
+		runner.record("checkClientParameters", conformancePass, "")
+	}
+
+	clientParametersResponse, err = requestAs[*commonbackend.CheckParametersResponse](runner, "checkClientParameters", &commonbackend.CheckClientParameters{
+		Type:       "checkClientParameters",
+		SourceIP:   sourceIP,
+		SourcePort: sourcePort,
+		DestPort:   0,
+		Protocol:   "tcp",
+	})
+
+	if err != nil {
+		runner.record("checkClientParameters (bad parameters)", conformanceFail, "%s", err.Error())
+	} else if clientParametersResponse.IsValid {
+		runner.record("checkClientParameters (bad parameters)", conformanceWarn, "backend accepted a destination port of 0")
+	} else {
+		runner.record("checkClientParameters (bad parameters)", conformancePass, "rejected: %s", clientParametersResponse.Message)
+	}
+
+	addProxyCommand := &commonbackend.AddProxy{
+		Type:       "addProxy",
+		SourceIP:   sourceIP,
+		SourcePort: sourcePort,
+		DestPort:   destPort,
+		Protocol:   "tcp",
+	}
+
+	removeProxyCommand := &commonbackend.RemoveProxy{
+		Type:       "removeProxy",
+		SourceIP:   sourceIP,
+		SourcePort: sourcePort,
+		DestPort:   destPort,
+		Protocol:   "tcp",
+	}
+
+	addProxyResponse, err := requestAs[*commonbackend.ProxyStatusResponse](runner, "addProxy", addProxyCommand)
+
+	if err != nil {
+		runner.record("addProxy", conformanceFail, "%s", err.Error())
+		return
+	}
+
+	if !addProxyResponse.IsActive {
+		runner.record("addProxy", conformanceFail, "backend failed to start the proxy")
+		return
+	}
+
+	runner.record("addProxy", conformancePass, "%s:%d -> remote:%d", sourceIP, sourcePort, destPort)
+
+	// Either rejecting the duplicate or treating it as a no-op is fine, as long as a single removal gets rid of it (checked below)
+	addProxyResponse, err = requestAs[*commonbackend.ProxyStatusResponse](runner, "addProxy", addProxyCommand)
+
+	if err != nil {
+		runner.record("addProxy (duplicate)", conformanceFail, "%s", err.Error())
+	} else if addProxyResponse.IsActive {
+		runner.record("addProxy (duplicate)", conformancePass, "accepted")
+	} else {
+		runner.record("addProxy (duplicate)", conformancePass, "rejected")
+	}
+
+	var trafficConn, trafficSourceConn net.Conn
+
+	if shouldCheckTraffic {
+		trafficConn, trafficSourceConn, err = checkTraffic(sourceListener, destAddress, runner.timeout)
+
+		if err != nil {
+			runner.record("traffic", conformanceFail, "%s", err.Error())
+		} else {
+			defer trafficConn.Close()
+			defer trafficSourceConn.Close()
+
+			runner.record("traffic", conformancePass, "")
+		}
+	} else {
+		runner.record("traffic", conformanceSkip, "traffic checks are disabled")
+	}
+
+	connectionsResponse, err := requestAs[*commonbackend.ProxyConnectionsResponse](runner, "proxyConnectionsRequest", &commonbackend.ProxyConnectionsRequest{
+		Type: "proxyConnectionsRequest",
+	})
+
+	var trafficConnection *commonbackend.ProxyClientConnection
+
+	if err != nil {
+		runner.record("proxyConnectionsRequest", conformanceFail, "%s", err.Error())
+	} else {
+		for _, connection := range connectionsResponse.Connections {
+			if connection.SourcePort == sourcePort && connection.DestPort == destPort && connection.Protocol == "tcp" {
+				trafficConnection = connection
+			}
+		}
+
+		if trafficConn != nil && trafficConnection == nil {
+			runner.record("proxyConnectionsRequest", conformanceFail, "the open connection isn't listed")
+		} else {
+			runner.record("proxyConnectionsRequest", conformancePass, "%d connection(s) listed", len(connectionsResponse.Connections))
+		}
+	}
+
+	if !slices.Contains(runner.capabilities, commonbackend.CapabilityKillConnection) {
+		runner.record("killConnection", conformanceSkip, "backend doesn't advertise the '%s' capability", commonbackend.CapabilityKillConnection)
+	} else if trafficConn == nil || trafficConnection == nil {
+		runner.record("killConnection", conformanceSkip, "needs an open connection, which requires the traffic check to pass")
+	} else {
+		killConnectionResponse, err := requestAs[*commonbackend.KillConnectionResponse](runner, "killConnection", &commonbackend.KillConnection{
+			Type:       "killConnection",
+			SourceIP:   sourceIP,
+			SourcePort: sourcePort,
+			DestPort:   destPort,
+			ClientIP:   trafficConnection.ClientIP,
+			ClientPort: trafficConnection.ClientPort,
+			Protocol:   "tcp",
+		})
+
+		if err != nil {
+			runner.record("killConnection", conformanceFail, "%s", err.Error())
+		} else if !killConnectionResponse.IsKilled {
+			runner.record("killConnection", conformanceFail, "backend failed to kill the connection: %s", killConnectionResponse.Message)
+		} else {
+			trafficConn.SetReadDeadline(time.Now().Add(runner.timeout))
+
+			if _, err := trafficConn.Read(make([]byte, 1)); err == nil {
+				runner.record("killConnection", conformanceFail, "backend says the connection was killed, but it's still open")
+			} else {
+				runner.record("killConnection", conformancePass, "")
+			}
+		}
+	}
+
+	if !slices.Contains(runner.capabilities, commonbackend.CapabilityParametersSchema) {
+		runner.record("parametersSchemaRequest", conformanceSkip, "backend doesn't advertise the '%s' capability", commonbackend.CapabilityParametersSchema)
+	} else {
+		schemaResponse, err := requestAs[*commonbackend.ParametersSchemaResponse](runner, "parametersSchemaRequest", &commonbackend.ParametersSchemaRequest{
+			Type: "parametersSchemaRequest",
+		})
+
+		if err != nil {
+			runner.record("parametersSchemaRequest", conformanceFail, "%s", err.Error())
+		} else if !json.Valid(schemaResponse.Schema) {
+			runner.record("parametersSchemaRequest", conformanceFail, "schema isn't valid JSON")
+		} else {
+			runner.record("parametersSchemaRequest", conformancePass, "")
+		}
+	}
+
+	removeProxyResponse, err := requestAs[*commonbackend.ProxyStatusResponse](runner, "removeProxy", removeProxyCommand)
+
+	if err != nil {
+		runner.record("removeProxy", conformanceFail, "%s", err.Error())
+	} else if removeProxyResponse.IsActive {
+		runner.record("removeProxy", conformanceFail, "backend failed to stop the proxy")
+	} else {
+		runner.record("removeProxy", conformancePass, "")
+	}
+
+	if shouldCheckTraffic {
+		if clientConn, sourceConn, err := checkTraffic(sourceListener, destAddress, time.Second); err == nil {
+			clientConn.Close()
+			sourceConn.Close()
+
+			runner.record("traffic (after removal)", conformanceFail, "proxy is still forwarding traffic (was the duplicate left behind?)")
+		} else {
+			runner.record("traffic (after removal)", conformancePass, "")
+		}
+	}
+
+	removeProxyResponse, err = requestAs[*commonbackend.ProxyStatusResponse](runner, "removeProxy", removeProxyCommand)
+
+	if err != nil {
+		runner.record("removeProxy (unknown proxy)", conformanceFail, "%s", err.Error())
+	} else if !removeProxyResponse.IsActive {
+		runner.record("removeProxy (unknown proxy)", conformanceWarn, "backend says it removed a proxy that doesn't exist")
+	} else {
+		runner.record("removeProxy (unknown proxy)", conformancePass, "rejected")
+	}
+
+	if !slices.Contains(runner.capabilities, commonbackend.CapabilityProxyDrain) {
+		runner.record("removeProxy (drain)", conformanceSkip, "backend doesn't advertise the '%s' capability", commonbackend.CapabilityProxyDrain)
+	} else if addProxyResponse, err := requestAs[*commonbackend.ProxyStatusResponse](runner, "addProxy", addProxyCommand); err != nil || !addProxyResponse.IsActive {
+		runner.record("removeProxy (drain)", conformanceFail, "failed to add the proxy back")
+	} else {
+		drainCommand := *removeProxyCommand
+		drainCommand.DrainTimeout = time.Second
+
+		removeProxyResponse, err := requestAs[*commonbackend.ProxyStatusResponse](runner, "removeProxy", &drainCommand)
+
+		if err != nil {
+			runner.record("removeProxy (drain)", conformanceFail, "%s", err.Error())
+		} else if removeProxyResponse.IsActive {
+			runner.record("removeProxy (drain)", conformanceFail, "backend failed to drain the proxy")
+		} else {
+			runner.record("removeProxy (drain)", conformancePass, "%d connection(s) remaining", removeProxyResponse.RemainingConnections)
+		}
+	}
+
+	if !slices.Contains(runner.capabilities, commonbackend.CapabilityBatchProxies) {
+		runner.record("addProxies", conformanceSkip, "backend doesn't advertise the '%s' capability", commonbackend.CapabilityBatchProxies)
+		runner.record("removeProxies", conformanceSkip, "backend doesn't advertise the '%s' capability", commonbackend.CapabilityBatchProxies)
+	} else {
+		addProxiesResponse, err := requestAs[*commonbackend.ProxiesStatusResponse](runner, "addProxies", &commonbackend.AddProxies{
+			Type:    "addProxies",
+			Proxies: []*commonbackend.AddProxy{addProxyCommand},
+		})
+
+		if err != nil {
+			runner.record("addProxies", conformanceFail, "%s", err.Error())
+		} else if len(addProxiesResponse.Results) != 1 {
+			runner.record("addProxies", conformanceFail, "got %d results for 1 proxy", len(addProxiesResponse.Results))
+		} else if !addProxiesResponse.Results[0].IsActive {
+			runner.record("addProxies", conformanceFail, "backend failed to start the proxy: %s", addProxiesResponse.Results[0].Message)
+		} else {
+			runner.record("addProxies", conformancePass, "")
+		}
+
+		removeProxiesResponse, err := requestAs[*commonbackend.ProxiesStatusResponse](runner, "removeProxies", &commonbackend.RemoveProxies{
+			Type:    "removeProxies",
+			Proxies: []*commonbackend.RemoveProxy{removeProxyCommand},
+		})
+
+		if err != nil {
+			runner.record("removeProxies", conformanceFail, "%s", err.Error())
+		} else if len(removeProxiesResponse.Results) != 1 {
+			runner.record("removeProxies", conformanceFail, "got %d results for 1 proxy", len(removeProxiesResponse.Results))
+		} else if removeProxiesResponse.Results[0].IsActive {
+			runner.record("removeProxies", conformanceFail, "backend failed to stop the proxy: %s", removeProxiesResponse.Results[0].Message)
+		} else {
+			runner.record("removeProxies", conformancePass, "")
+		}
+	}
+
+	stopResponse, err := requestAs[*commonbackend.BackendStatusResponse](runner, "stop", &commonbackend.Stop{
+		Type: "stop",
+	})
+
+	if err != nil {
+		runner.record("stop", conformanceFail, "%s", err.Error())
+	} else if stopResponse.IsRunning {
+		runner.record("stop", conformanceFail, "backend failed to stop: %s", stopResponse.Message)
+	} else {
+		runner.record("stop", conformancePass, "")
+	}
+}
+
+func conformanceEntrypoint(cCtx *cli.Context) error {
+	executablePath := cCtx.Args().Get(0)
+
+	if executablePath == "" {
+		return fmt.Errorf("executable file is not set")
+	}
+
+	if _, err := os.Stat(executablePath); err != nil {
+		return fmt.Errorf("failed to get backend executable information: %s", err.Error())
+	}
+
+	backendParameters := []byte("{}")
+
+	if executableParamsPath := cCtx.String("params-path"); executableParamsPath != "" {
+		var err error
+		backendParameters, err = os.ReadFile(executableParamsPath)
+
+		if err != nil {
+			return fmt.Errorf("could not read backend parameters: %s", err.Error())
+		}
+	}
+
+	timeout := cCtx.Duration("timeout")
+
+	sockPath, sockListener, err := backendlauncher.GetUnixSocket(tempDir)
+
+	if err != nil {
+		return fmt.Errorf("failed to acquire unix socket: %s", err.Error())
+	}
+
+	defer sockListener.Close()
+
+	cmd := exec.Command(executablePath)
+	cmd.Env = append(cmd.Env, fmt.Sprintf("HERMES_API_SOCK=%s", sockPath), fmt.Sprintf("HERMES_LOG_LEVEL=%s", logLevel), fmt.Sprintf("HERMES_MAX_FRAME_SIZE=%d", commonbackend.MaxFrameSize))
+	cmd.Stdout = WriteLogger{UseError: false}
+	cmd.Stderr = WriteLogger{UseError: true}
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start backend: %s", err.Error())
+	}
+
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+
+	sockListener.(*net.UnixListener).SetDeadline(time.Now().Add(timeout))
+	sock, err := sockListener.Accept()
+
+	if err != nil {
+		return fmt.Errorf("backend never connected to the socket: %s", err.Error())
+	}
+
+	defer sock.Close()
+
+	runner := &conformanceRunner{
+		sock:    sock,
+		timeout: timeout,
+	}
+
+	runner.run(backendParameters, cCtx.String("source-ip"), cCtx.String("dest-host"), cCtx.Bool("check-traffic"))
+
+	resultCounts := map[conformanceResult]int{}
+
+	for _, check := range runner.checks {
+		resultCounts[check.Result]++
+
+		if check.Message != "" {
+			fmt.Printf("%s  %-40s %s\n", check.Result, check.Name, check.Message)
+		} else {
+			fmt.Printf("%s  %s\n", check.Result, check.Name)
+		}
+	}
+
+	fmt.Printf("\n%d passed, %d warnings, %d failed, %d skipped\n", resultCounts[conformancePass], resultCounts[conformanceWarn], resultCounts[conformanceFail], resultCounts[conformanceSkip])
+
+	if resultCounts[conformanceFail] != 0 {
+		return fmt.Errorf("backend failed %d conformance check(s)", resultCounts[conformanceFail])
+	}
+
+	return nil
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"net"
 	"os"
 	"os/exec"
 	"strings"
@@ -44,6 +45,47 @@ func (writer WriteLogger) Write(p []byte) (n int, err error) {
 	return len(p), err
 }
 
+// Prints out log messages and events sent by the backend. Returns false if the message isn't one of those
+func printBackendEvent(commandType string, commandRaw interface{}) bool {
+	switch command := commandRaw.(type) {
+	case *commonbackend.LogMessage:
+		level, err := log.ParseLevel(command.Level)
+
+		if err != nil {
+			level = log.InfoLevel
+		}
+
+		keyvals := make([]interface{}, 0, len(command.Fields)*2)
+
+		for _, field := range command.Fields {
+			keyvals = append(keyvals, field.Key, field.Value)
+		}
+
+		log.Log(level, "application: "+command.Message, keyvals...)
+	case *commonbackend.ConnectionOpened, *commonbackend.ConnectionClosed, *commonbackend.ProxyFailed, *commonbackend.UpstreamDisconnected:
+		log.Infof("application sent event: %s", commandType)
+	default:
+		return false
+	}
+
+	return true
+}
+
+// Reads the next response from the backend, printing out any log messages and events that arrive before it
+func readResponse(sock net.Conn) (string, interface{}, error) {
+	for {
+		commandType, commandRaw, err := commonbackend.Unmarshal(sock)
+
+		if err != nil {
+			return "", nil, err
+		}
+
+		if !printBackendEvent(commandType, commandRaw) {
+			return commandType, commandRaw, nil
+		}
+	}
+}
+
 var (
 	tempDir  string
 	logLevel string
@@ -58,7 +100,8 @@ func entrypoint(cCtx *cli.Context) error {
 
 	executableParamsPath := cCtx.String("params-path")
 
-	if executablePath == "" {
+	// This isn't marked as required in the flags, as that would also make it required for the subcommands
+	if executableParamsPath == "" {
 		return fmt.Errorf("executable parameters is not set")
 	}
 
@@ -118,6 +161,42 @@ func entrypoint(cCtx *cli.Context) error {
 
 			defer sock.Close()
 
+			helloMarshalledCommand, err := commonbackend.Marshal("hello", &commonbackend.Hello{
+				Type:            "hello",
+				ProtocolVersion: commonbackend.ProtocolVersion,
+			})
+
+			if err != nil {
+				log.Errorf("failed to generate hello command: %s", err.Error())
+				continue
+			}
+
+			if _, err = sock.Write(helloMarshalledCommand); err != nil {
+				log.Errorf("failed to write to socket: %s", err.Error())
+				continue
+			}
+
+			commandType, commandRaw, err := readResponse(sock)
+
+			if err != nil {
+				log.Errorf("failed to read from/unmarshal from socket: %s", err.Error())
+				continue
+			}
+
+			helloResponse, ok := commandRaw.(*commonbackend.HelloResponse)
+
+			if !ok {
+				log.Errorf("recieved commandType '%s', expecting 'helloResponse'", commandType)
+				continue
+			}
+
+			if helloResponse.ProtocolVersion < commonbackend.MinimumProtocolVersion {
+				log.Errorf("backend speaks protocol version %d, but the oldest supported version is %d", helloResponse.ProtocolVersion, commonbackend.MinimumProtocolVersion)
+				continue
+			}
+
+			log.Infof("connected to backend '%s' (version '%s', protocol version %d, capabilities: %s)", helloResponse.BackendName, helloResponse.BackendVersion, helloResponse.ProtocolVersion, strings.Join(helloResponse.Capabilities, ", "))
+
 			startCommand := &commonbackend.Start{
 				Type:      "start",
 				Arguments: backendParameters,
@@ -135,7 +214,7 @@ func entrypoint(cCtx *cli.Context) error {
 				continue
 			}
 
-			commandType, commandRaw, err := commonbackend.Unmarshal(sock)
+			commandType, commandRaw, err = readResponse(sock)
 
 			if err != nil {
 				log.Errorf("failed to read from/unmarshal from socket: %s", err.Error())
@@ -196,7 +275,7 @@ func entrypoint(cCtx *cli.Context) error {
 					continue
 				}
 
-				commandType, commandRaw, err := commonbackend.Unmarshal(sock)
+				commandType, commandRaw, err := readResponse(sock)
 
 				if err != nil {
 					log.Errorf("failed to read from/unmarshal from socket: %s", err.Error())
@@ -233,9 +312,17 @@ func entrypoint(cCtx *cli.Context) error {
 				log.Info("successfully initialized all proxies")
 			}
 
-			log.Debug("entering infinite keepalive loop...")
+			log.Debug("entering event loop...")
 
 			for {
+				commandType, _, err := readResponse(sock)
+
+				if err != nil {
+					log.Warnf("failed to read from/unmarshal from socket: %s", err.Error())
+					break
+				}
+
+				log.Warnf("recieved unexpected commandType '%s'", commandType)
 			}
 		}
 	}()
@@ -255,7 +342,7 @@ func entrypoint(cCtx *cli.Context) error {
 		// TODO: can we reuse cmd?
 
 		cmd := exec.Command(executablePath)
-		cmd.Env = append(cmd.Env, fmt.Sprintf("HERMES_API_SOCK=%s", sockPath), fmt.Sprintf("HERMES_LOG_LEVEL=%s", logLevel))
+		cmd.Env = append(cmd.Env, fmt.Sprintf("HERMES_API_SOCK=%s", sockPath), fmt.Sprintf("HERMES_LOG_LEVEL=%s", logLevel), fmt.Sprintf("HERMES_MAX_FRAME_SIZE=%d", commonbackend.MaxFrameSize))
 
 		cmd.Stdout = stdout
 		cmd.Stderr = stderr
@@ -314,10 +401,9 @@ func main() {
 		Action: entrypoint,
 		Flags: []cli.Flag{
 			&cli.StringFlag{
-				Name:     "params-path",
-				Aliases:  []string{"params", "pp"},
-				Usage:    "file containing the parameters that are sent to the backend",
-				Required: true,
+				Name:    "params-path",
+				Aliases: []string{"params", "pp"},
+				Usage:   "file containing the parameters that are sent to the backend",
 			},
 			&cli.StringFlag{
 				Name:    "proxies",
@@ -325,6 +411,40 @@ func main() {
 				Usage:   "file that contains the list of proxies to setup in JSON format",
 			},
 		},
+		Commands: []*cli.Command{
+			{
+				Name:      "conformance",
+				Usage:     "runs a backend through the backend protocol, and reports on how well it conforms to it",
+				ArgsUsage: "<backend executable>",
+				Action:    conformanceEntrypoint,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "params-path",
+						Aliases: []string{"params", "pp"},
+						Usage:   "file containing the parameters that are sent to the backend (defaults to '{}')",
+					},
+					&cli.DurationFlag{
+						Name:  "timeout",
+						Usage: "how long to wait for each response from the backend",
+						Value: 10 * time.Second,
+					},
+					&cli.BoolFlag{
+						Name:  "check-traffic",
+						Usage: "check that traffic actually gets forwarded (the backend has to be able to reach the source IP)",
+					},
+					&cli.StringFlag{
+						Name:  "source-ip",
+						Usage: "IP to listen on for the proxy source",
+						Value: "127.0.0.1",
+					},
+					&cli.StringFlag{
+						Name:  "dest-host",
+						Usage: "host to connect to when checking traffic sent to the proxy destination",
+						Value: "127.0.0.1",
+					},
+				},
+			},
+		},
 	}
 
 	if err := app.Run(os.Args); err != nil {
@@ -1,13 +1,13 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net"
 	"os"
-	"strconv"
-	"strings"
 	"sync"
 	"time"
 
@@ -27,11 +27,19 @@ type SSHListener struct {
 }
 
 type SSHBackend struct {
-	config         *SSHBackendData
-	conn           *ssh.Client
-	clients        []*commonbackend.ProxyClientConnection
-	proxies        []*SSHListener
+	helper      *backendutil.BackendApplicationHelper
+	connections *backendutil.ConnectionTracker
+
+	// Guards the connection state below, which gets swapped out by starting, stopping, and reconnecting
+	connLock sync.Mutex
+	config   *SSHBackendData
+	conn     *ssh.Client
+	resolver *backendutil.SourceResolver
+	// Bumped every time the backend is started or stopped, so that an old reconnect loop knows to give up
+	connGeneration uint64
+
 	arrayPropMutex sync.Mutex
+	proxies        []*SSHListener
 }
 
 type SSHBackendData struct {
@@ -40,9 +48,63 @@ type SSHBackendData struct {
 	Username    string   `json:"username" validate:"required"`
 	PrivateKey  string   `json:"privateKey" validate:"required"`
 	ListenOnIPs []string `json:"listenOnIPs"`
+
+	// How long to cache resolved source hostnames for. If unset, they're resolved on every connection.
+	SourceDNSCacheSeconds uint `json:"sourceDNSCacheSeconds"`
+	// How long a connection can go without any data being sent either way before it's closed. If unset, connections never time out.
+	IdleTimeoutSeconds uint `json:"idleTimeoutSeconds"`
 }
 
+// JSON Schema for SSHBackendData. Keep this in sync with the struct above.
+const sshBackendDataSchema = `{
+  "$schema": "https://json-schema.org/draft/2020-12/schema",
+  "type": "object",
+  "properties": {
+    "ip": {
+      "type": "string",
+      "description": "IP address or hostname of the SSH server"
+    },
+    "port": {
+      "type": "integer",
+      "minimum": 1,
+      "maximum": 65535,
+      "description": "Port the SSH server is listening on"
+    },
+    "username": {
+      "type": "string",
+      "description": "User to log in as"
+    },
+    "privateKey": {
+      "type": "string",
+      "writeOnly": true,
+      "description": "Private key to authenticate with, in OpenSSH or PEM format"
+    },
+    "listenOnIPs": {
+      "type": "array",
+      "items": {
+        "type": "string"
+      },
+      "description": "IPs on the SSH server to listen on. Defaults to 0.0.0.0"
+    },
+    "sourceDNSCacheSeconds": {
+      "type": "integer",
+      "minimum": 0,
+      "description": "How long to cache resolved source hostnames for, in seconds. Defaults to resolving on every connection"
+    },
+    "idleTimeoutSeconds": {
+      "type": "integer",
+      "minimum": 0,
+      "description": "How long a connection can go without any traffic before it's closed, in seconds. Defaults to never closing idle connections"
+    }
+  },
+  "required": ["ip", "port", "username", "privateKey"]
+}`
+
 func (backend *SSHBackend) StartBackend(bytes []byte) (bool, error) {
+	return backend.StartBackendContext(context.Background(), bytes)
+}
+
+func (backend *SSHBackend) StartBackendContext(ctx context.Context, bytes []byte) (bool, error) {
 	log.Info("SSHBackend is initializing...")
 	var backendData SSHBackendData
 
@@ -54,46 +116,114 @@ func (backend *SSHBackend) StartBackend(bytes []byte) (bool, error) {
 		return false, err
 	}
 
-	backend.config = &backendData
-
-	if len(backend.config.ListenOnIPs) == 0 {
-		backend.config.ListenOnIPs = []string{"0.0.0.0"}
+	if len(backendData.ListenOnIPs) == 0 {
+		backendData.ListenOnIPs = []string{"0.0.0.0"}
 	}
 
-	signer, err := ssh.ParsePrivateKey([]byte(backendData.PrivateKey))
+	conn, err := dialSSH(ctx, &backendData)
 
 	if err != nil {
 		return false, err
 	}
 
-	auth := ssh.PublicKeys(signer)
+	backend.connLock.Lock()
+
+	// Starting again replaces the old connection, and the old reconnect loop along with it
+	if backend.conn != nil {
+		backend.conn.Close()
+	}
+
+	backend.config = &backendData
+	backend.conn = conn
+	backend.resolver = backendutil.NewSourceResolver(time.Duration(backendData.SourceDNSCacheSeconds) * time.Second)
+	backend.connGeneration++
+	generation := backend.connGeneration
+
+	backend.connLock.Unlock()
+
+	log.Info("SSHBackend has initialized successfully.")
+	go backend.backendDisconnectHandler(conn, generation)
+
+	return true, nil
+}
+
+// Gets the current connection state, all at once. conn is nil if we aren't connected.
+func (backend *SSHBackend) connection() (*ssh.Client, *SSHBackendData, *backendutil.SourceResolver) {
+	backend.connLock.Lock()
+	defer backend.connLock.Unlock()
+
+	return backend.conn, backend.config, backend.resolver
+}
+
+// Connects to the SSH server in backendData. Same as ssh.Dial, but gives up once the context is cancelled.
+func dialSSH(ctx context.Context, backendData *SSHBackendData) (*ssh.Client, error) {
+	signer, err := ssh.ParsePrivateKey([]byte(backendData.PrivateKey))
+
+	if err != nil {
+		return nil, err
+	}
 
 	config := &ssh.ClientConfig{
 		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
 		User:            backendData.Username,
 		Auth: []ssh.AuthMethod{
-			auth,
+			ssh.PublicKeys(signer),
 		},
 	}
 
-	conn, err := ssh.Dial("tcp", fmt.Sprintf("%s:%d", backendData.IP, backendData.Port), config)
+	address := fmt.Sprintf("%s:%d", backendData.IP, backendData.Port)
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", address)
 
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 
-	backend.conn = conn
+	// The SSH handshake doesn't take a context, so we close the connection out from under it instead
+	stopWatchingContext := context.AfterFunc(ctx, func() {
+		conn.Close()
+	})
 
-	log.Info("SSHBackend has initialized successfully.")
-	go backend.backendDisconnectHandler()
+	sshConn, channels, requests, err := ssh.NewClientConn(conn, address, config)
 
-	return true, nil
+	if !stopWatchingContext() {
+		if err == nil {
+			sshConn.Close()
+		}
+
+		return nil, ctx.Err()
+	}
+
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return ssh.NewClient(sshConn, channels, requests), nil
+}
+
+func (backend *SSHBackend) StopBackendContext(ctx context.Context) (bool, error) {
+	// If the API has already given up on stopping us, it thinks we're still running, so we stay that way
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	return backend.StopBackend()
 }
 
 func (backend *SSHBackend) StopBackend() (bool, error) {
-	err := backend.conn.Close()
+	backend.connLock.Lock()
+	conn := backend.conn
+	backend.conn = nil
+	backend.connGeneration++
+	backend.connLock.Unlock()
+
+	if conn == nil {
+		return true, nil
+	}
 
-	if err != nil {
+	if err := conn.Close(); err != nil {
 		return false, err
 	}
 
@@ -101,176 +231,116 @@ func (backend *SSHBackend) StopBackend() (bool, error) {
 }
 
 func (backend *SSHBackend) GetBackendStatus() (bool, error) {
-	return backend.conn != nil, nil
+	conn, _, _ := backend.connection()
+	return conn != nil, nil
 }
 
 func (backend *SSHBackend) StartProxy(command *commonbackend.AddProxy) (bool, error) {
-	listenerObject := &SSHListener{
+	return backend.StartProxyContext(context.Background(), command)
+}
+
+func (backend *SSHBackend) StartProxyContext(ctx context.Context, command *commonbackend.AddProxy) (bool, error) {
+	conn, config, resolver := backend.connection()
+
+	if conn == nil {
+		return false, fmt.Errorf("not connected to the SSH server")
+	}
+
+	listeners, err := backend.listen(ctx, conn, config, resolver, command)
+
+	if err != nil {
+		return false, err
+	}
+
+	backend.arrayPropMutex.Lock()
+	backend.proxies = append(backend.proxies, &SSHListener{
 		SourceIP:   command.SourceIP,
 		SourcePort: command.SourcePort,
 		DestPort:   command.DestPort,
 		Protocol:   command.Protocol,
-		Listeners:  []net.Listener{},
-	}
+		Listeners:  listeners,
+	})
+	backend.arrayPropMutex.Unlock()
+
+	return true, nil
+}
+
+// Listens for the proxy on every IP we're configured to listen on, and forwards the connections to the source
+func (backend *SSHBackend) listen(ctx context.Context, conn *ssh.Client, config *SSHBackendData, resolver *backendutil.SourceResolver, command *commonbackend.AddProxy) ([]net.Listener, error) {
+	listeners := []net.Listener{}
 
-	for _, ipListener := range backend.config.ListenOnIPs {
+	for _, ipListener := range config.ListenOnIPs {
 		ip := net.TCPAddr{
 			IP:   net.ParseIP(ipListener),
 			Port: int(command.DestPort),
 		}
 
-		listener, err := backend.conn.ListenTCP(&ip)
+		var listener net.Listener
+		err := ctx.Err()
+
+		// Don't bother setting up any more listeners if the API has already given up on this proxy
+		if err == nil {
+			listener, err = conn.ListenTCP(&ip)
+		}
 
 		if err != nil {
 			// Incase we error out, we clean up all the other listeners
-			for _, listener := range listenerObject.Listeners {
-				err = listener.Close()
-
-				if err != nil {
+			for _, listener := range listeners {
+				if err := listener.Close(); err != nil {
 					log.Warnf("failed to close listener upon failure cleanup: %s", err.Error())
 				}
 			}
 
-			return false, err
+			return nil, err
 		}
 
-		listenerObject.Listeners = append(listenerObject.Listeners, listener)
+		listeners = append(listeners, listener)
 
 		go func() {
 			for {
 				forwardedConn, err := listener.Accept()
 
 				if err != nil {
-					log.Warnf("failed to accept listener connection: %s", err.Error())
-
-					if err.Error() == "EOF" {
+					if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
 						return
 					}
 
+					log.Warnf("failed to accept listener connection: %s", err.Error())
 					continue
 				}
 
-				sourceConn, err := net.Dial("tcp", fmt.Sprintf("%s:%d", command.SourceIP, command.SourcePort))
+				sourceConn, err := resolver.Dial("tcp", command.SourceIP, command.SourcePort)
 
 				if err != nil {
 					log.Warnf("failed to dial source connection: %s", err.Error())
+					forwardedConn.Close()
 					continue
 				}
 
-				clientIPAndPort := forwardedConn.RemoteAddr().String()
-				clientIP := clientIPAndPort[:strings.LastIndex(clientIPAndPort, ":")]
-				clientPort, err := strconv.Atoi(clientIPAndPort[strings.LastIndex(clientIPAndPort, ":")+1:])
+				advertisedConn, err := backendutil.NewProxyClientConnection(forwardedConn.RemoteAddr(), command)
 
 				if err != nil {
-					log.Warnf("failed to parse client port: %s", err.Error())
+					log.Warnf("failed to parse client address: %s", err.Error())
+					forwardedConn.Close()
+					sourceConn.Close()
 					continue
 				}
 
-				advertisedConn := &commonbackend.ProxyClientConnection{
-					SourceIP:   command.SourceIP,
-					SourcePort: command.SourcePort,
-					DestPort:   command.DestPort,
-					ClientIP:   clientIP,
-					ClientPort: uint16(clientPort),
-
-					// FIXME (imterah): shouldn't protocol be in here?
-					// Protocol:   command.Protocol,
-				}
-
-				backend.arrayPropMutex.Lock()
-				backend.clients = append(backend.clients, advertisedConn)
-				backend.arrayPropMutex.Unlock()
-
-				cleanupJob := func() {
-					defer backend.arrayPropMutex.Unlock()
-					err := sourceConn.Close()
-
-					if err != nil {
-						log.Warnf("failed to close source connection: %s", err.Error())
-					}
-
-					err = forwardedConn.Close()
-
-					if err != nil {
-						log.Warnf("failed to close forwarded/proxied connection: %s", err.Error())
-					}
-
-					backend.arrayPropMutex.Lock()
-
-					for clientIndex, clientInstance := range backend.clients {
-						// Check if memory addresses are equal for the pointer
-						if clientInstance == advertisedConn {
-							// Splice out the clientInstance by clientIndex
-
-							// TODO: change approach. It works but it's a bit wonky imho
-							// I asked AI to do this as it's a relatively simple task and I forgot how to do this effectively
-							backend.clients = append(backend.clients[:clientIndex], backend.clients[clientIndex+1:]...)
-							return
-						}
-					}
-
-					log.Warn("failed to delete client from clients metadata: couldn't find client in the array")
-				}
-
-				sourceBuffer := make([]byte, 65535)
-				forwardedBuffer := make([]byte, 65535)
-
-				go func() {
-					defer cleanupJob()
-
-					for {
-						len, err := forwardedConn.Read(forwardedBuffer)
-
-						if err != nil {
-							if err.Error() != "EOF" && !errors.Is(err, net.ErrClosed) {
-								log.Errorf("failed to read from forwarded connection: %s", err.Error())
-							}
-
-							return
-						}
-
-						if _, err = sourceConn.Write(forwardedBuffer[:len]); err != nil {
-							if err.Error() != "EOF" && !errors.Is(err, net.ErrClosed) {
-								log.Errorf("failed to write to source connection: %s", err.Error())
-							}
-
-							return
-						}
-					}
-				}()
-
-				go func() {
-					defer cleanupJob()
-
-					for {
-						len, err := sourceConn.Read(sourceBuffer)
-
-						if err != nil {
-							if err.Error() != "EOF" && !errors.Is(err, net.ErrClosed) {
-								log.Errorf("failed to read from source connection: %s", err.Error())
-							}
-
-							return
-						}
-
-						if _, err = forwardedConn.Write(sourceBuffer[:len]); err != nil {
-							if err.Error() != "EOF" && !errors.Is(err, net.ErrClosed) {
-								log.Errorf("failed to write to forwarded connection: %s", err.Error())
-							}
-
-							return
-						}
-					}
-				}()
+				backend.connections.StartRelay(forwardedConn, sourceConn, advertisedConn, time.Duration(config.IdleTimeoutSeconds)*time.Second)
 			}
 		}()
 	}
 
-	backend.arrayPropMutex.Lock()
-	backend.proxies = append(backend.proxies, listenerObject)
-	backend.arrayPropMutex.Unlock()
+	return listeners, nil
+}
 
-	return true, nil
+func (backend *SSHBackend) StopProxyContext(ctx context.Context, command *commonbackend.RemoveProxy) (bool, error) {
+	// Same as in StopBackendContext
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	return backend.StopProxy(command)
 }
 
 func (backend *SSHBackend) StopProxy(command *commonbackend.RemoveProxy) (bool, error) {
@@ -300,10 +370,24 @@ func (backend *SSHBackend) StopProxy(command *commonbackend.RemoveProxy) (bool,
 }
 
 func (backend *SSHBackend) GetAllClientConnections() []*commonbackend.ProxyClientConnection {
-	defer backend.arrayPropMutex.Unlock()
-	backend.arrayPropMutex.Lock()
+	return backend.connections.Connections()
+}
+
+func (backend *SSHBackend) GetParametersSchema() []byte {
+	return []byte(sshBackendDataSchema)
+}
+
+func (backend *SSHBackend) KillConnection(command *commonbackend.KillConnection) (bool, error) {
+	return backend.connections.KillConnection(command)
+}
+
+func (backend *SSHBackend) DrainProxy(command *commonbackend.RemoveProxy) (uint32, error) {
+	// Stopping the proxy closes the listeners, so no new connections get accepted, but existing clients are left alone
+	if _, err := backend.StopProxy(command); err != nil {
+		return 0, err
+	}
 
-	return backend.clients
+	return backend.connections.DrainProxy(command), nil
 }
 
 func (backend *SSHBackend) CheckParametersForConnections(clientParameters *commonbackend.CheckClientParameters) *commonbackend.CheckParametersResponse {
@@ -341,54 +425,64 @@ func (backend *SSHBackend) CheckParametersForBackend(arguments []byte) *commonba
 	}
 }
 
-func (backend *SSHBackend) backendDisconnectHandler() {
+// Reconnects to the SSH server whenever the connection drops, and sets the proxies back up. Gives up once the backend
+// is started or stopped again, as that replaces the connection this was started for.
+func (backend *SSHBackend) backendDisconnectHandler(conn *ssh.Client, generation uint64) {
 	for {
-		if backend.conn != nil {
-			err := backend.conn.Wait()
+		err := conn.Wait()
 
-			if err == nil || err.Error() != "EOF" {
-				continue
-			}
-		}
+		backend.connLock.Lock()
 
-		log.Info("Disconnected from the remote SSH server. Attempting to reconnect in 5 seconds...")
-
-		time.Sleep(5 * time.Second)
+		if backend.connGeneration != generation {
+			backend.connLock.Unlock()
+			return
+		}
 
 		// Make the connection nil to accurately report our status incase GetBackendStatus is called
 		backend.conn = nil
+		config := backend.config
+		resolver := backend.resolver
 
-		// Use the last half of the code from the main initialization
-		signer, err := ssh.ParsePrivateKey([]byte(backend.config.PrivateKey))
+		backend.connLock.Unlock()
 
 		if err != nil {
-			log.Errorf("Failed to parse private key: %s", err.Error())
-			return
+			log.Debugf("SSH connection closed with error: %s", err.Error())
 		}
 
-		auth := ssh.PublicKeys(signer)
+		log.Info("Disconnected from the remote SSH server. Attempting to reconnect in 5 seconds...")
 
-		config := &ssh.ClientConfig{
-			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-			User:            backend.config.Username,
-			Auth: []ssh.AuthMethod{
-				auth,
-			},
+		if err := backend.helper.SendUpstreamDisconnected("disconnected from the remote SSH server"); err != nil {
+			log.Debugf("failed to send upstream disconnected event: %s", err.Error())
 		}
 
-		conn, err := ssh.Dial("tcp", fmt.Sprintf("%s:%d", backend.config.IP, backend.config.Port), config)
+		time.Sleep(5 * time.Second)
+
+		conn, err = dialSSH(context.Background(), config)
 
 		if err != nil {
 			log.Errorf("Failed to connect to the server: %s", err.Error())
 			return
 		}
 
+		backend.connLock.Lock()
+
+		if backend.connGeneration != generation {
+			backend.connLock.Unlock()
+			conn.Close()
+
+			return
+		}
+
 		backend.conn = conn
+		backend.connLock.Unlock()
 
 		log.Info("SSHBackend has reconnected successfully. Attempting to set up proxies again...")
 
+		// The proxies are set back up in place, so that they can still be stopped while this is going on
+		backend.arrayPropMutex.Lock()
+
 		for _, proxy := range backend.proxies {
-			ok, err := backend.StartProxy(&commonbackend.AddProxy{
+			listeners, err := backend.listen(context.Background(), conn, config, resolver, &commonbackend.AddProxy{
 				SourceIP:   proxy.SourceIP,
 				SourcePort: proxy.SourcePort,
 				DestPort:   proxy.DestPort,
@@ -397,15 +491,19 @@ func (backend *SSHBackend) backendDisconnectHandler() {
 
 			if err != nil {
 				log.Errorf("Failed to set up proxy: %s", err.Error())
-				continue
-			}
 
-			if !ok {
-				log.Errorf("Failed to set up proxy: OK status is false")
+				if err := backend.helper.SendProxyFailed(proxy.SourceIP, proxy.SourcePort, proxy.DestPort, proxy.Protocol, err.Error()); err != nil {
+					log.Debugf("failed to send proxy failed event: %s", err.Error())
+				}
+
 				continue
 			}
+
+			proxy.Listeners = listeners
 		}
 
+		backend.arrayPropMutex.Unlock()
+
 		log.Info("SSHBackend has reinitialized and restored state successfully.")
 	}
 }
@@ -435,6 +533,14 @@ func main() {
 	backend := &SSHBackend{}
 
 	application := backendutil.NewHelper(backend)
+	backend.connections = backendutil.NewConnectionTracker(application)
+	application.Name = "ssh"
+	application.Version = "1.0.0"
+	application.Capabilities = []string{commonbackend.CapabilityTCP, commonbackend.CapabilityConnectionEvents, commonbackend.CapabilityUnixSockets}
+	application.ForwardLogs()
+
+	backend.helper = application
+
 	err := application.Start()
 
 	if err != nil {
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"testing"
+
+	"git.terah.dev/imterah/hermes/backend/backendutil/testkit"
+	"git.terah.dev/imterah/hermes/backend/commonbackend"
+)
+
+func TestSSHBackendChecksServerParameters(t *testing.T) {
+	client := testkit.New(t, &SSHBackend{})
+
+	parameters := []struct {
+		name      string
+		arguments string
+		isValid   bool
+	}{
+		{"valid", `{"ip": "192.168.0.1", "port": 22, "username": "hermes", "privateKey": "key"}`, true},
+		{"valid with options", `{"ip": "ssh.example.com", "port": 2222, "username": "hermes", "privateKey": "key", "listenOnIPs": ["127.0.0.1"], "idleTimeoutSeconds": 60}`, true},
+		{"not json", `ssh://hermes@192.168.0.1`, false},
+		{"empty", `{}`, false},
+		{"missing private key", `{"ip": "192.168.0.1", "port": 22, "username": "hermes"}`, false},
+		{"missing port", `{"ip": "192.168.0.1", "username": "hermes", "privateKey": "key"}`, false},
+		{"port out of range", `{"ip": "192.168.0.1", "port": 65536, "username": "hermes", "privateKey": "key"}`, false},
+		{"wrong type", `{"ip": "192.168.0.1", "port": "22", "username": "hermes", "privateKey": "key"}`, false},
+	}
+
+	for _, test := range parameters {
+		response, err := client.CheckServerParameters([]byte(test.arguments))
+
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if response.IsValid != test.isValid {
+			t.Errorf("%s: expected valid to be %t (got %t: %s)", test.name, test.isValid, response.IsValid, response.Message)
+		}
+
+		if !response.IsValid && response.Message == "" {
+			t.Errorf("%s: parameters were rejected without a message", test.name)
+		}
+	}
+}
+
+func TestSSHBackendChecksClientParameters(t *testing.T) {
+	client := testkit.New(t, &SSHBackend{})
+
+	for _, protocol := range []string{"tcp", "udp"} {
+		response, err := client.CheckClientParameters(&commonbackend.CheckClientParameters{
+			SourceIP:   "127.0.0.1",
+			SourcePort: 8080,
+			DestPort:   80,
+			Protocol:   protocol,
+		})
+
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if response.IsValid != (protocol == "tcp") {
+			t.Errorf("%s: got valid = %t (%s)", protocol, response.IsValid, response.Message)
+		}
+	}
+}
+
+func TestSSHBackendRejectsInvalidStartParameters(t *testing.T) {
+	client := testkit.New(t, &SSHBackend{})
+
+	startResponse, err := client.Start([]byte(`{"ip": "192.168.0.1", "port": 22, "username": "hermes"}`))
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if startResponse.IsRunning || startResponse.StatusCode != commonbackend.StatusFailure {
+		t.Errorf("backend started without a private key")
+	}
+
+	// Nothing is connected, so there is nowhere to listen for the proxy
+	proxyStatus, err := client.AddProxy(&commonbackend.AddProxy{
+		SourceIP:   "127.0.0.1",
+		SourcePort: 8080,
+		DestPort:   80,
+		Protocol:   "tcp",
+	})
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if proxyStatus.IsActive {
+		t.Errorf("proxy was started without a connection to the SSH server")
+	}
+}